@@ -0,0 +1,383 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sonare.media/internal/catalog"
+	"sonare.media/internal/config"
+	"sonare.media/internal/logging"
+)
+
+// trackFields are the form fields that set a track's metadata, as sent by
+// the admin CLI and accepted by handleAdminTracks.
+var trackFields = []string{
+	"palette", "phase", "variant", "title", "duration", "bpm", "energy",
+	"vocal_ratio", "weight", "license_name", "license_url", "license_attribution",
+}
+
+// requireAdminToken lets through requests bearing token.
+func requireAdminToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="sonare admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleAdminTracks manages the catalog on the admin listener:
+//
+//	GET    /admin/tracks         the catalog, with problems and retired files
+//	POST   /admin/tracks         upload a new track
+//	PUT    /admin/tracks/<file>  replace its audio, update its metadata, or both
+//	DELETE /admin/tracks/<file>  retire it
+//
+// Uploads and updates are multipart forms with the audio in an "audio"
+// part and metadata in trackFields. Each edit rescans the catalog, so
+// changes are served by the time the response is sent.
+func handleAdminTracks(index *catalog.Index, lib *catalog.Library, maxUpload int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, hasFile := strings.CutPrefix(r.URL.Path, "/admin/tracks/")
+		switch {
+		case !hasFile && r.Method == http.MethodGet:
+			cat := index.Catalog()
+			writeAdminJSON(w, http.StatusOK, map[string]interface{}{
+				"tracks":    cat.Tracks,
+				"problems":  cat.Problems,
+				"unmatched": cat.Unmatched,
+				"retired":   cat.Retired,
+			})
+			return
+		case !hasFile && r.Method == http.MethodPost, hasFile && r.Method == http.MethodPut:
+		case hasFile && r.Method == http.MethodDelete:
+			if err := lib.Retire(file); err != nil {
+				adminError(w, r, err)
+				return
+			}
+			logging.FromContext(r.Context()).Info("ADMIN: track retired", "file", file)
+			refreshAfterEdit(index, catalog.ManifestName)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Room for the metadata fields and multipart framing.
+		r.Body = http.MaxBytesReader(w, r.Body, maxUpload+1<<20)
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			if errors.As(err, new(*http.MaxBytesError)) {
+				adminError(w, r, catalog.ErrTooLarge)
+				return
+			}
+			http.Error(w, "Expected a multipart form", http.StatusBadRequest)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+		audio, _, err := r.FormFile("audio")
+		if err != nil && !errors.Is(err, http.ErrMissingFile) {
+			http.Error(w, "Unreadable audio part", http.StatusBadRequest)
+			return
+		}
+		if audio != nil {
+			defer audio.Close()
+		}
+		form := r.MultipartForm.Value
+		var edit catalog.Track
+		if err := applyTrackForm(form, &edit); err != nil {
+			adminError(w, r, fmt.Errorf("%w: %v", catalog.ErrInvalid, err))
+			return
+		}
+
+		if !hasFile {
+			if audio == nil {
+				http.Error(w, "Missing audio part", http.StatusBadRequest)
+				return
+			}
+			t, err := lib.Add(edit, audio)
+			if err != nil {
+				adminError(w, r, err)
+				return
+			}
+			logging.FromContext(r.Context()).Info("ADMIN: track added", "file", t.File)
+			refreshAfterEdit(index, t.File, catalog.ManifestName)
+			writeAdminJSON(w, http.StatusCreated, t)
+			return
+		}
+
+		if audio == nil && len(form) == 0 {
+			http.Error(w, "Nothing to change: send audio, metadata fields or both", http.StatusBadRequest)
+			return
+		}
+		var (
+			t       catalog.Track
+			written []string
+		)
+		if audio != nil {
+			if t, err = lib.Replace(file, audio); err != nil {
+				adminError(w, r, err)
+				return
+			}
+			logging.FromContext(r.Context()).Info("ADMIN: track audio replaced", "file", file)
+			written = append(written, file, catalog.ManifestName)
+		}
+		if len(form) > 0 {
+			if t, err = lib.Update(file, func(t *catalog.Track) { applyTrackForm(form, t) }); err != nil {
+				adminError(w, r, err)
+				return
+			}
+			logging.FromContext(r.Context()).Info("ADMIN: track updated", "file", file)
+			written = append(written, catalog.ManifestName)
+		}
+		refreshAfterEdit(index, written...)
+		writeAdminJSON(w, http.StatusOK, t)
+	})
+}
+
+// applyTrackForm sets the metadata fields present in form; an empty value
+// clears the field.
+func applyTrackForm(form url.Values, t *catalog.Track) error {
+	for _, key := range slices.Sorted(maps.Keys(form)) {
+		v := strings.TrimSpace(form.Get(key))
+		var err error
+		number := func(dst *float64) {
+			*dst = 0
+			if v != "" {
+				*dst, err = strconv.ParseFloat(v, 64)
+			}
+		}
+		switch key {
+		case "palette":
+			t.Palette = v
+		case "phase":
+			t.Phase = v
+		case "variant":
+			t.Variant = v
+		case "title":
+			t.Title = v
+		case "duration":
+			t.Duration = 0
+			if v != "" {
+				t.Duration, err = time.ParseDuration(v)
+			}
+		case "bpm":
+			number(&t.BPM)
+		case "energy":
+			number(&t.Energy)
+		case "vocal_ratio":
+			number(&t.VocalRatio)
+		case "weight":
+			number(&t.Weight)
+		case "license_name":
+			t.License.Name = v
+		case "license_url":
+			t.License.URL = v
+		case "license_attribution":
+			t.License.Attribution = v
+		default:
+			return fmt.Errorf("unknown field %q", key)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+func adminError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, catalog.ErrInvalid):
+		status = http.StatusBadRequest
+	case errors.Is(err, catalog.ErrUnknownTrack):
+		status = http.StatusNotFound
+	case errors.Is(err, catalog.ErrTrackExists):
+		status = http.StatusConflict
+	case errors.Is(err, catalog.ErrTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	default:
+		logging.FromContext(r.Context()).Error("ADMIN ERROR", "path", r.URL.Path, "err", err)
+		http.Error(w, "Internal Server Error", status)
+		return
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// refreshAfterEdit brings the catalog up to date after the library wrote
+// files, whether or not the directory is watched. The library renames
+// every file into place whole, so they need not settle.
+func refreshAfterEdit(index *catalog.Index, files ...string) {
+	index.Arrived(files...)
+	index.Refresh()
+}
+
+// runAdminCommand is the client of handleAdminTracks: it talks to the
+// admin listener of a running server, authenticating with admin_token.
+func runAdminCommand(w io.Writer, cfg config.Config, args []string) int {
+	flags := flag.NewFlagSet("admin "+args[0], flag.ContinueOnError)
+	meta := make(map[string]*string, len(trackFields))
+	for _, field := range trackFields {
+		meta[field] = flags.String(strings.ReplaceAll(field, "_", "-"), "", "set the track's "+strings.ReplaceAll(field, "_", " "))
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	fields := url.Values{}
+	flags.Visit(func(f *flag.Flag) {
+		fields.Set(strings.ReplaceAll(f.Name, "-", "_"), f.Value.String())
+	})
+	rest := flags.Args()
+
+	var method, target, audio string
+	switch {
+	case args[0] == "list" && len(rest) == 0:
+		method, target = http.MethodGet, "/admin/tracks"
+	case args[0] == "upload" && len(rest) == 1:
+		method, target, audio = http.MethodPost, "/admin/tracks", rest[0]
+	case args[0] == "update" && len(rest) == 1 && len(fields) > 0:
+		method, target = http.MethodPut, "/admin/tracks/"+url.PathEscape(rest[0])
+	case args[0] == "replace" && len(rest) == 2:
+		method, target, audio = http.MethodPut, "/admin/tracks/"+url.PathEscape(rest[0]), rest[1]
+	case args[0] == "retire" && len(rest) == 1:
+		method, target = http.MethodDelete, "/admin/tracks/"+url.PathEscape(rest[0])
+	default:
+		fmt.Fprintf(os.Stderr, "usage: admin list | upload [metadata flags] FILE | update [metadata flags] TRACK | replace [metadata flags] TRACK FILE | retire TRACK\n\nmetadata flags:\n")
+		flags.SetOutput(os.Stderr)
+		flags.PrintDefaults()
+		return 2
+	}
+	if cfg.AdminAddr == "" || cfg.AdminToken == "" {
+		fmt.Fprintln(os.Stderr, "admin commands need admin_addr and admin_token")
+		return 1
+	}
+
+	var body io.Reader
+	contentType := ""
+	if method == http.MethodPost || method == http.MethodPut {
+		f := (*os.File)(nil)
+		if audio != "" {
+			var err error
+			if f, err = os.Open(audio); err != nil {
+				fmt.Fprintf(os.Stderr, "open audio: %v\n", err)
+				return 1
+			}
+			defer f.Close()
+		}
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		go func() {
+			pw.CloseWithError(writeTrackForm(mw, fields, f))
+		}()
+		body, contentType = pr, mw.FormDataContentType()
+	}
+
+	req, err := http.NewRequest(method, adminURL(cfg.AdminAddr)+target, body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "admin request: %v\n", err)
+		return 1
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.AdminToken)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "admin request: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		fmt.Fprintf(os.Stderr, "%s %s: %s: %s\n", method, target, resp.Status, strings.TrimSpace(string(msg)))
+		return 1
+	}
+
+	switch args[0] {
+	case "list":
+		var listing struct {
+			Tracks   []catalog.Track `json:"tracks"`
+			Problems []string        `json:"problems"`
+			Retired  []string        `json:"retired"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+			fmt.Fprintf(os.Stderr, "decode listing: %v\n", err)
+			return 1
+		}
+		for _, t := range listing.Tracks {
+			fmt.Fprintf(w, "%-8s %-8s %-40s %s\n", t.Palette, t.Phase, t.File, t.Title)
+		}
+		for _, p := range listing.Problems {
+			fmt.Fprintf(w, "problem: %s\n", p)
+		}
+		for _, name := range listing.Retired {
+			fmt.Fprintf(w, "retired: %s\n", name)
+		}
+	case "retire":
+		fmt.Fprintf(w, "retired %s\n", rest[0])
+	default:
+		var t catalog.Track
+		if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+			fmt.Fprintf(os.Stderr, "decode track: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(w, "%s: %s/%s %q\n", t.File, t.Palette, t.Phase, t.Title)
+	}
+	return 0
+}
+
+func writeTrackForm(mw *multipart.Writer, fields url.Values, audio *os.File) error {
+	for key := range fields {
+		if err := mw.WriteField(key, fields.Get(key)); err != nil {
+			return err
+		}
+	}
+	if audio != nil {
+		part, err := mw.CreateFormFile("audio", filepath.Base(audio.Name()))
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(part, audio); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// adminURL turns admin_addr into a base URL; a listener on every
+// interface is reached over loopback.
+func adminURL(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "http://" + addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
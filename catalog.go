@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"sonare.media/internal/catalog"
+	"sonare.media/internal/config"
+	"sonare.media/internal/mp4"
+)
+
+// durationTolerance is how far a manifest duration may drift from the
+// file's before check reports it.
+const durationTolerance = time.Second
+
+// runCatalogCheck validates the music directory: every file must be a
+// catalogued track, each palette must cover every phase and the beacon
+// (unless the manifest marks it incomplete) with tracks whose variants
+// are distinct, and every track must be a non-empty MP4 whose metadata
+// agrees with the manifest and whose running time lies within the
+// configured bounds. It exits non-zero when anything is an error, so
+// releases can gate on it; warnings alone do not fail.
+func runCatalogCheck(w io.Writer, music fs.FS, cfg config.Catalog) int {
+	cat, err := catalog.Load(music)
+	if err != nil {
+		fmt.Fprintf(w, "error: %v\n", err)
+		return 1
+	}
+
+	errs := 0
+	for _, problem := range cat.Problems {
+		fmt.Fprintf(w, "error: %s\n", problem)
+		errs++
+	}
+	for _, name := range cat.Unmatched {
+		if strings.EqualFold(path.Ext(name), ".m4a") {
+			fmt.Fprintf(w, "error: %s: name does not match PALETTE_PHASE-Title.m4a or PALETTE_Sonare.m4a and the manifest does not list it\n", name)
+			errs++
+		} else {
+			fmt.Fprintf(w, "warning: %s: not a preview track; ignored\n", name)
+		}
+	}
+
+	for _, t := range cat.Tracks {
+		fmt.Fprintf(w, "%s\n", t.File)
+		problems, warnings := checkTrack(w, music, t, cfg)
+		for _, p := range problems {
+			fmt.Fprintf(w, "  error: %s\n", p)
+		}
+		for _, p := range warnings {
+			fmt.Fprintf(w, "  warning: %s\n", p)
+		}
+		errs += len(problems)
+	}
+
+	for _, p := range cat.Palettes() {
+		fmt.Fprintf(w, "palette %s: %d tracks, %.0f%% complete\n", p.Key, len(cat.Palette(p.Key)), 100*p.Completeness)
+		for _, phase := range p.Missing {
+			if cat.Info[p.Key].Incomplete {
+				fmt.Fprintf(w, "  warning: no %s track (palette marked incomplete)\n", phase)
+				continue
+			}
+			fmt.Fprintf(w, "  error: no %s track\n", phase)
+			errs++
+		}
+		for _, phase := range catalog.Phases {
+			byVariant := make(map[string][]string)
+			var order []string
+			for _, t := range cat.Variants(p.Key, phase) {
+				key := strings.ToLower(t.Variant)
+				if byVariant[key] == nil {
+					order = append(order, key)
+				}
+				byVariant[key] = append(byVariant[key], t.File)
+			}
+			for _, key := range order {
+				if dup := byVariant[key]; len(dup) > 1 {
+					fmt.Fprintf(w, "  error: %d %s tracks share the variant %q: %s\n", len(dup), phase, key, strings.Join(dup, ", "))
+					errs++
+				}
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "\n%d tracks, %d errors\n", len(cat.Tracks), errs)
+	if errs > 0 {
+		return 1
+	}
+	return 0
+}
+
+// checkTrack reads one track's MP4 metadata, printing a summary line, and
+// returns its errors and warnings.
+func checkTrack(w io.Writer, music fs.FS, t catalog.Track, cfg config.Catalog) (problems, warnings []string) {
+	stat, err := fs.Stat(music, t.File)
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+	if stat.Size() == 0 {
+		return []string{"empty file"}, nil
+	}
+	info, err := mp4.ReadFile(music, t.File)
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+	fmt.Fprintf(w, "  %s/%s  %s  %s  %d Hz  %d ch  %d kbps\n", t.Palette, t.Phase,
+		info.Duration.Round(time.Millisecond), info.Codec, info.SampleRate, info.Channels, info.Bitrate/1000)
+
+	bounds, kind := cfg.TrackDuration, "phase tracks"
+	if t.Phase == "beacon" {
+		bounds, kind = cfg.BeaconDuration, "beacons"
+	}
+	switch {
+	case info.Duration <= 0:
+		problems = append(problems, "no duration")
+	case !bounds.Contains(info.Duration):
+		problems = append(problems, fmt.Sprintf("duration %s is outside %s for %s", info.Duration.Round(time.Millisecond), formatRange(bounds), kind))
+	}
+	if t.Duration > 0 && (t.Duration-info.Duration > durationTolerance || info.Duration-t.Duration > durationTolerance) {
+		problems = append(problems, fmt.Sprintf("manifest duration %s differs from file duration %s", t.Duration, info.Duration.Round(time.Millisecond)))
+	}
+	if info.SampleRate == 0 || info.Channels == 0 {
+		problems = append(problems, "audio track has no sample rate or channel count")
+	}
+	if !strings.HasPrefix(info.Codec, "mp4a.40.") {
+		warnings = append(warnings, fmt.Sprintf("codec %q is not AAC; some browsers will not play it", info.Codec))
+	}
+	if !info.FastStart {
+		warnings = append(warnings, "moov follows mdat; playback waits for the whole file (re-mux with -movflags +faststart)")
+	}
+	// Players switch between renditions mid-stream, so they must line up.
+	for _, name := range t.Renditions {
+		r, err := mp4.ReadFile(music, name)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("rendition %s: %v", name, err))
+		case r.Duration-info.Duration > durationTolerance || info.Duration-r.Duration > durationTolerance:
+			problems = append(problems, fmt.Sprintf("rendition %s lasts %s, not %s", name, r.Duration.Round(time.Millisecond), info.Duration.Round(time.Millisecond)))
+		}
+	}
+	return problems, warnings
+}
+
+func formatRange(r config.DurationRange) string {
+	if r.Max == 0 {
+		return fmt.Sprintf("[%s, ∞)", r.Min)
+	}
+	return fmt.Sprintf("[%s, %s]", r.Min, r.Max)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"sonare.media/internal/certs"
+	"sonare.media/internal/config"
+)
+
+// ensureDevCertificate generates a development certificate for serve-test
+// when neither the certificate nor the key exists, and replaces a generated
+// one that has expired. Operator-provided pairs are never touched.
+func ensureDevCertificate(cfg config.Config) error {
+	certPath, keyPath := cfg.TLS.CertFile, cfg.TLS.KeyFile
+	_, certErr := os.Stat(certPath)
+	_, keyErr := os.Stat(keyPath)
+	switch {
+	case certErr == nil && keyErr == nil:
+		chain, err := certs.ReadChain(certPath)
+		if err != nil || !slices.Contains(chain[0].Subject.Organization, certs.DevOrganization) || time.Now().Before(chain[0].NotAfter) {
+			return nil
+		}
+		slog.Warn("TLS: development certificate expired; regenerating", "cert", certPath, "not_after", chain[0].NotAfter)
+	case os.IsNotExist(certErr) && os.IsNotExist(keyErr):
+	default:
+		// A half-present pair is left for ensureTLSFiles to report.
+		return nil
+	}
+
+	var ca *certs.DevCA
+	if cfg.TLS.SelfSigned.DevCA {
+		var created bool
+		var err error
+		ca, created, err = certs.LoadOrCreateDevCA(cfg.TLS.SelfSigned.CACertFile, cfg.TLS.SelfSigned.CAKeyFile)
+		if err != nil {
+			return err
+		}
+		if created {
+			slog.Warn("TLS: created development CA; trust it once in your browser or OS store", "ca_cert", cfg.TLS.SelfSigned.CACertFile)
+		}
+	}
+
+	leaf, err := certs.GenerateSelfSigned(certPath, keyPath, cfg.TLS.SelfSigned.Hosts, cfg.TLS.SelfSigned.Validity, ca)
+	if err != nil {
+		return fmt.Errorf("generate development certificate: %w", err)
+	}
+	slog.Info("TLS: generated development certificate",
+		"cert", certPath,
+		"issuer", leaf.Issuer.CommonName,
+		"dns_names", leaf.DNSNames,
+		"ip_addresses", leaf.IPAddresses,
+		"not_after", leaf.NotAfter,
+	)
+	return nil
+}
+
+// runCertsCommand prints expiry and SANs for the certificates the server
+// would load with cfg. It exits non-zero when any is missing or expired.
+func runCertsCommand(w io.Writer, cfg config.Config, now time.Time) int {
+	var paths []string
+	if cfg.TLS.ACME.Enabled {
+		for _, host := range cfg.TLS.ACME.Hosts {
+			paths = append(paths, filepath.Join(cfg.TLS.ACME.CacheDir, host))
+		}
+	} else {
+		paths = append(paths, cfg.TLS.CertFile)
+	}
+	if cfg.TLS.SelfSigned.DevCA {
+		paths = append(paths, cfg.TLS.SelfSigned.CACertFile)
+	}
+
+	code := 0
+	for i, path := range paths {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w, path)
+		chain, err := certs.ReadChain(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				fmt.Fprintln(w, "  status:     missing")
+				if cfg.Mode == "serve-test" && !cfg.TLS.ACME.Enabled {
+					fmt.Fprintln(w, "  note:       serve-test will generate a development certificate")
+				}
+			} else {
+				fmt.Fprintf(w, "  status:     unreadable (%v)\n", err)
+			}
+			code = 1
+			continue
+		}
+		if !describeCertificate(w, chain[0], now) {
+			code = 1
+		}
+	}
+	return code
+}
+
+// describeCertificate writes one certificate's summary and reports whether
+// it is currently valid.
+func describeCertificate(w io.Writer, cert *x509.Certificate, now time.Time) bool {
+	status, detail := certificateStatus(cert, now)
+	fmt.Fprintf(w, "  subject:    %s\n", cert.Subject)
+	fmt.Fprintf(w, "  issuer:     %s\n", cert.Issuer)
+	fmt.Fprintf(w, "  not before: %s\n", cert.NotBefore.UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "  not after:  %s\n", cert.NotAfter.UTC().Format(time.RFC3339))
+	if status == checkFail {
+		fmt.Fprintf(w, "  status:     %s (%s)\n", status, detail)
+	} else {
+		fmt.Fprintf(w, "  status:     %s (%d days left)\n", status, int(cert.NotAfter.Sub(now).Hours()/24))
+	}
+	if len(cert.DNSNames) > 0 {
+		fmt.Fprintf(w, "  dns names:  %s\n", strings.Join(cert.DNSNames, ", "))
+	}
+	if len(cert.IPAddresses) > 0 {
+		ips := make([]string, len(cert.IPAddresses))
+		for i, ip := range cert.IPAddresses {
+			ips[i] = ip.String()
+		}
+		fmt.Fprintf(w, "  ip addrs:   %s\n", strings.Join(ips, ", "))
+	}
+	if cert.IsCA {
+		fmt.Fprintln(w, "  ca:         yes")
+	}
+	return status != checkFail
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"sonare.media/internal/config"
+)
+
+// loadConfig layers the config file, SONARE_* variables and explicitly set
+// flags. It does not validate; callers decide when invalid settings matter.
+func loadConfig(path string) (config.Config, error) {
+	cfg, err := config.Load(path, os.Environ())
+	if err != nil {
+		return cfg, err
+	}
+
+	var errs []error
+	flag.Visit(func(f *flag.Flag) {
+		key, ok := flagKeys[f.Name]
+		if !ok {
+			return
+		}
+		if err := cfg.Set(key, f.Value.String()); err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %w", f.Name, err))
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return cfg, err
+	}
+
+	if mode, ok := normalizeMode(cfg.Mode); ok {
+		cfg.Mode = mode
+	}
+	return cfg, nil
+}
+
+// runCommand dispatches the positional subcommands and returns the exit
+// code.
+func runCommand(cfg config.Config, args []string) int {
+	switch {
+	case len(args) == 2 && args[0] == "config" && args[1] == "print":
+		if err := cfg.Print(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "print config: %v\n", err)
+			return 1
+		}
+		if err := cfg.Validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+			return 1
+		}
+		return 0
+	case len(args) == 1 && args[0] == "certs":
+		return runCertsCommand(os.Stdout, cfg, time.Now())
+	case len(args) == 2 && args[0] == "catalog" && (args[1] == "check" || args[1] == "lint"):
+		music, err := musicFS(cfg.WebDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "open music: %v\n", err)
+			return 1
+		}
+		return runCatalogCheck(os.Stdout, music, cfg.Catalog)
+	case len(args) >= 2 && args[0] == "admin":
+		return runAdminCommand(os.Stdout, cfg, args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args)
+		usage()
+		return 2
+	}
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command]\n\n", os.Args[0])
+	fmt.Fprintln(out, "Commands:")
+	fmt.Fprintln(out, "  config print    Print the effective configuration with secrets masked")
+	fmt.Fprintln(out, "  certs           Show expiry and SANs of the certificates the server would load")
+	fmt.Fprintln(out, "  catalog check   Validate the music directory: names, phase coverage, MP4 metadata and durations")
+	fmt.Fprintln(out, "                  (\"catalog lint\" is an alias); exits 1 on any error")
+	fmt.Fprintln(out, "  admin list|upload|update|replace|retire")
+	fmt.Fprintln(out, "                  Manage the catalog of a running server through its admin listener;")
+	fmt.Fprintln(out, "                  \"admin upload -h\" lists the metadata flags")
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
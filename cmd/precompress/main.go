@@ -0,0 +1,36 @@
+// Command precompress writes .br, .zst and .gz siblings of the web assets
+// so they are embedded next to the originals. Run it through go generate
+// before building a release:
+//
+//	go generate ./... && go build
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"sonare.media/internal/compression"
+)
+
+func main() {
+	minSize := flag.Int("min-size", compression.DefaultMinSize, "Skip files smaller than this many bytes")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] dir\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	written, err := compression.Precompress(flag.Arg(0), *minSize)
+	for _, name := range written {
+		fmt.Println(name)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "precompress: %v\n", err)
+		os.Exit(1)
+	}
+}
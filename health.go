@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"sonare.media/internal/store"
+)
+
+const (
+	readinessTimeout = 2 * time.Second
+
+	// certWarnWindow flags a certificate as "warn" ahead of expiry; the
+	// process stays ready until the certificate has actually lapsed.
+	certWarnWindow = 14 * 24 * time.Hour
+
+	// analyticsSaturation is the queue fill ratio at which we report unready.
+	analyticsSaturation = 0.9
+)
+
+// Check statuses, from best to worst.
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+type checkResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Detail    string  `json:"detail,omitempty"`
+}
+
+type readinessCheck struct {
+	name string
+	run  func(ctx context.Context) (status, detail string)
+}
+
+// readiness probes the dependencies the site needs to serve real traffic.
+type readiness struct {
+	checks []readinessCheck
+}
+
+// newReadiness builds the dependency probes for this process. cert returns
+// the certificate being served and is nil when the server does not
+// terminate TLS itself.
+func newReadiness(music fs.FS, cert func() *x509.Certificate) *readiness {
+	rd := &readiness{}
+	rd.add("db_writable", checkDBWritable)
+	rd.add("db_schema", checkSchemaVersion)
+	rd.add("music_dir", func(ctx context.Context) (string, string) {
+		return checkMusicDir(music)
+	})
+	rd.add("analytics_queue", checkAnalyticsQueue)
+	if cert != nil {
+		rd.add("tls_cert", func(ctx context.Context) (string, string) {
+			leaf := cert()
+			if leaf == nil {
+				return checkFail, "no certificate loaded"
+			}
+			return certificateStatus(leaf, time.Now())
+		})
+	}
+	return rd
+}
+
+func (rd *readiness) add(name string, run func(ctx context.Context) (string, string)) {
+	rd.checks = append(rd.checks, readinessCheck{name: name, run: run})
+}
+
+// run executes every check concurrently and reports the worst status.
+func (rd *readiness) run(ctx context.Context) (string, map[string]checkResult) {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]checkResult, len(rd.checks))
+
+	for _, c := range rd.checks {
+		wg.Add(1)
+		go func(c readinessCheck) {
+			defer wg.Done()
+			start := time.Now()
+			status, detail := c.run(ctx)
+			res := checkResult{
+				Status:    status,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+				Detail:    detail,
+			}
+			mu.Lock()
+			results[c.name] = res
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	overall := checkOK
+	for _, res := range results {
+		switch {
+		case res.Status == checkFail:
+			overall = checkFail
+		case res.Status == checkWarn && overall == checkOK:
+			overall = checkWarn
+		}
+	}
+	return overall, results
+}
+
+func (rd *readiness) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	overall, results := rd.run(r.Context())
+
+	status := http.StatusOK
+	if overall == checkFail {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": overall,
+		"checks": results,
+	})
+}
+
+// handleLivez reports only that the process is up and serving; it must not
+// touch dependencies so that a slow database never gets us restarted.
+func handleLivez(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"status": checkOK})
+}
+
+func checkDBWritable(ctx context.Context) (string, string) {
+	if store.DB == nil {
+		return checkFail, "database not initialized"
+	}
+	if err := store.CheckWritable(ctx); err != nil {
+		return checkFail, err.Error()
+	}
+	return checkOK, ""
+}
+
+func checkSchemaVersion(ctx context.Context) (string, string) {
+	if store.DB == nil {
+		return checkFail, "database not initialized"
+	}
+	version, err := store.SchemaVersion()
+	if err != nil {
+		return checkFail, err.Error()
+	}
+	detail := fmt.Sprintf("version %d of %d", version, store.LatestSchemaVersion())
+	if version != store.LatestSchemaVersion() {
+		return checkFail, detail
+	}
+	return checkOK, detail
+}
+
+func checkMusicDir(music fs.FS) (string, string) {
+	entries, err := fs.ReadDir(music, ".")
+	if err != nil {
+		return checkFail, err.Error()
+	}
+
+	tracks := 0
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.EqualFold(filepath.Ext(entry.Name()), ".m4a") {
+			tracks++
+		}
+	}
+	if tracks == 0 {
+		return checkFail, "no .m4a previews found"
+	}
+	return checkOK, fmt.Sprintf("%d tracks", tracks)
+}
+
+func checkAnalyticsQueue(ctx context.Context) (string, string) {
+	depth, capacity := len(analyticsQueue), cap(analyticsQueue)
+	detail := fmt.Sprintf("%d/%d queued", depth, capacity)
+	if float64(depth) >= analyticsSaturation*float64(capacity) {
+		return checkFail, detail
+	}
+	return checkOK, detail
+}
+
+func certificateStatus(leaf *x509.Certificate, now time.Time) (string, string) {
+	remaining := leaf.NotAfter.Sub(now)
+	detail := fmt.Sprintf("expires %s", leaf.NotAfter.UTC().Format(time.RFC3339))
+	switch {
+	case now.Before(leaf.NotBefore):
+		return checkFail, "certificate not yet valid"
+	case remaining <= 0:
+		return checkFail, "certificate expired " + leaf.NotAfter.UTC().Format(time.RFC3339)
+	case remaining < certWarnWindow:
+		return checkWarn, detail
+	default:
+		return checkOK, detail
+	}
+}
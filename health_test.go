@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertificateStatus(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 2, 14, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		notAfter time.Time
+		want     string
+	}{
+		{name: "healthy", notAfter: now.Add(60 * 24 * time.Hour), want: checkOK},
+		{name: "near expiry", notAfter: now.Add(3 * 24 * time.Hour), want: checkWarn},
+		{name: "expired", notAfter: now.Add(-time.Hour), want: checkFail},
+	}
+
+	for _, tc := range tests {
+		leaf := &x509.Certificate{NotBefore: now.Add(-24 * time.Hour), NotAfter: tc.notAfter}
+		if got, detail := certificateStatus(leaf, now); got != tc.want {
+			t.Errorf("%s: status=%q (%s) want=%q", tc.name, got, detail, tc.want)
+		}
+	}
+}
+
+func TestReadinessReportsWorstStatus(t *testing.T) {
+	t.Parallel()
+
+	rd := &readiness{}
+	rd.add("fine", func(ctx context.Context) (string, string) { return checkOK, "" })
+	rd.add("soon", func(ctx context.Context) (string, string) { return checkWarn, "" })
+
+	overall, results := rd.run(context.Background())
+	if overall != checkWarn || len(results) != 2 {
+		t.Fatalf("got overall=%q results=%v, want warn with 2 results", overall, results)
+	}
+
+	rd.add("broken", func(ctx context.Context) (string, string) { return checkFail, "boom" })
+	overall, results = rd.run(context.Background())
+	if overall != checkFail || results["broken"].Detail != "boom" {
+		t.Fatalf("got overall=%q results=%v, want fail", overall, results)
+	}
+}
+
+func TestCheckMusicDir(t *testing.T) {
+	t.Parallel()
+
+	musicDir := t.TempDir()
+	if got, _ := checkMusicDir(os.DirFS(musicDir)); got != checkFail {
+		t.Fatalf("empty dir: got=%q want=%q", got, checkFail)
+	}
+
+	if err := os.WriteFile(filepath.Join(musicDir, "WARM_Sonare.m4a"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("write test file: %v", err)
+	}
+	if got, detail := checkMusicDir(os.DirFS(musicDir)); got != checkOK {
+		t.Fatalf("populated dir: got=%q (%s) want=%q", got, detail, checkOK)
+	}
+}
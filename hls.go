@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sonare.media/internal/catalog"
+	"sonare.media/internal/hls"
+	"sonare.media/internal/logging"
+	"sonare.media/internal/signedurl"
+)
+
+// handleHLS serves catalogued tracks as HLS presentations:
+//
+//	/hls/<file>/master.m3u8     renditions of the track
+//	/hls/<file>/<r>/media.m3u8  segments of rendition r
+//	/hls/<file>/<r>/init.mp4    its initialization segment
+//	/hls/<file>/<r>/<n>.m4s     its media segment n
+//
+// Rendition 0 is the track's own file and 1 onwards its renditions from
+// the manifest. Segments take the /music caching; playlists are
+// revalidated, since they carry signed URLs when those are enabled.
+func handleHLS(live *liveConfig, index *catalog.Index, packager *hls.Packager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/hls/"), "/")
+		track, ok := index.Catalog().Track(parts[0])
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		files := append([]string{track.File}, track.Renditions...)
+		uri, err := hlsURIs(r, live, "/hls/"+track.File+"/")
+		if err != nil {
+			logging.FromContext(r.Context()).Error("SIGNED URL ERROR", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if len(parts) == 2 && parts[1] == hls.MasterName {
+			var variants []hls.Variant
+			for i, file := range files {
+				src, err := packager.Open(file)
+				if err != nil {
+					logging.FromContext(r.Context()).Warn("HLS: rendition skipped", "track", track.File, "file", file, "err", err)
+					continue
+				}
+				src.Close()
+				variants = append(variants, hls.Variant{URI: uri(strconv.Itoa(i) + "/" + hls.MediaName), Track: src.Track})
+			}
+			if len(variants) == 0 {
+				http.Error(w, "Track cannot be streamed", http.StatusNotFound)
+				return
+			}
+			servePlaylist(w, r, hls.MasterPlaylist(variants))
+			return
+		}
+
+		if len(parts) != 3 {
+			http.NotFound(w, r)
+			return
+		}
+		rendition, err := strconv.Atoi(parts[1])
+		if err != nil || rendition < 0 || rendition >= len(files) || parts[1] != strconv.Itoa(rendition) {
+			http.NotFound(w, r)
+			return
+		}
+		src, err := packager.Open(files[rendition])
+		if err != nil {
+			logging.FromContext(r.Context()).Error("HLS ERROR", "file", files[rendition], "err", err)
+			http.Error(w, "Track cannot be streamed", http.StatusNotFound)
+			return
+		}
+		defer src.Close()
+
+		name := parts[2]
+		if name == hls.MediaName {
+			prefix := parts[1] + "/"
+			servePlaylist(w, r, src.Track.MediaPlaylist(func(file string) string { return strings.TrimPrefix(uri(prefix+file), prefix) }))
+			return
+		}
+
+		var body bytes.Buffer
+		switch n, err := strconv.Atoi(strings.TrimSuffix(name, ".m4s")); {
+		case name == hls.InitName:
+			body.Write(src.Track.Init())
+		case err == nil && name == hls.SegmentName(n) && n < len(src.Track.Segments):
+			if err := src.Track.WriteSegment(&body, src.File, n); err != nil {
+				logging.FromContext(r.Context()).Error("HLS ERROR", "file", files[rendition], "segment", n, "err", err)
+				http.Error(w, "Failed to read segment", http.StatusInternalServerError)
+				return
+			}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		// Segment boundaries depend on the configured segment duration
+		// as well as the file.
+		w.Header().Set("ETag", fmt.Sprintf(`"%s-%d"`, src.Version, live.Load().Catalog.HLS.SegmentDuration.Milliseconds()))
+		w.Header().Set("Content-Type", hls.SegmentType)
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(body.Bytes()))
+	})
+}
+
+func servePlaylist(w http.ResponseWriter, r *http.Request, playlist string) {
+	w.Header().Set("Content-Type", hls.PlaylistType)
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write([]byte(playlist))
+}
+
+// hlsURIs returns how a playlist requested by r refers to another file
+// of the presentation, given its path relative to the track's directory
+// dir. With signed URLs every reference is signed to expire, and be bound
+// to a session, as the playlist's own URL was.
+func hlsURIs(r *http.Request, live *liveConfig, dir string) (func(rel string) string, error) {
+	su, signer, err := live.Signer()
+	if !su.Enabled {
+		return func(rel string) string { return rel }, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	query := r.URL.Query()
+	// signedMusicMiddleware has already verified the expiry.
+	expires, _ := signedurl.Expires(query)
+	session := ""
+	if query.Get(signedurl.ParamBind) == "1" {
+		session = sessionID(r)
+	}
+	return func(rel string) string {
+		return rel + "?" + signer.Sign(dir+rel, expires, session)
+	}, nil
+}
@@ -0,0 +1,441 @@
+// Package assets fingerprints the files under assets/ with a content hash
+// (app.js becomes app.3f9a1c2b.js), rewrites the references to them in
+// index.html, and attaches validators to everything it serves. The index
+// page also gets a CSP nonce on its script and style elements, filled in
+// per request by InjectNonce.
+package assets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Dir holds the fingerprinted files.
+	Dir = "assets"
+	// Index is the page whose references are rewritten.
+	Index = "index.html"
+	// ImmutableCacheControl is sent for fingerprinted URLs: their content
+	// can never change, so browsers need not revalidate.
+	ImmutableCacheControl = "public, max-age=31536000, immutable"
+	// hashLen is the number of hex digits of the SHA-256 kept in names.
+	hashLen = 8
+	// NoncePlaceholder marks where InjectNonce writes the request's nonce.
+	NoncePlaceholder = "__SONARE_CSP_NONCE__"
+)
+
+// refPattern finds root-relative asset references in src and href
+// attributes. Absolute URLs (og:image and the like) are left alone so
+// crawlers keep a stable address.
+var refPattern = regexp.MustCompile(`\b(src|href)=(["'])/` + Dir + `/([^"'?#]+)`)
+
+// nonceTagPattern finds script and style start tags in the index page.
+var nonceTagPattern = regexp.MustCompile(`(?i)<(script|style)\b[^>]*>`)
+
+// Pipeline serves a view of a site filesystem with fingerprinted asset
+// names and a rewritten index page. It rebuilds itself when the source
+// files change, which only happens with a -web-dir overlay.
+type Pipeline struct {
+	src     fs.FS
+	modTime time.Time
+	recheck time.Duration
+	state   atomic.Pointer[state]
+	// checked is when the inputs were last compared, in Unix nanoseconds.
+	checked atomic.Int64
+	mu      sync.Mutex
+	// etags caches content hashes by name, size and modification time.
+	etags sync.Map
+}
+
+type state struct {
+	signature string
+	// fingerprinted maps "assets/app.3f9a1c2b.js" to "assets/app.js".
+	fingerprinted map[string]string
+	// urls maps "assets/app.js" to "/assets/app.3f9a1c2b.js".
+	urls  map[string]string
+	index []byte
+}
+
+// New builds the pipeline over src. modTime stands in for files without
+// one, such as embedded files, so Last-Modified is stable per build.
+// recheck is how often the inputs are compared with what was built; zero
+// builds once, which is all the embedded site needs.
+func New(src fs.FS, modTime time.Time, recheck time.Duration) (*Pipeline, error) {
+	p := &Pipeline{src: src, modTime: modTime.UTC().Truncate(time.Second), recheck: recheck}
+	if _, err := p.current(); err != nil {
+		return nil, err
+	}
+	p.checked.Store(time.Now().UnixNano())
+	return p, nil
+}
+
+// URL returns the fingerprinted URL for an asset such as "assets/app.js".
+func (p *Pipeline) URL(name string) (string, bool) {
+	st, err := p.current()
+	if err != nil {
+		return "", false
+	}
+	u, ok := st.urls[name]
+	return u, ok
+}
+
+// current returns the state, rebuilding it when the inputs changed.
+func (p *Pipeline) current() (*state, error) {
+	if st := p.state.Load(); st != nil && !p.due() {
+		return st, nil
+	}
+	sig, err := p.signature()
+	if err != nil {
+		return nil, err
+	}
+	if st := p.state.Load(); st != nil && st.signature == sig {
+		return st, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if st := p.state.Load(); st != nil && st.signature == sig {
+		return st, nil
+	}
+	st, err := p.build(sig)
+	if err != nil {
+		return nil, err
+	}
+	p.state.Store(st)
+	return st, nil
+}
+
+// due reports whether the inputs should be compared again, claiming the
+// check so that concurrent requests keep using the current state.
+func (p *Pipeline) due() bool {
+	if p.recheck <= 0 {
+		return false
+	}
+	now, last := time.Now().UnixNano(), p.checked.Load()
+	return now-last >= int64(p.recheck) && p.checked.CompareAndSwap(last, now)
+}
+
+// signature summarises the names, sizes and times of the inputs.
+func (p *Pipeline) signature() (string, error) {
+	var b strings.Builder
+	if info, err := fs.Stat(p.src, Index); err == nil {
+		fmt.Fprintf(&b, "%s|%d|%d;", Index, info.Size(), info.ModTime().UnixNano())
+	}
+	entries, err := fs.ReadDir(p.src, Dir)
+	if err != nil && !isNotExist(err) {
+		return "", err
+	}
+	for _, e := range entries {
+		if e.IsDir() || isSibling(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s|%d|%d;", e.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+func (p *Pipeline) build(sig string) (*state, error) {
+	st := &state{
+		signature:     sig,
+		fingerprinted: make(map[string]string),
+		urls:          make(map[string]string),
+	}
+	entries, err := fs.ReadDir(p.src, Dir)
+	if err != nil && !isNotExist(err) {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || isSibling(e.Name()) {
+			continue
+		}
+		name := path.Join(Dir, e.Name())
+		sum, err := hashFile(p.src, name)
+		if err != nil {
+			return nil, fmt.Errorf("fingerprint %s: %w", name, err)
+		}
+		ext := path.Ext(e.Name())
+		hashed := path.Join(Dir, strings.TrimSuffix(e.Name(), ext)+"."+sum[:hashLen]+ext)
+		st.fingerprinted[hashed] = name
+		st.urls[name] = "/" + hashed
+	}
+
+	index, err := fs.ReadFile(p.src, Index)
+	switch {
+	case err == nil:
+		st.index = refPattern.ReplaceAllFunc(index, func(m []byte) []byte {
+			sub := refPattern.FindSubmatch(m)
+			if u, ok := st.urls[path.Join(Dir, string(sub[3]))]; ok {
+				return []byte(string(sub[1]) + "=" + string(sub[2]) + u)
+			}
+			return m
+		})
+		st.index = nonceTagPattern.ReplaceAllFunc(st.index, func(m []byte) []byte {
+			if bytes.Contains(bytes.ToLower(m), []byte("nonce=")) {
+				return m
+			}
+			tag := nonceTagPattern.FindSubmatch(m)[1]
+			return []byte("<" + string(tag) + ` nonce="` + NoncePlaceholder + `"` + string(m[1+len(tag):]))
+		})
+	case !isNotExist(err):
+		return nil, err
+	}
+	return st, nil
+}
+
+// FS returns the view served to clients: fingerprinted names resolve to
+// their originals (including precompressed siblings), index.html is the
+// rewritten page, and other files without a modification time get the
+// build's. The index has none, so it is served without Last-Modified.
+func (p *Pipeline) FS() fs.FS {
+	return view{p}
+}
+
+// Handler sets validators on responses from next, which must serve FS.
+// Fingerprinted URLs are also marked immutable.
+func (p *Pipeline) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		name := requestName(r)
+		if st, err := p.current(); err == nil {
+			if _, ok := st.fingerprinted[name]; ok {
+				w.Header().Set("Cache-Control", ImmutableCacheControl)
+			}
+		}
+		// The index differs per request once its nonce is filled in, so a
+		// validator would let a cached copy pair an old nonce with a new
+		// policy.
+		if name != Index {
+			if etag, ok := p.etag(name); ok {
+				w.Header().Set("ETag", etag)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// InjectNonce replaces NoncePlaceholder in the index page served by next
+// with nonce(r). Other files pass through untouched. Range requests for
+// the index get the whole page, since offsets shift with the nonce.
+func (p *Pipeline) InjectNonce(next http.Handler, nonce func(*http.Request) string) http.Handler {
+	placeholder := []byte(NoncePlaceholder)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || requestName(r) != Index {
+			next.ServeHTTP(w, r)
+			return
+		}
+		inner := r.Clone(r.Context())
+		inner.Method = http.MethodGet
+		inner.Header.Del("Range")
+		inner.Header.Del("If-Range")
+		buf := &bufferedResponse{header: w.Header()}
+		next.ServeHTTP(buf, inner)
+
+		body := buf.body.Bytes()
+		if buf.status == 0 || buf.status == http.StatusOK {
+			body = bytes.ReplaceAll(body, placeholder, []byte(nonce(r)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		}
+		if buf.status != 0 {
+			w.WriteHeader(buf.status)
+		}
+		if r.Method != http.MethodHead {
+			w.Write(body)
+		}
+	})
+}
+
+// bufferedResponse holds a response until InjectNonce has rewritten it.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	if b.status == 0 {
+		b.status = code
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// requestName maps a request path onto the name http.FileServer serves.
+func requestName(r *http.Request) string {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if strings.HasSuffix(r.URL.Path, "/") {
+		name = path.Join(name, Index)
+	}
+	if name == "" {
+		name = Index
+	}
+	return name
+}
+
+// etag returns the strong ETag of a file in the view.
+func (p *Pipeline) etag(name string) (string, bool) {
+	fsys := p.FS()
+	info, err := fs.Stat(fsys, name)
+	if err != nil || info.IsDir() {
+		return "", false
+	}
+	key := fmt.Sprintf("%s|%d|%d", name, info.Size(), info.ModTime().UnixNano())
+	if v, ok := p.etags.Load(key); ok {
+		return v.(string), true
+	}
+	sum, err := hashFile(fsys, name)
+	if err != nil {
+		return "", false
+	}
+	etag := `"` + sum[:32] + `"`
+	p.etags.Store(key, etag)
+	return etag, true
+}
+
+func hashFile(fsys fs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// siblingExts are the precompressed variants written by go generate.
+var siblingExts = []string{".br", ".zst", ".gz"}
+
+func isSibling(name string) bool {
+	for _, ext := range siblingExts {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func isNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// view is the filesystem returned by Pipeline.FS.
+type view struct {
+	p *Pipeline
+}
+
+func (v view) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	st, err := v.p.current()
+	if err != nil {
+		return nil, err
+	}
+	if name == Index && st.index != nil {
+		return &memFile{Reader: bytes.NewReader(st.index), info: memInfo{name: Index, size: int64(len(st.index))}}, nil
+	}
+
+	src := name
+	if orig, ok := st.fingerprinted[name]; ok {
+		src = orig
+	} else {
+		for _, ext := range siblingExts {
+			if orig, ok := st.fingerprinted[strings.TrimSuffix(name, ext)]; ok && strings.HasSuffix(name, ext) {
+				src = orig + ext
+				break
+			}
+		}
+	}
+	f, err := v.p.src.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	return &timedFile{File: f, name: path.Base(name), modTime: v.p.modTime}, nil
+}
+
+// timedFile substitutes a modification time and the requested name.
+type timedFile struct {
+	fs.File
+	name    string
+	modTime time.Time
+}
+
+func (f *timedFile) Stat() (fs.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	mt := info.ModTime()
+	if mt.IsZero() {
+		mt = f.modTime
+	}
+	return memInfo{name: f.name, size: info.Size(), modTime: mt, mode: info.Mode()}, nil
+}
+
+func (f *timedFile) Read(b []byte) (int, error) { return f.File.Read(b) }
+
+func (f *timedFile) Seek(offset int64, whence int) (int64, error) {
+	if s, ok := f.File.(io.Seeker); ok {
+		return s.Seek(offset, whence)
+	}
+	return 0, fmt.Errorf("seek %s: not supported", f.name)
+}
+
+func (f *timedFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d, ok := f.File.(fs.ReadDirFile); ok {
+		return d.ReadDir(n)
+	}
+	return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: fs.ErrInvalid}
+}
+
+// memFile is the rewritten index page.
+type memFile struct {
+	*bytes.Reader
+	info memInfo
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+type memInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	mode    fs.FileMode
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() fs.FileMode  { return i.mode }
+func (i memInfo) ModTime() time.Time { return i.modTime }
+func (i memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memInfo) Sys() any           { return nil }
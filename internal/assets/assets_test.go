@@ -0,0 +1,124 @@
+package assets
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestPipeline(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{
+		"index.html": {Data: []byte(`<link rel="stylesheet" href="/assets/styles.css">` +
+			`<meta property="og:image" content="https://sonare.media/assets/app.js">` +
+			`<script src='/assets/app.js'></script><img src="/assets/missing.png">`)},
+		"assets/app.js":     {Data: []byte("console.log('sonare')")},
+		"assets/styles.css": {Data: []byte("body{}")},
+		"music/A.m4a":       {Data: []byte("audio")},
+	}
+	built := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	p, err := New(fsys, built, 0)
+	if err != nil {
+		t.Fatalf("new pipeline: %v", err)
+	}
+
+	appURL, ok := p.URL("assets/app.js")
+	if !ok || !strings.HasPrefix(appURL, "/assets/app.") || !strings.HasSuffix(appURL, ".js") || len(appURL) != len("/assets/app.js")+hashLen+1 {
+		t.Fatalf("fingerprinted URL mismatch: got=%q", appURL)
+	}
+	cssURL, _ := p.URL("assets/styles.css")
+
+	nonce := func(*http.Request) string { return "bm9uY2U=" }
+	h := p.Handler(p.InjectNonce(http.FileServer(http.FS(p.FS())), nonce))
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	index := get("/", nil)
+	body, _ := io.ReadAll(index.Body)
+	for _, want := range []string{
+		`href="` + cssURL + `"`,
+		`<script nonce="bm9uY2U=" src='` + appURL + `'>`,
+		`content="https://sonare.media/assets/app.js"`,
+		`src="/assets/missing.png"`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("index missing %s:\n%s", want, body)
+		}
+	}
+	if strings.Contains(string(body), NoncePlaceholder) {
+		t.Errorf("index still has the nonce placeholder:\n%s", body)
+	}
+	if got := index.Header().Get("Content-Length"); got != strconv.Itoa(len(body)) {
+		t.Errorf("content-length mismatch: got=%s want=%d", got, len(body))
+	}
+	// A per-request page must not be revalidated against a cached copy.
+	if lm, etag := index.Header().Get("Last-Modified"), index.Header().Get("ETag"); lm != "" || etag != "" {
+		t.Errorf("index validators mismatch: last-modified=%q etag=%q want none", lm, etag)
+	}
+	if ranged := get("/", http.Header{"Range": {"bytes=0-9"}}); ranged.Code != http.StatusOK || ranged.Body.String() != string(body) {
+		t.Errorf("ranged index mismatch: code=%d body=%q", ranged.Code, ranged.Body.String())
+	}
+
+	asset := get(appURL, nil)
+	if asset.Code != http.StatusOK || asset.Body.String() != "console.log('sonare')" {
+		t.Fatalf("fingerprinted asset mismatch: code=%d body=%q", asset.Code, asset.Body.String())
+	}
+	if got := asset.Header().Get("Cache-Control"); got != ImmutableCacheControl {
+		t.Fatalf("cache-control mismatch: got=%q want=%q", got, ImmutableCacheControl)
+	}
+
+	if stale := get("/assets/app.00000000.js", nil); stale.Code != http.StatusNotFound || stale.Header().Get("Cache-Control") != "" {
+		t.Fatalf("stale fingerprint mismatch: code=%d cache-control=%q", stale.Code, stale.Header().Get("Cache-Control"))
+	}
+
+	music := get("/music/A.m4a", nil)
+	etag := music.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, "W/") {
+		t.Fatalf("expected strong ETag, got %q", etag)
+	}
+	if again := get("/music/A.m4a", http.Header{"If-None-Match": {etag}}); again.Code != http.StatusNotModified {
+		t.Fatalf("conditional request mismatch: got=%d want=304", again.Code)
+	}
+}
+
+func TestPipelineRecheck(t *testing.T) {
+	t.Parallel()
+
+	for name, tt := range map[string]struct {
+		recheck time.Duration
+		rebuilt bool
+	}{
+		"embedded": {recheck: 0, rebuilt: false},
+		"overlay":  {recheck: time.Millisecond, rebuilt: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			fsys := fstest.MapFS{"assets/app.js": {Data: []byte("console.log(1)")}}
+			p, err := New(fsys, time.Time{}, tt.recheck)
+			if err != nil {
+				t.Fatalf("new pipeline: %v", err)
+			}
+			before, _ := p.URL("assets/app.js")
+
+			fsys["assets/app.js"] = &fstest.MapFile{Data: []byte("console.log('edited')")}
+			time.Sleep(2 * time.Millisecond)
+			after, _ := p.URL("assets/app.js")
+			if rebuilt := after != before; rebuilt != tt.rebuilt {
+				t.Fatalf("rebuilt mismatch: got=%v want=%v (before=%s after=%s)", rebuilt, tt.rebuilt, before, after)
+			}
+		})
+	}
+}
@@ -0,0 +1,472 @@
+// Package catalog describes the preview tracks under web/music. Tracks are
+// listed with their metadata in a manifest (catalog.yaml next to the
+// audio); files the manifest does not mention are imported from their
+// names, e.g. WARM_OPEN-FirstLight.m4a, so dropping a correctly named file
+// into the directory is enough to publish it.
+//
+// A palette may have any number of tracks per phase. They are told apart
+// by their variant, the part of the name after the dash (CoreFlow and
+// CoreFlow2 in WARM_PEAK-CoreFlow.m4a and WARM_PEAK-CoreFlow2.m4a), and
+// one is picked per request by a selection strategy (see Pick).
+package catalog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestName is the manifest's file name inside the music directory.
+const ManifestName = "catalog.yaml"
+
+// Phases are the slots of a palette's preview, in playback order. The
+// beacon is the short Sonare ident played between phases.
+var Phases = []string{"open", "peak", "offpeak", "close", "beacon"}
+
+// Where a track's metadata came from.
+const (
+	SourceManifest = "manifest"
+	SourceFilename = "filename"
+)
+
+// Track is one preview file and what is known about it.
+type Track struct {
+	// File is the name inside the music directory.
+	File    string `yaml:"file" json:"file"`
+	Palette string `yaml:"palette" json:"palette"`
+	Phase   string `yaml:"phase" json:"phase"`
+	// Variant distinguishes tracks of the same palette and phase; it
+	// defaults to the part of the file name after the dash.
+	Variant string `yaml:"variant,omitempty" json:"variant"`
+	Title   string `yaml:"title,omitempty" json:"title"`
+	// Duration is the running time stated in the manifest.
+	Duration time.Duration `yaml:"duration,omitempty" json:"duration,omitempty"`
+	BPM      float64       `yaml:"bpm,omitempty" json:"bpm,omitempty"`
+	// Energy and VocalRatio are normalised to [0, 1].
+	Energy     float64 `yaml:"energy,omitempty" json:"energy,omitempty"`
+	VocalRatio float64 `yaml:"vocal_ratio,omitempty" json:"vocal_ratio,omitempty"`
+	License    License `yaml:"license,omitempty" json:"license,omitzero"`
+	// Weight is the track's relative chance under StrategyWeighted; zero
+	// counts as 1.
+	Weight float64 `yaml:"weight,omitempty" json:"weight,omitempty"`
+	// Renditions are other encodings of the same audio, e.g. at a lower
+	// bitrate, offered alongside File when the track is streamed. They
+	// are not tracks of their own.
+	Renditions []string `yaml:"renditions,omitempty" json:"renditions,omitempty"`
+	// Source is SourceManifest or SourceFilename.
+	Source string `yaml:"-" json:"source"`
+}
+
+// License records the terms a track is used under.
+type License struct {
+	Name        string `yaml:"name,omitempty" json:"name,omitempty"`
+	URL         string `yaml:"url,omitempty" json:"url,omitempty"`
+	Attribution string `yaml:"attribution,omitempty" json:"attribution,omitempty"`
+}
+
+// PaletteInfo is the manifest's description of a palette.
+type PaletteInfo struct {
+	// Name is shown to visitors; it defaults to the capitalised key.
+	Name        string `yaml:"name,omitempty"`
+	Description string `yaml:"description,omitempty"`
+	// Incomplete marks a palette published before it has every phase, so
+	// that check reports the missing ones as warnings, not errors.
+	Incomplete bool `yaml:"incomplete,omitempty"`
+}
+
+// Manifest is the on-disk form of the catalog.
+type Manifest struct {
+	// Palettes is keyed by palette, e.g. "warm".
+	Palettes map[string]PaletteInfo `yaml:"palettes,omitempty"`
+	Tracks   []Track                `yaml:"tracks"`
+	// Retired lists files taken out of the catalog that may still be in
+	// the directory, such as copies embedded in the binary.
+	Retired []string `yaml:"retired,omitempty"`
+}
+
+// Catalog is the merged view of the manifest and the directory.
+type Catalog struct {
+	// Tracks are sorted by palette, then phase order, then file.
+	Tracks []Track
+	// Info holds the manifest's palette descriptions by key.
+	Info map[string]PaletteInfo
+	// Problems lists manifest entries that were skipped and why.
+	Problems []string
+	// Unmatched lists files that are neither in the manifest nor named
+	// like a track, and so are not served as previews.
+	Unmatched []string
+	// Retired lists the manifest's retired files.
+	Retired []string
+
+	byPalette map[string][]Track
+	byPhase   map[string][]Track
+	byFile    map[string]Track
+}
+
+// Palette summarises one palette's coverage.
+type Palette struct {
+	Key         string
+	Name        string
+	Description string
+	// Phases lists the phases other than the beacon that have a track,
+	// in playback order.
+	Phases []string
+	Beacon bool
+	// Missing lists the phases, beacon included, without a track.
+	Missing []string
+	// Completeness is the fraction of Phases (beacon included) covered.
+	Completeness float64
+}
+
+// Complete reports whether every phase and the beacon have a track.
+func (p Palette) Complete() bool {
+	return len(p.Missing) == 0
+}
+
+// Load reads the manifest from music (if present) and imports every other
+// .m4a file by name. Only an unreadable directory or a malformed manifest
+// is an error; bad entries are skipped and reported in Problems.
+func Load(music fs.FS) (*Catalog, error) {
+	entries, err := fs.ReadDir(music, ".")
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]bool)
+	for _, e := range entries {
+		if !e.IsDir() {
+			files[e.Name()] = true
+		}
+	}
+
+	c := &Catalog{Info: make(map[string]PaletteInfo)}
+	listed := make(map[string]bool)
+	if files[ManifestName] {
+		m, err := ReadManifest(music)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range m.Retired {
+			listed[name] = true
+		}
+		c.Retired = m.Retired
+		for key, info := range m.Palettes {
+			if key = NormalizePalette(key); key != "" {
+				c.Info[key] = info
+			}
+		}
+		for i, t := range m.Tracks {
+			t.Palette = NormalizePalette(t.Palette)
+			t.Phase = strings.ToLower(strings.TrimSpace(t.Phase))
+			if slices.Contains(c.Retired, t.File) {
+				c.Problems = append(c.Problems, fmt.Sprintf("%s: tracks[%d] (%s): file is retired", ManifestName, i, t.File))
+				continue
+			}
+			if err := t.validate(files); err != nil {
+				c.Problems = append(c.Problems, fmt.Sprintf("%s: tracks[%d] (%s): %v", ManifestName, i, t.File, err))
+				continue
+			}
+			if name := firstListed(listed, t); name != "" {
+				c.Problems = append(c.Problems, fmt.Sprintf("%s: tracks[%d] (%s): %s listed more than once", ManifestName, i, t.File, name))
+				continue
+			}
+			listed[t.File] = true
+			for _, r := range t.Renditions {
+				listed[r] = true
+			}
+			if t.Title == "" {
+				t.Title = titleFromFilename(t.File)
+			}
+			if t.Variant == "" {
+				t.Variant = variantFromFilename(t.File)
+			}
+			t.Source = SourceManifest
+			c.Tracks = append(c.Tracks, t)
+		}
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || listed[e.Name()] {
+			continue
+		}
+		palette, phase, ok := ParseFilename(e.Name())
+		if !ok {
+			if e.Name() != ManifestName && !strings.HasPrefix(e.Name(), ".") {
+				c.Unmatched = append(c.Unmatched, e.Name())
+			}
+			continue
+		}
+		c.Tracks = append(c.Tracks, Track{
+			File:    e.Name(),
+			Palette: palette,
+			Phase:   phase,
+			Variant: variantFromFilename(e.Name()),
+			Title:   titleFromFilename(e.Name()),
+			Source:  SourceFilename,
+		})
+	}
+
+	sort.SliceStable(c.Tracks, func(i, j int) bool {
+		a, b := c.Tracks[i], c.Tracks[j]
+		if a.Palette != b.Palette {
+			return a.Palette < b.Palette
+		}
+		if pa, pb := slices.Index(Phases, a.Phase), slices.Index(Phases, b.Phase); pa != pb {
+			return pa < pb
+		}
+		return a.File < b.File
+	})
+	return index(c), nil
+}
+
+// ReadManifest decodes the manifest in music. Unknown keys are rejected so
+// a typo does not silently drop metadata.
+func ReadManifest(music fs.FS) (Manifest, error) {
+	var m Manifest
+	data, err := fs.ReadFile(music, ManifestName)
+	if err != nil {
+		return m, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil && !errors.Is(err, io.EOF) {
+		return m, fmt.Errorf("%s: %w", ManifestName, err)
+	}
+	return m, nil
+}
+
+func (t Track) validate(files map[string]bool) error {
+	switch {
+	case t.File == "" || t.File != path.Base(t.File):
+		return fmt.Errorf("file must be a name inside the music directory")
+	case !files[t.File]:
+		return fmt.Errorf("file not found")
+	case t.Palette == "":
+		return fmt.Errorf("missing palette")
+	case !slices.Contains(Phases, t.Phase):
+		return fmt.Errorf("phase %q is not one of %s", t.Phase, strings.Join(Phases, ", "))
+	case t.Duration < 0:
+		return fmt.Errorf("duration must not be negative")
+	case t.BPM < 0:
+		return fmt.Errorf("bpm must not be negative")
+	case t.Weight < 0:
+		return fmt.Errorf("weight must not be negative")
+	case t.badRendition(files) != "":
+		return fmt.Errorf("rendition %q must be another file inside the music directory", t.badRendition(files))
+	case t.Energy < 0 || t.Energy > 1:
+		return fmt.Errorf("energy %v is outside [0, 1]", t.Energy)
+	case t.VocalRatio < 0 || t.VocalRatio > 1:
+		return fmt.Errorf("vocal_ratio %v is outside [0, 1]", t.VocalRatio)
+	}
+	return nil
+}
+
+func (t Track) badRendition(files map[string]bool) string {
+	for _, r := range t.Renditions {
+		if r == t.File || r != path.Base(r) || !files[r] {
+			return r
+		}
+	}
+	return ""
+}
+
+// firstListed returns the first file of t, its own or a rendition, that
+// is already in listed.
+func firstListed(listed map[string]bool, t Track) string {
+	for _, name := range append([]string{t.File}, t.Renditions...) {
+		if listed[name] {
+			return name
+		}
+	}
+	return ""
+}
+
+// Palette returns the tracks of one palette in phase order. The slice is
+// shared and must not be modified.
+func (c *Catalog) Palette(palette string) []Track {
+	return c.byPalette[palette]
+}
+
+// Variants returns the tracks of one palette and phase, ordered by file.
+// The slice is shared and must not be modified.
+func (c *Catalog) Variants(palette, phase string) []Track {
+	return c.byPhase[palette+"/"+phase]
+}
+
+// Track returns the track stored in file.
+func (c *Catalog) Track(file string) (Track, bool) {
+	t, ok := c.byFile[file]
+	return t, ok
+}
+
+// IsRetired reports whether the manifest retired file.
+func (c *Catalog) IsRetired(file string) bool {
+	return slices.Contains(c.Retired, file)
+}
+
+// Palettes summarises every palette that has a track or a manifest entry,
+// sorted by key.
+func (c *Catalog) Palettes() []Palette {
+	covered := make(map[string]map[string]bool)
+	for key := range c.Info {
+		covered[key] = make(map[string]bool)
+	}
+	for _, t := range c.Tracks {
+		if covered[t.Palette] == nil {
+			covered[t.Palette] = make(map[string]bool)
+		}
+		covered[t.Palette][t.Phase] = true
+	}
+
+	palettes := make([]Palette, 0, len(covered))
+	for key, phases := range covered {
+		info := c.Info[key]
+		p := Palette{Key: key, Name: info.Name, Description: info.Description, Phases: []string{}, Missing: []string{}}
+		if p.Name == "" {
+			first, size := utf8.DecodeRuneInString(key)
+			p.Name = string(unicode.ToUpper(first)) + key[size:]
+		}
+		for _, phase := range Phases {
+			switch {
+			case !phases[phase]:
+				p.Missing = append(p.Missing, phase)
+			case phase == "beacon":
+				p.Beacon = true
+			default:
+				p.Phases = append(p.Phases, phase)
+			}
+		}
+		p.Completeness = float64(len(Phases)-len(p.Missing)) / float64(len(Phases))
+		palettes = append(palettes, p)
+	}
+	sort.Slice(palettes, func(i, j int) bool { return palettes[i].Key < palettes[j].Key })
+	return palettes
+}
+
+// ParseFilename derives palette and phase from names such as
+// WARM_OPEN-FirstLight.m4a or WARM_Sonare.m4a (the beacon, whose variants
+// are named like WARM_Sonare-Short.m4a).
+func ParseFilename(filename string) (palette string, phase string, ok bool) {
+	ext := strings.ToLower(path.Ext(filename))
+	if ext != ".m4a" {
+		return "", "", false
+	}
+
+	base := strings.TrimSuffix(filename, path.Ext(filename))
+	parts := strings.SplitN(base, "_", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+
+	palette = NormalizePalette(parts[0])
+	if palette == "" {
+		return "", "", false
+	}
+
+	suffix := strings.ToUpper(parts[1])
+	switch {
+	case suffix == "SONARE" || strings.HasPrefix(suffix, "SONARE-"):
+		return palette, "beacon", true
+	case strings.HasPrefix(suffix, "OPEN-"):
+		return palette, "open", true
+	case strings.HasPrefix(suffix, "PEAK-"):
+		return palette, "peak", true
+	case strings.HasPrefix(suffix, "OFFPEAK-"):
+		return palette, "offpeak", true
+	case strings.HasPrefix(suffix, "CLOSE-"):
+		return palette, "close", true
+	default:
+		return "", "", false
+	}
+}
+
+// Filename returns the conventional name of a track, the inverse of
+// ParseFilename: WARM_OPEN-FirstLight.m4a, or WARM_Sonare.m4a for a beacon
+// (WARM_Sonare-Short.m4a for its other variants). ok is false when palette
+// or variant are not plain letters and digits, or the phase is unknown.
+func Filename(palette, phase, variant string) (name string, ok bool) {
+	palette = NormalizePalette(palette)
+	if !isAlnum(palette) || (variant != "" && !isAlnum(variant)) {
+		return "", false
+	}
+	prefix := strings.ToUpper(palette) + "_"
+	switch {
+	case phase == "beacon" && (variant == "" || variant == "Sonare"):
+		return prefix + "Sonare.m4a", true
+	case phase == "beacon":
+		return prefix + "Sonare-" + variant + ".m4a", true
+	case variant == "" || !slices.Contains(Phases, phase):
+		return "", false
+	}
+	return prefix + strings.ToUpper(phase) + "-" + variant + ".m4a", true
+}
+
+// VariantFromTitle turns "First Light" into FirstLight, a variant Filename
+// accepts.
+func VariantFromTitle(title string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(title, func(r rune) bool { return !isAlnumRune(r) }) {
+		runes := []rune(word)
+		b.WriteRune(unicode.ToUpper(runes[0]))
+		b.WriteString(string(runes[1:]))
+	}
+	return b.String()
+}
+
+func isAlnum(s string) bool {
+	return s != "" && strings.IndexFunc(s, func(r rune) bool { return !isAlnumRune(r) }) < 0
+}
+
+func isAlnumRune(r rune) bool {
+	return r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// NormalizePalette folds a palette name to its catalog key.
+func NormalizePalette(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// variantFromFilename returns the part of a track's name after the dash:
+// FirstLight for WARM_OPEN-FirstLight.m4a, and Sonare for a beacon named
+// WARM_Sonare.m4a.
+func variantFromFilename(filename string) string {
+	base := strings.TrimSuffix(filename, path.Ext(filename))
+	if _, rest, ok := strings.Cut(base, "-"); ok {
+		return rest
+	}
+	if _, rest, ok := strings.Cut(base, "_"); ok {
+		return rest
+	}
+	return base
+}
+
+// titleFromFilename turns WARM_OPEN-FirstLight.m4a into "First Light" and
+// a beacon into "Sonare".
+func titleFromFilename(filename string) string {
+	base := strings.TrimSuffix(filename, path.Ext(filename))
+	if _, rest, ok := strings.Cut(base, "-"); ok {
+		base = rest
+	} else if _, rest, ok := strings.Cut(base, "_"); ok {
+		base = rest
+	}
+
+	var b strings.Builder
+	runes := []rune(strings.NewReplacer("_", " ", "-", " ").Replace(base))
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && unicode.IsLower(runes[i-1]) {
+			b.WriteRune(' ')
+		}
+		b.WriteRune(r)
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
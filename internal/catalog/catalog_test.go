@@ -0,0 +1,374 @@
+package catalog
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestParseFilename(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		filename    string
+		wantPalette string
+		wantTrack   string
+		wantOK      bool
+	}{
+		{
+			name:        "open phase",
+			filename:    "WARM_OPEN-FirstLight.m4a",
+			wantPalette: "warm",
+			wantTrack:   "open",
+			wantOK:      true,
+		},
+		{
+			name:        "beacon case insensitive",
+			filename:    "modern_sonare.m4a",
+			wantPalette: "modern",
+			wantTrack:   "beacon",
+			wantOK:      true,
+		},
+		{
+			name:        "offpeak with uppercase extension",
+			filename:    "PREMIUM_OFFPEAK-DriftState.M4A",
+			wantPalette: "premium",
+			wantTrack:   "offpeak",
+			wantOK:      true,
+		},
+		{
+			name:        "beacon variant",
+			filename:    "WARM_Sonare-Short.m4a",
+			wantPalette: "warm",
+			wantTrack:   "beacon",
+			wantOK:      true,
+		},
+		{
+			name:     "invalid extension",
+			filename: "WARM_OPEN-FirstLight.mp3",
+			wantOK:   false,
+		},
+		{
+			name:     "missing separator",
+			filename: "WARMOPEN-FirstLight.m4a",
+			wantOK:   false,
+		},
+		{
+			name:     "unknown phase",
+			filename: "WARM_TRANSITION-Rise.m4a",
+			wantOK:   false,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			gotPalette, gotTrack, gotOK := ParseFilename(tc.filename)
+			if gotOK != tc.wantOK {
+				t.Fatalf("ok mismatch: got=%v want=%v", gotOK, tc.wantOK)
+			}
+			if !tc.wantOK {
+				return
+			}
+			if gotPalette != tc.wantPalette {
+				t.Fatalf("palette mismatch: got=%q want=%q", gotPalette, tc.wantPalette)
+			}
+			if gotTrack != tc.wantTrack {
+				t.Fatalf("track mismatch: got=%q want=%q", gotTrack, tc.wantTrack)
+			}
+		})
+	}
+}
+
+func TestLoadMergesManifestAndFilenames(t *testing.T) {
+	t.Parallel()
+
+	music := fstest.MapFS{
+		"catalog.yaml": {Data: []byte(`tracks:
+  - file: WARM_OPEN-FirstLight.m4a
+    palette: Warm
+    phase: open
+    title: First Light (Extended)
+    duration: 1m32s
+    bpm: 96
+    energy: 0.4
+    vocal_ratio: 0.1
+    license:
+      name: CC BY 4.0
+      attribution: Sonare Studio
+    renditions: [WARM_OPEN-FirstLight-64k.m4a]
+  - file: warm-intro.m4a
+    palette: warm
+    phase: peak
+  - file: MISSING.m4a
+    palette: warm
+    phase: close
+  - file: WARM_Sonare.m4a
+    palette: warm
+    phase: interlude
+`)},
+		"WARM_OPEN-FirstLight.m4a":     {Data: []byte("x")},
+		"WARM_OPEN-FirstLight-64k.m4a": {Data: []byte("x")},
+		"WARM_Sonare.m4a":              {Data: []byte("x")},
+		"WARM_OFFPEAK-DriftState.m4a":  {Data: []byte("x")},
+		"warm-intro.m4a":               {Data: []byte("x")},
+		"CALM_CLOSE-LastCall.m4a":      {Data: []byte("x")},
+		"README.txt":                   {Data: []byte("x")},
+	}
+	c, err := Load(music)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	type row struct{ file, phase, title, source string }
+	var got []row
+	for _, tr := range c.Palette("warm") {
+		got = append(got, row{tr.File, tr.Phase, tr.Title, tr.Source})
+	}
+	want := []row{
+		{"WARM_OPEN-FirstLight.m4a", "open", "First Light (Extended)", SourceManifest},
+		{"warm-intro.m4a", "peak", "intro", SourceManifest},
+		{"WARM_OFFPEAK-DriftState.m4a", "offpeak", "Drift State", SourceFilename},
+		{"WARM_Sonare.m4a", "beacon", "Sonare", SourceFilename},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("track count mismatch: got=%v want=%v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("track %d mismatch: got=%+v want=%+v", i, got[i], want[i])
+		}
+	}
+
+	open := c.Palette("warm")[0]
+	if open.Duration != 92*time.Second || open.BPM != 96 || open.License.Name != "CC BY 4.0" || len(open.Renditions) != 1 {
+		t.Errorf("metadata mismatch: got=%+v", open)
+	}
+	if len(c.Problems) != 2 || !strings.Contains(c.Problems[0], "file not found") || !strings.Contains(c.Problems[1], "phase") {
+		t.Errorf("problems mismatch: got=%q", c.Problems)
+	}
+	if !slices.Equal(c.Unmatched, []string{"README.txt"}) {
+		t.Errorf("unmatched mismatch: got=%q want=[README.txt]", c.Unmatched)
+	}
+}
+
+func TestLoadRejectsUnknownManifestKeys(t *testing.T) {
+	t.Parallel()
+
+	music := fstest.MapFS{
+		"catalog.yaml": {Data: []byte("tracks:\n  - file: A_Sonare.m4a\n    tempo: 90\n")},
+	}
+	if _, err := Load(music); err == nil {
+		t.Fatal("expected error for unknown manifest key")
+	}
+}
+
+func TestPalettes(t *testing.T) {
+	t.Parallel()
+
+	music := fstest.MapFS{
+		"catalog.yaml": {Data: []byte(`palettes:
+  Warm:
+    name: Analog Hearth
+    description: Acoustic warmth.
+  coming-soon: {}
+  émeraude: {}
+tracks: []
+`)},
+		"WARM_OPEN-FirstLight.m4a":    {Data: []byte("x")},
+		"WARM_PEAK-CoreFlow.m4a":      {Data: []byte("x")},
+		"WARM_OFFPEAK-DriftState.m4a": {Data: []byte("x")},
+		"WARM_CLOSE-LastCall.m4a":     {Data: []byte("x")},
+		"WARM_Sonare.m4a":             {Data: []byte("x")},
+		"LOUNGE_PEAK-Glow.m4a":        {Data: []byte("x")},
+	}
+	c, err := Load(music)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	got := c.Palettes()
+	if len(got) != 4 {
+		t.Fatalf("palette count mismatch: got=%+v", got)
+	}
+
+	tests := []struct {
+		key          string
+		name         string
+		phases       int
+		beacon       bool
+		completeness float64
+		complete     bool
+	}{
+		{key: "coming-soon", name: "Coming-soon", phases: 0, completeness: 0},
+		{key: "lounge", name: "Lounge", phases: 1, completeness: 0.2},
+		{key: "warm", name: "Analog Hearth", phases: 4, beacon: true, completeness: 1, complete: true},
+		{key: "émeraude", name: "Émeraude", phases: 0, completeness: 0},
+	}
+	for i, tt := range tests {
+		p := got[i]
+		if p.Key != tt.key || p.Name != tt.name || len(p.Phases) != tt.phases || p.Beacon != tt.beacon ||
+			p.Completeness != tt.completeness || p.Complete() != tt.complete {
+			t.Errorf("palette %d mismatch: got=%+v want=%+v", i, p, tt)
+		}
+	}
+	if got[2].Description != "Acoustic warmth." {
+		t.Errorf("description mismatch: got=%q", got[2].Description)
+	}
+}
+
+func TestIndexHoldsBackFilesBeingWritten(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	past := time.Now().Add(-time.Hour)
+	write := func(name string, modTime time.Time) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+			t.Fatalf("write %q: %v", name, err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("chtimes %q: %v", name, err)
+		}
+	}
+	files := func(c *Catalog) []string {
+		var names []string
+		for _, tr := range c.Tracks {
+			names = append(names, tr.File)
+		}
+		return names
+	}
+
+	write("WARM_OPEN-FirstLight.m4a", past)
+	x := NewIndex(os.DirFS(dir), time.Minute, nil)
+	if retry, err := x.Rescan(); err != nil || retry != 0 {
+		t.Fatalf("rescan: retry=%v err=%v", retry, err)
+	}
+	first := x.Catalog()
+	if _, ok := first.Track("WARM_OPEN-FirstLight.m4a"); !ok {
+		t.Fatalf("track missing: got=%v", files(first))
+	}
+
+	// Just modified: held back until it has been quiet for the settle time.
+	write("WARM_PEAK-CoreFlow.m4a", time.Now())
+	if retry, err := x.Rescan(); err != nil || retry <= 0 || x.Catalog() != first {
+		t.Fatalf("fresh file mismatch: retry=%v err=%v tracks=%v", retry, err, files(x.Catalog()))
+	}
+
+	// Reported as written to by the watcher, however old its modtime.
+	write("WARM_PEAK-CoreFlow.m4a", past)
+	x.writing["WARM_PEAK-CoreFlow.m4a"] = time.Now()
+	if retry, err := x.Rescan(); err != nil || retry <= 0 || x.Catalog() != first {
+		t.Fatalf("open file mismatch: retry=%v err=%v tracks=%v", retry, err, files(x.Catalog()))
+	}
+
+	delete(x.writing, "WARM_PEAK-CoreFlow.m4a")
+	if _, err := x.Rescan(); err != nil {
+		t.Fatalf("rescan: %v", err)
+	}
+	if got, want := files(x.Catalog()), []string{"WARM_OPEN-FirstLight.m4a", "WARM_PEAK-CoreFlow.m4a"}; !slices.Equal(got, want) {
+		t.Errorf("settled tracks mismatch: got=%v want=%v", got, want)
+	}
+	if got := files(first); len(got) != 1 {
+		t.Errorf("earlier snapshot changed: got=%v", got)
+	}
+
+	// Renamed into place whole: taken at once, until it changes again.
+	write("WARM_CLOSE-LastCall.m4a", time.Now())
+	x.Arrived("WARM_CLOSE-LastCall.m4a")
+	if retry, err := x.Rescan(); err != nil || retry != 0 {
+		t.Fatalf("arrived file mismatch: retry=%v err=%v", retry, err)
+	}
+	if _, ok := x.Catalog().Track("WARM_CLOSE-LastCall.m4a"); !ok {
+		t.Fatalf("arrived track missing: got=%v", files(x.Catalog()))
+	}
+	write("WARM_CLOSE-LastCall.m4a", time.Now())
+	if retry, err := x.Rescan(); err != nil || retry <= 0 {
+		t.Errorf("rewritten file mismatch: retry=%v err=%v", retry, err)
+	}
+}
+
+func TestIndexWatchPicksUpRenamedFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	// inotify reports the rename, so the file need not settle; elsewhere
+	// it looks like any fresh file.
+	settle := time.Hour
+	if runtime.GOOS != "linux" {
+		settle = 10 * time.Millisecond
+	}
+	x := NewIndex(os.DirFS(dir), settle, nil)
+	if _, err := x.Rescan(); err != nil {
+		t.Fatalf("rescan: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- x.Watch(ctx, dir, 20*time.Millisecond) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("watch: %v", err)
+		}
+	}()
+
+	// The watch may not be in place yet, so keep renaming a fresh copy in
+	// until one is seen.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		tmp := filepath.Join(dir, ".upload.tmp")
+		if err := os.WriteFile(tmp, []byte("x"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, "WARM_Sonare.m4a")); err != nil {
+			t.Fatalf("rename: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if _, ok := x.Catalog().Track("WARM_Sonare.m4a"); ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("renamed file never appeared in the catalog")
+		}
+	}
+}
+
+func TestPick(t *testing.T) {
+	t.Parallel()
+
+	tracks := []Track{{File: "a.m4a"}, {File: "b.m4a", Weight: 1e9}, {File: "c.m4a"}}
+	for turn, want := range []string{"a.m4a", "b.m4a", "c.m4a", "a.m4a"} {
+		if got := Pick(tracks, StrategyRoundRobin, uint64(turn)).File; got != want {
+			t.Errorf("round-robin turn %d mismatch: got=%s want=%s", turn, got, want)
+		}
+	}
+	if got := Pick(tracks, StrategyFirst, 7).File; got != "a.m4a" {
+		t.Errorf("first mismatch: got=%s want=a.m4a", got)
+	}
+
+	heavy := 0
+	for range 100 {
+		switch Pick(tracks, StrategyWeighted, 0).File {
+		case "b.m4a":
+			heavy++
+		case "a.m4a", "c.m4a":
+		default:
+			t.Fatal("weighted pick returned an unknown track")
+		}
+		if got := Pick(tracks, StrategyRandom, 0).File; !slices.ContainsFunc(tracks, func(t Track) bool { return t.File == got }) {
+			t.Fatalf("random pick returned an unknown track %q", got)
+		}
+	}
+	if heavy < 99 {
+		t.Errorf("weighted picks mismatch: got=%d/100 of the heavy track", heavy)
+	}
+}
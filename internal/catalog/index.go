@@ -0,0 +1,245 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// writeTimeout is how long a file the watcher saw written to, but never
+// saw closed, is held back; it covers writers that truncate or stall.
+const writeTimeout = time.Minute
+
+// Index keeps the catalog of a music directory in memory. Readers get the
+// current snapshot without locking; rescans build a new one and swap it in
+// whole, so a request never sees a half-updated catalog.
+//
+// Files still being written are left out of a rescan until they settle:
+// those the watcher has seen written to and not yet closed, and any
+// modified within the last settle interval. The exception is a file
+// renamed into place whole, which the watcher reports (or a writer
+// declares with Arrived): it is picked up at once.
+type Index struct {
+	music  fs.FS
+	settle time.Duration
+	notify func(*Catalog, error)
+
+	current atomic.Pointer[Catalog]
+
+	// mu serialises rescans and guards the fields below.
+	mu sync.Mutex
+	// writing holds when the watcher last saw each file written to;
+	// entries are dropped when the writer closes the file.
+	writing map[string]time.Time
+	// arrived holds the size and modification time of files renamed into
+	// place, which need not settle for as long as they stay unchanged.
+	arrived map[string]stamp
+	// signature identifies the directory state behind current.
+	signature string
+}
+
+// NewIndex returns an index of music holding an empty catalog until the
+// first Rescan. notify, if set, is called after every rescan that swapped
+// in a new catalog or failed, with the catalog now being served.
+func NewIndex(music fs.FS, settle time.Duration, notify func(*Catalog, error)) *Index {
+	x := &Index{music: music, settle: settle, notify: notify, writing: make(map[string]time.Time), arrived: make(map[string]stamp)}
+	x.current.Store(index(&Catalog{Info: make(map[string]PaletteInfo)}))
+	return x
+}
+
+// Catalog returns the current snapshot. It must not be modified.
+func (x *Index) Catalog() *Catalog {
+	return x.current.Load()
+}
+
+// stamp identifies one version of a file.
+type stamp struct {
+	size int64
+	mod  time.Time
+}
+
+// Arrived records that the named files were just renamed into the
+// directory whole, so the next rescan takes them without waiting for them
+// to settle. A later change to a file undoes this.
+func (x *Index) Arrived(names ...string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, name := range names {
+		x.markArrived(name)
+	}
+}
+
+// markArrived records name's current version as complete. x.mu is held.
+func (x *Index) markArrived(name string) {
+	delete(x.writing, name)
+	info, err := fs.Stat(x.music, name)
+	if err != nil {
+		delete(x.arrived, name)
+		return
+	}
+	x.arrived[name] = stamp{size: info.Size(), mod: info.ModTime()}
+}
+
+// Refresh rescans now and, while files are held back, again once they
+// should have settled. It returns the first rescan's error; later ones
+// only reach notify.
+func (x *Index) Refresh() error {
+	retry, err := x.Rescan()
+	if err == nil && retry > 0 {
+		time.AfterFunc(retry, func() { x.Refresh() })
+	}
+	return err
+}
+
+// Rescan reloads the directory if it changed since the last rescan. When
+// a file was held back because it is still being written, it returns how
+// long to wait before trying again. On error the previous catalog stays.
+func (x *Index) Rescan() (retry time.Duration, err error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	defer func() {
+		if err != nil && x.notify != nil {
+			x.notify(x.current.Load(), err)
+		}
+	}()
+
+	entries, err := fs.ReadDir(x.music, ".")
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	hidden := make(map[string]bool)
+	listed := make(map[string]bool, len(entries))
+	var sig strings.Builder
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		listed[e.Name()] = true
+		info, err := e.Info()
+		if err != nil {
+			// Removed since the listing was read.
+			hidden[e.Name()] = true
+			continue
+		}
+		age := now.Sub(info.ModTime())
+		whole := false
+		if s, ok := x.arrived[e.Name()]; ok {
+			whole = s.size == info.Size() && s.mod.Equal(info.ModTime())
+			if !whole || age >= x.settle {
+				delete(x.arrived, e.Name())
+			}
+		}
+		if written, ok := x.writing[e.Name()]; ok && now.Sub(written) < writeTimeout {
+			hidden[e.Name()] = true
+			retry = max(retry, x.settle)
+			continue
+		}
+		if !whole && !info.ModTime().IsZero() && age >= 0 && age < x.settle {
+			hidden[e.Name()] = true
+			retry = max(retry, x.settle-age, time.Millisecond)
+			continue
+		}
+		fmt.Fprintf(&sig, "%s|%d|%d\n", e.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	for name := range x.arrived {
+		if !listed[name] {
+			delete(x.arrived, name)
+		}
+	}
+	if hidden[ManifestName] {
+		// Entries may refer to files the half-written manifest has not
+		// listed yet; wait for the whole of it.
+		return retry, nil
+	}
+	if sig.String() == x.signature {
+		return retry, nil
+	}
+
+	// A failed load is not retried until the directory changes again.
+	x.signature = sig.String()
+	c, err := Load(settledFS{x.music, hidden})
+	if err != nil {
+		return retry, err
+	}
+	x.current.Store(c)
+	if x.notify != nil {
+		x.notify(c, nil)
+	}
+	return retry, nil
+}
+
+// watchLoop rescans debounce after the last event, and again whenever a
+// rescan held a file back, until ctx is done or events is closed.
+func (x *Index) watchLoop(ctx context.Context, events <-chan fileEvent, debounce time.Duration) {
+	timer := time.NewTimer(debounce)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			x.mu.Lock()
+			switch {
+			case ev.writing:
+				x.writing[ev.name] = time.Now()
+			case ev.arrived:
+				x.markArrived(ev.name)
+			default:
+				delete(x.writing, ev.name)
+			}
+			x.mu.Unlock()
+			timer.Reset(debounce)
+		case <-timer.C:
+			if retry, err := x.Rescan(); err == nil && retry > 0 {
+				timer.Reset(max(retry, debounce))
+			}
+		}
+	}
+}
+
+// fileEvent is one change in the watched directory: writing is set while
+// the file is being written and cleared by anything that ends it (close,
+// rename or removal), and arrived is set when a file is renamed into the
+// directory.
+type fileEvent struct {
+	name    string
+	writing bool
+	arrived bool
+}
+
+// index fills in the lookup tables of a freshly loaded catalog.
+func index(c *Catalog) *Catalog {
+	c.byPalette = make(map[string][]Track)
+	c.byPhase = make(map[string][]Track)
+	c.byFile = make(map[string]Track, len(c.Tracks))
+	for _, t := range c.Tracks {
+		c.byPalette[t.Palette] = append(c.byPalette[t.Palette], t)
+		c.byPhase[t.Palette+"/"+t.Phase] = append(c.byPhase[t.Palette+"/"+t.Phase], t)
+		c.byFile[t.File] = t
+	}
+	return c
+}
+
+// settledFS hides files that are still being written from directory
+// listings.
+type settledFS struct {
+	fs.FS
+	hidden map[string]bool
+}
+
+func (s settledFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(s.FS, name)
+	if err != nil || name != "." {
+		return entries, err
+	}
+	return slices.DeleteFunc(entries, func(e fs.DirEntry) bool { return s.hidden[e.Name()] }), nil
+}
@@ -0,0 +1,446 @@
+package catalog
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+
+	"sonare.media/internal/mp4"
+)
+
+// Errors of Library operations.
+var (
+	ErrTrackExists  = errors.New("track already exists")
+	ErrUnknownTrack = errors.New("unknown track")
+	ErrTooLarge     = errors.New("audio file too large")
+	// ErrInvalid wraps every rejection of the caller's input.
+	ErrInvalid = errors.New("invalid track")
+)
+
+// Library edits the music directory on disk: it stores uploaded audio and
+// keeps the manifest in step. Every file is written under a temporary
+// name and renamed into place, so an Index never lists half a file, and
+// manifest edits keep the operator's comments and ordering.
+type Library struct {
+	dir     string
+	music   fs.FS
+	retired string
+	limit   int64
+
+	// mu serialises edits, which read the manifest and write it back.
+	mu sync.Mutex
+}
+
+// NewLibrary returns a library writing to dir. music is the directory as
+// the server reads it, which may add files (such as embedded ones) that
+// dir does not have. Retired files in dir are moved to retiredDir. Audio
+// files larger than limit bytes are refused.
+func NewLibrary(dir string, music fs.FS, retiredDir string, limit int64) *Library {
+	return &Library{dir: dir, music: music, retired: retiredDir, limit: limit}
+}
+
+// Add stores audio as a new track. The file is named after the track's
+// palette, phase and variant (see Filename); a missing variant is taken
+// from the title. The returned track is the manifest entry as written.
+func (l *Library) Add(t Track, audio io.Reader) (Track, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	t.Palette = NormalizePalette(t.Palette)
+	t.Phase = strings.ToLower(strings.TrimSpace(t.Phase))
+	if t.Variant == "" && t.Phase != "beacon" {
+		t.Variant = VariantFromTitle(t.Title)
+	}
+	name, ok := Filename(t.Palette, t.Phase, t.Variant)
+	if !ok {
+		return Track{}, fmt.Errorf("%w: palette and variant must be letters and digits, and phase one of %v", ErrInvalid, Phases)
+	}
+	t.File = name
+
+	m, err := l.readManifest()
+	if err != nil {
+		return Track{}, err
+	}
+	if _, err := fs.Stat(l.music, name); err == nil && !slices.Contains(m.retired(), name) {
+		return Track{}, fmt.Errorf("%w: %s", ErrTrackExists, name)
+	}
+	files, err := l.files()
+	if err != nil {
+		return Track{}, err
+	}
+	files[name] = true
+	if err := t.validate(files); err != nil {
+		return Track{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	if err := l.writeAudio(name, audio); err != nil {
+		return Track{}, err
+	}
+	m.unretire(name)
+	t = m.put(t)
+	return t, l.writeManifest(m)
+}
+
+// Replace swaps the audio of a track and keeps its metadata, except the
+// stated duration, which may no longer hold. It returns the track as the
+// catalog will load it.
+func (l *Library) Replace(file string, audio io.Reader) (Track, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	t, m, err := l.entry(file)
+	if err != nil {
+		return Track{}, err
+	}
+	if err := l.writeAudio(file, audio); err != nil {
+		return Track{}, err
+	}
+	if t.Duration == 0 {
+		return t, nil
+	}
+	t.Duration = 0
+	t = m.put(t)
+	return t, l.writeManifest(m)
+}
+
+// Update applies edit to a track's metadata and writes it to the
+// manifest. A track known only from its file name gets an entry. The
+// file itself is never renamed.
+func (l *Library) Update(file string, edit func(*Track)) (Track, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	t, m, err := l.entry(file)
+	if err != nil {
+		return Track{}, err
+	}
+	edit(&t)
+	t.File = file
+	t.Palette = NormalizePalette(t.Palette)
+	t.Phase = strings.ToLower(strings.TrimSpace(t.Phase))
+	files, err := l.files()
+	if err != nil {
+		return Track{}, err
+	}
+	if err := t.validate(files); err != nil {
+		return Track{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	t = m.put(t)
+	return t, l.writeManifest(m)
+}
+
+// Retire takes a track and its renditions out of the catalog. All of
+// them are listed as retired in the manifest, which also keeps copies
+// outside the directory, such as embedded ones, from coming back; then
+// files in the directory are moved to the retired directory. The
+// manifest goes first so that a failed move leaves a file behind that is
+// already hidden, rather than a track whose audio has gone.
+func (l *Library) Retire(file string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	t, m, err := l.entry(file)
+	if err != nil {
+		return err
+	}
+	names := append([]string{file}, t.Renditions...)
+	m.remove(file)
+	for _, name := range names {
+		m.retire(name)
+	}
+	if err := l.writeManifest(m); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := l.moveToRetired(name); err != nil {
+			return fmt.Errorf("%s retired but left in place: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// entry returns a catalogued track as the catalog loads it, and the
+// manifest.
+func (l *Library) entry(file string) (Track, *manifestDoc, error) {
+	m, err := l.readManifest()
+	if err != nil {
+		return Track{}, nil, err
+	}
+	t, ok := m.find(file)
+	t.Source = SourceManifest
+	if !ok {
+		// Not in the manifest: a track imported by name, unless retired.
+		palette, phase, ok := ParseFilename(file)
+		if _, err := fs.Stat(l.music, file); err != nil || !ok || slices.Contains(m.retired(), file) {
+			return Track{}, nil, fmt.Errorf("%w: %s", ErrUnknownTrack, file)
+		}
+		t = Track{File: file, Palette: palette, Phase: phase, Source: SourceFilename}
+	}
+	if t.Title == "" {
+		t.Title = titleFromFilename(file)
+	}
+	if t.Variant == "" {
+		t.Variant = variantFromFilename(file)
+	}
+	return t, m, nil
+}
+
+func (l *Library) files() (map[string]bool, error) {
+	entries, err := fs.ReadDir(l.music, ".")
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			files[e.Name()] = true
+		}
+	}
+	return files, nil
+}
+
+// writeAudio stores audio as name once it has been read in full and
+// parsed as an MP4 file with an audio track.
+func (l *Library) writeAudio(name string, audio io.Reader) error {
+	br := bufio.NewReader(audio)
+	// Every MP4 file opens with an ftyp box; refuse anything else before
+	// storing a byte of it.
+	if head, err := br.Peek(8); err != nil || string(head[4:8]) != "ftyp" {
+		return fmt.Errorf("%w: %v", ErrInvalid, mp4.ErrNotMP4)
+	}
+	return l.writeFile(name, io.LimitReader(br, l.limit+1), func(f *os.File, size int64) error {
+		if size > l.limit {
+			return fmt.Errorf("%w: over %d bytes", ErrTooLarge, l.limit)
+		}
+		if _, err := mp4.Read(f, size); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		return nil
+	})
+}
+
+// writeFile writes r to name in the directory through a temporary file,
+// which check may reject before it is renamed into place.
+func (l *Library) writeFile(name string, r io.Reader, check func(f *os.File, size int64) error) (err error) {
+	// The temporary name is hidden, so an Index does not list it.
+	f, err := os.CreateTemp(l.dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+	if check != nil {
+		if err := check(f, size); err != nil {
+			return err
+		}
+	}
+	if err := f.Chmod(0o644); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(l.dir, name))
+}
+
+// moveToRetired moves name out of the directory, if it is there.
+func (l *Library) moveToRetired(name string) error {
+	src := filepath.Join(l.dir, name)
+	if _, err := os.Lstat(src); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err := os.MkdirAll(l.retired, 0o755); err != nil {
+		return err
+	}
+	dst := filepath.Join(l.retired, name)
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	// The retired directory may be on another filesystem.
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+func (l *Library) readManifest() (*manifestDoc, error) {
+	data, err := fs.ReadFile(l.music, ManifestName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return newManifestDoc(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Decode into the typed form first so unknown keys are refused as
+	// they are when the catalog loads.
+	if _, err := ReadManifest(l.music); err != nil {
+		return nil, err
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("%s: %w", ManifestName, err)
+	}
+	if len(root.Content) == 0 {
+		return newManifestDoc(), nil
+	}
+	if root.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: not a mapping", ManifestName)
+	}
+	return &manifestDoc{root: &root}, nil
+}
+
+func (l *Library) writeManifest(m *manifestDoc) error {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(m.root); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	return l.writeFile(ManifestName, &buf, nil)
+}
+
+// manifestDoc is the manifest as a YAML document, edited in place so that
+// comments and the order of entries survive.
+type manifestDoc struct {
+	root *yaml.Node
+}
+
+func newManifestDoc() *manifestDoc {
+	return &manifestDoc{root: &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}}
+}
+
+// list returns the sequence under key, creating it if create is set.
+func (m *manifestDoc) list(key string, create bool) *yaml.Node {
+	mapping := m.root.Content[0]
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	if !create {
+		return nil
+	}
+	seq := &yaml.Node{Kind: yaml.SequenceNode}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, seq)
+	return seq
+}
+
+// index returns the position of file's entry in the tracks list, or -1.
+func (m *manifestDoc) index(file string) int {
+	tracks := m.list("tracks", false)
+	if tracks == nil {
+		return -1
+	}
+	for i, n := range tracks.Content {
+		var t Track
+		if n.Decode(&t) == nil && t.File == file {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *manifestDoc) find(file string) (Track, bool) {
+	i := m.index(file)
+	if i < 0 {
+		return Track{}, false
+	}
+	var t Track
+	m.list("tracks", false).Content[i].Decode(&t)
+	return t, true
+}
+
+// put writes t as file's entry, replacing any existing one, and returns
+// it as the catalog will load it. Fields that merely repeat what the file
+// name implies are left out of the entry.
+func (m *manifestDoc) put(t Track) Track {
+	entry := t
+	if entry.Title == titleFromFilename(entry.File) {
+		entry.Title = ""
+	}
+	if entry.Variant == variantFromFilename(entry.File) {
+		entry.Variant = ""
+	}
+	entry.Source = ""
+	var n yaml.Node
+	n.Encode(entry)
+
+	tracks := m.list("tracks", true)
+	// "tracks: []" would otherwise stay on one line.
+	tracks.Style = 0
+	if i := m.index(t.File); i >= 0 {
+		// Keep the comments written around the entry.
+		old := tracks.Content[i]
+		n.HeadComment, n.LineComment, n.FootComment = old.HeadComment, old.LineComment, old.FootComment
+		tracks.Content[i] = &n
+	} else {
+		tracks.Content = append(tracks.Content, &n)
+	}
+	t.Source = SourceManifest
+	return t
+}
+
+func (m *manifestDoc) remove(file string) {
+	if i := m.index(file); i >= 0 {
+		tracks := m.list("tracks", false)
+		tracks.Content = slices.Delete(tracks.Content, i, i+1)
+	}
+}
+
+func (m *manifestDoc) retired() []string {
+	var names []string
+	if seq := m.list("retired", false); seq != nil {
+		seq.Decode(&names)
+	}
+	return names
+}
+
+func (m *manifestDoc) retire(file string) {
+	if !slices.Contains(m.retired(), file) {
+		seq := m.list("retired", true)
+		seq.Content = append(seq.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: file})
+	}
+}
+
+func (m *manifestDoc) unretire(file string) {
+	if seq := m.list("retired", false); seq != nil {
+		seq.Content = slices.DeleteFunc(seq.Content, func(n *yaml.Node) bool { return n.Value == file })
+	}
+}
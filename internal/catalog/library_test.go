@@ -0,0 +1,175 @@
+package catalog
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLibraryEditsDirectoryAndManifest(t *testing.T) {
+	t.Parallel()
+
+	audio, err := os.ReadFile("../../web/music/WARM_Sonare.m4a")
+	if err != nil {
+		t.Fatalf("read preview: %v", err)
+	}
+	dir, retired := t.TempDir(), t.TempDir()
+	manifest := "# Curated by the music team.\ntracks: []\n"
+	if err := os.WriteFile(filepath.Join(dir, ManifestName), []byte(manifest), 0o644); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "WARM_PEAK-Glow.m4a"), audio, 0o644); err != nil {
+		t.Fatalf("write track: %v", err)
+	}
+	music := os.DirFS(dir)
+	lib := NewLibrary(dir, music, retired, int64(len(audio)))
+
+	added, err := lib.Add(Track{Palette: "Lounge", Phase: "open", Title: "Night Swim", BPM: 100}, bytes.NewReader(audio))
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if added.File != "LOUNGE_OPEN-NightSwim.m4a" || added.Title != "Night Swim" {
+		t.Errorf("added track mismatch: got=%q %q want=LOUNGE_OPEN-NightSwim.m4a \"Night Swim\"", added.File, added.Title)
+	}
+	for name, tt := range map[string]struct {
+		track Track
+		audio []byte
+		want  error
+	}{
+		"existing":  {Track{Palette: "lounge", Phase: "open", Variant: "NightSwim"}, audio, ErrTrackExists},
+		"not mp4":   {Track{Palette: "lounge", Phase: "peak", Variant: "Text"}, []byte("hello, this is not audio"), ErrInvalid},
+		"too large": {Track{Palette: "lounge", Phase: "peak", Variant: "Big"}, append(audio, 0), ErrTooLarge},
+		"bad name":  {Track{Palette: "lounge_bar", Phase: "peak", Variant: "Glow"}, audio, ErrInvalid},
+	} {
+		if _, err := lib.Add(tt.track, bytes.NewReader(tt.audio)); !errors.Is(err, tt.want) {
+			t.Errorf("%s: add error mismatch: got=%v want=%v", name, err, tt.want)
+		}
+	}
+
+	updated, err := lib.Update("WARM_PEAK-Glow.m4a", func(t *Track) { t.Energy = 0.8 })
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if updated.Title != "Glow" || updated.Variant != "Glow" {
+		t.Errorf("updated track mismatch: got=%q %q want=\"Glow\" \"Glow\"", updated.Title, updated.Variant)
+	}
+	if _, err := lib.Update("WARM_PEAK-Glow.m4a", func(t *Track) { t.Energy = 2 }); !errors.Is(err, ErrInvalid) {
+		t.Errorf("update error mismatch: got=%v want=%v", err, ErrInvalid)
+	}
+	if _, err := lib.Replace("WARM_PEAK-Glow.m4a", strings.NewReader("not audio")); !errors.Is(err, ErrInvalid) {
+		t.Errorf("replace error mismatch: got=%v want=%v", err, ErrInvalid)
+	}
+
+	c, err := Load(music)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if tr, ok := c.Track("LOUNGE_OPEN-NightSwim.m4a"); !ok || tr.Title != "Night Swim" || tr.BPM != 100 || tr.Source != SourceManifest {
+		t.Errorf("added track mismatch: got=%+v", tr)
+	}
+	if tr, ok := c.Track("WARM_PEAK-Glow.m4a"); !ok || tr.Energy != 0.8 || tr.Title != "Glow" {
+		t.Errorf("updated track mismatch: got=%+v", tr)
+	}
+	if len(c.Problems) != 0 || len(c.Unmatched) != 0 {
+		t.Errorf("load reported problems=%q unmatched=%q", c.Problems, c.Unmatched)
+	}
+
+	if err := lib.Retire("LOUNGE_OPEN-NightSwim.m4a"); err != nil {
+		t.Fatalf("retire: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(retired, "LOUNGE_OPEN-NightSwim.m4a")); err != nil {
+		t.Errorf("retired file not moved: %v", err)
+	}
+	if c, err = Load(music); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if _, ok := c.Track("LOUNGE_OPEN-NightSwim.m4a"); ok || !c.IsRetired("LOUNGE_OPEN-NightSwim.m4a") {
+		t.Errorf("retired track still listed: tracks=%v retired=%v", c.Tracks, c.Retired)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			t.Errorf("temporary file left behind: %s", e.Name())
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "# Curated by the music team.\n") {
+		t.Errorf("manifest comment lost:\n%s", data)
+	}
+}
+
+func TestLibraryRetireHidesTrackWhenMoveFails(t *testing.T) {
+	t.Parallel()
+
+	audio, err := os.ReadFile("../../web/music/WARM_Sonare.m4a")
+	if err != nil {
+		t.Fatalf("read preview: %v", err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "WARM_PEAK-Glow.m4a"), audio, 0o644); err != nil {
+		t.Fatalf("write track: %v", err)
+	}
+	// A file where the retired directory should be makes every move fail.
+	retired := filepath.Join(t.TempDir(), "retired")
+	if err := os.WriteFile(retired, nil, 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	music := os.DirFS(dir)
+
+	if err := NewLibrary(dir, music, retired, int64(len(audio))).Retire("WARM_PEAK-Glow.m4a"); err == nil {
+		t.Fatal("expected the move to fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "WARM_PEAK-Glow.m4a")); err != nil {
+		t.Errorf("unmoved file missing: %v", err)
+	}
+	c, err := Load(music)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if _, ok := c.Track("WARM_PEAK-Glow.m4a"); ok || !c.IsRetired("WARM_PEAK-Glow.m4a") {
+		t.Errorf("track not retired: tracks=%v retired=%v", c.Tracks, c.Retired)
+	}
+}
+
+func TestFilename(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		palette, phase, variant string
+		want                    string
+	}{
+		{"Warm", "open", "FirstLight", "WARM_OPEN-FirstLight.m4a"},
+		{"warm", "offpeak", "Drift2", "WARM_OFFPEAK-Drift2.m4a"},
+		{"warm", "beacon", "", "WARM_Sonare.m4a"},
+		{"warm", "beacon", "Short", "WARM_Sonare-Short.m4a"},
+		{"warm", "open", "", ""},
+		{"warm", "intro", "A", ""},
+		{"warm-ish", "open", "A", ""},
+		{"warm", "open", "First Light", ""},
+	}
+	for _, tt := range tests {
+		got, ok := Filename(tt.palette, tt.phase, tt.variant)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("Filename(%q, %q, %q) mismatch: got=%q want=%q", tt.palette, tt.phase, tt.variant, got, tt.want)
+			continue
+		}
+		if ok {
+			if palette, phase, _ := ParseFilename(got); palette != NormalizePalette(tt.palette) || phase != tt.phase {
+				t.Errorf("ParseFilename(%q) mismatch: got=%s/%s", got, palette, phase)
+			}
+		}
+	}
+	if got := VariantFromTitle("first light (extended)"); got != "FirstLightExtended" {
+		t.Errorf("variant mismatch: got=%q want=FirstLightExtended", got)
+	}
+}
@@ -0,0 +1,52 @@
+package catalog
+
+import "math/rand/v2"
+
+// Selection strategies for choosing among the variants of a phase.
+const (
+	// StrategyFirst always picks the first variant by file name.
+	StrategyFirst = "first"
+	// StrategyRandom picks uniformly at random.
+	StrategyRandom = "random"
+	// StrategyWeighted picks at random in proportion to Track.Weight.
+	StrategyWeighted = "weighted"
+	// StrategyRoundRobin steps through the variants as the visitor's turn
+	// counter advances, so repeat visits hear each in turn.
+	StrategyRoundRobin = "round-robin"
+)
+
+// Strategies lists the valid strategies.
+var Strategies = []string{StrategyFirst, StrategyRandom, StrategyWeighted, StrategyRoundRobin}
+
+// Pick chooses one of tracks, the variants of a single phase, by strategy;
+// turn is the visitor's counter for StrategyRoundRobin. Unknown strategies
+// behave like StrategyFirst. tracks must not be empty.
+func Pick(tracks []Track, strategy string, turn uint64) Track {
+	switch strategy {
+	case StrategyRandom:
+		return tracks[rand.IntN(len(tracks))]
+	case StrategyWeighted:
+		total := 0.0
+		for _, t := range tracks {
+			total += t.weight()
+		}
+		r := rand.Float64() * total
+		for _, t := range tracks {
+			if r -= t.weight(); r < 0 {
+				return t
+			}
+		}
+		return tracks[len(tracks)-1]
+	case StrategyRoundRobin:
+		return tracks[turn%uint64(len(tracks))]
+	default:
+		return tracks[0]
+	}
+}
+
+func (t Track) weight() float64 {
+	if t.Weight == 0 {
+		return 1
+	}
+	return t.Weight
+}
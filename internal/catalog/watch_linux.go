@@ -0,0 +1,70 @@
+//go:build linux
+
+package catalog
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotifyMask selects the events that can change what a rescan sees.
+const inotifyMask = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE | unix.IN_ATTRIB |
+	unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+
+// Watch keeps the index current with dir, the directory on disk behind
+// its filesystem, using inotify. It rescans debounce after the last change
+// and returns once ctx is done.
+func (x *Index) Watch(ctx context.Context, dir string, debounce time.Duration) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("inotify: %w", err)
+	}
+	if _, err := unix.InotifyAddWatch(fd, dir, inotifyMask); err != nil {
+		unix.Close(fd)
+		return fmt.Errorf("watch %s: %w", dir, err)
+	}
+	// A non-blocking descriptor goes through the runtime poller, so Close
+	// wakes the reader below.
+	f := os.NewFile(uintptr(fd), "inotify")
+	stop := context.AfterFunc(ctx, func() { f.Close() })
+	defer stop()
+
+	events := make(chan fileEvent, 64)
+	go func() {
+		defer close(events)
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			for off := 0; off+unix.SizeofInotifyEvent <= n; {
+				// struct inotify_event: wd, mask, cookie, len, name[len].
+				mask := binary.NativeEndian.Uint32(buf[off+4:])
+				nameLen := int(binary.NativeEndian.Uint32(buf[off+12:]))
+				start := off + unix.SizeofInotifyEvent
+				off = start + nameLen
+				if off > n || mask&unix.IN_ISDIR != 0 {
+					continue
+				}
+				name := strings.TrimRight(string(buf[start:off]), "\x00")
+				ev := fileEvent{name: name, writing: mask&unix.IN_MODIFY != 0, arrived: mask&unix.IN_MOVED_TO != 0}
+				select {
+				case events <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	x.watchLoop(ctx, events, debounce)
+	f.Close()
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+
+package catalog
+
+import (
+	"context"
+	"time"
+)
+
+// Watch keeps the index current by rescanning every debounce until ctx is
+// done. Without inotify there is no sign of a file being written other
+// than its modification time, which Rescan already checks.
+func (x *Index) Watch(ctx context.Context, dir string, debounce time.Duration) error {
+	ticker := time.NewTicker(debounce)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			x.Rescan()
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEConfig describes how certificates are obtained from an ACME CA.
+type ACMEConfig struct {
+	// DirectoryURL is the CA's directory endpoint. Point it at a local
+	// Pebble instance to exercise issuance without touching the network.
+	DirectoryURL string
+	// Email is registered with the account for expiry notices.
+	Email string
+	// Hosts are the only names certificates will be requested for.
+	Hosts []string
+	// CacheDir persists the account key and issued certificates.
+	CacheDir string
+	// CAFile, if set, is a PEM bundle trusted for talking to the directory,
+	// e.g. Pebble's test root.
+	CAFile string
+	// OnCertificate, if set, is called whenever a certificate is served
+	// for a host for the first time after issuance or renewal.
+	OnCertificate func(host string, leaf *x509.Certificate)
+}
+
+// ACME obtains and renews certificates through HTTP-01 and TLS-ALPN-01.
+type ACME struct {
+	manager  *autocert.Manager
+	onCert   func(string, *x509.Certificate)
+	leaf     atomic.Pointer[x509.Certificate]
+	lastSeen atomic.Pointer[[]byte]
+}
+
+// NewACME validates cfg and prepares the certificate manager. Nothing is
+// requested from the CA until the first handshake for a configured host.
+func NewACME(cfg ACMEConfig) (*ACME, error) {
+	if len(cfg.Hosts) == 0 {
+		return nil, errors.New("acme: at least one host is required")
+	}
+	if cfg.CacheDir == "" {
+		return nil, errors.New("acme: cache directory is required")
+	}
+	if err := os.MkdirAll(cfg.CacheDir, 0o700); err != nil {
+		return nil, fmt.Errorf("acme: create cache dir: %w", err)
+	}
+
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("acme: read CA file: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("acme: no certificates found in %q", cfg.CAFile)
+		}
+		httpClient.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: roots},
+		}
+	}
+
+	directory := cfg.DirectoryURL
+	if directory == "" {
+		directory = autocert.DefaultACMEDirectory
+	}
+
+	return &ACME{
+		manager: &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(cfg.CacheDir),
+			HostPolicy: autocert.HostWhitelist(cfg.Hosts...),
+			Email:      cfg.Email,
+			Client: &acme.Client{
+				DirectoryURL: directory,
+				HTTPClient:   httpClient,
+			},
+		},
+		onCert: cfg.OnCertificate,
+	}, nil
+}
+
+// HTTPHandler answers HTTP-01 challenges and passes every other request to
+// fallback (the :80 redirect server).
+func (a *ACME) HTTPHandler(fallback http.Handler) http.Handler {
+	return a.manager.HTTPHandler(fallback)
+}
+
+// TLSConfig serves managed certificates and answers TLS-ALPN-01 challenges.
+func (a *ACME) TLSConfig() *tls.Config {
+	cfg := a.manager.TLSConfig()
+	cfg.GetCertificate = a.GetCertificate
+	return cfg
+}
+
+// GetCertificate returns the managed certificate for the handshake's server
+// name, obtaining or renewing it first when needed.
+func (a *ACME) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, err := a.manager.GetCertificate(hello)
+	if err != nil || cert == nil || len(cert.Certificate) == 0 {
+		return cert, err
+	}
+
+	// Challenge certificates for TLS-ALPN-01 are not the served identity.
+	for _, proto := range hello.SupportedProtos {
+		if proto == acme.ALPNProto {
+			return cert, nil
+		}
+	}
+
+	der := cert.Certificate[0]
+	if last := a.lastSeen.Load(); last != nil && string(*last) == string(der) {
+		return cert, nil
+	}
+	leaf := cert.Leaf
+	if leaf == nil {
+		if leaf, err = x509.ParseCertificate(der); err != nil {
+			return cert, nil
+		}
+	}
+	a.leaf.Store(leaf)
+	a.lastSeen.Store(&der)
+	if a.onCert != nil {
+		a.onCert(hello.ServerName, leaf)
+	}
+	return cert, nil
+}
+
+// Warm requests certificates for every configured host up front so the
+// first visitor does not wait on issuance and failures surface at startup.
+// The hello offers ECDSA the way browsers do; autocert would otherwise take
+// the client for an RSA-only one and issue a certificate nobody is served.
+func (a *ACME) Warm(hosts []string) error {
+	var errs []error
+	for _, host := range hosts {
+		hello := &tls.ClientHelloInfo{
+			ServerName:       host,
+			SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+			SupportedCurves:  []tls.CurveID{tls.X25519, tls.CurveP256},
+			CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		}
+		if _, err := a.GetCertificate(hello); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", host, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Leaf returns the most recently served managed certificate, or nil before
+// the first handshake.
+func (a *ACME) Leaf() *x509.Certificate {
+	return a.leaf.Load()
+}
@@ -0,0 +1,492 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestNewACMEValidatesConfig(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	badCA := filepath.Join(dir, "bad.pem")
+	if err := os.WriteFile(badCA, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		cfg     ACMEConfig
+		wantErr string
+	}{
+		{name: "no hosts", cfg: ACMEConfig{CacheDir: dir}, wantErr: "host"},
+		{name: "no cache dir", cfg: ACMEConfig{Hosts: []string{"sonare.media"}}, wantErr: "cache"},
+		{name: "missing ca file", cfg: ACMEConfig{Hosts: []string{"sonare.media"}, CacheDir: dir, CAFile: filepath.Join(dir, "nope.pem")}, wantErr: "read CA file"},
+		{name: "empty ca file", cfg: ACMEConfig{Hosts: []string{"sonare.media"}, CacheDir: dir, CAFile: badCA}, wantErr: "no certificates"},
+		{name: "ok", cfg: ACMEConfig{Hosts: []string{"sonare.media"}, CacheDir: filepath.Join(dir, "acme")}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			a, err := NewACME(tc.cfg)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if a.Leaf() != nil {
+					t.Fatalf("leaf mismatch: got=%v want=nil", a.Leaf())
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("error mismatch: got=%v want substring %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestACMEIssuesThroughChallenges(t *testing.T) {
+	t.Parallel()
+
+	const host = "shop.sonare.test"
+	for _, challenge := range []string{"http-01", "tls-alpn-01"} {
+		t.Run(challenge, func(t *testing.T) {
+			t.Parallel()
+
+			ca := newTestCA(t, challenge)
+			var served atomic.Int32
+			a, err := NewACME(ACMEConfig{
+				DirectoryURL:  ca.server.URL + "/directory",
+				Hosts:         []string{host},
+				CacheDir:      t.TempDir(),
+				CAFile:        ca.trustFile(t),
+				OnCertificate: func(string, *x509.Certificate) { served.Add(1) },
+			})
+			if err != nil {
+				t.Fatalf("new acme: %v", err)
+			}
+
+			// Port 80 answers HTTP-01, port 443 TLS-ALPN-01 and visitors.
+			plain := httptest.NewServer(a.HTTPHandler(nil))
+			t.Cleanup(plain.Close)
+			secure := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+			secure.TLS = a.TLSConfig()
+			secure.Config.ErrorLog = log.New(io.Discard, "", 0)
+			secure.StartTLS()
+			t.Cleanup(secure.Close)
+			ca.route(plain.Listener.Addr().String(), secure.Listener.Addr().String())
+
+			if err := a.Warm([]string{host}); err != nil {
+				t.Fatalf("warm: %v", err)
+			}
+			leaf := a.Leaf()
+			if leaf == nil {
+				t.Fatal("no leaf after warming")
+			}
+			if leaf.PublicKeyAlgorithm != x509.ECDSA {
+				t.Fatalf("warmed key algorithm mismatch: got=%v want=%v", leaf.PublicKeyAlgorithm, x509.ECDSA)
+			}
+			if _, err := leaf.Verify(x509.VerifyOptions{Roots: ca.roots(), DNSName: host}); err != nil {
+				t.Fatalf("leaf does not chain to the CA: %v", err)
+			}
+
+			// A browser's handshake gets the warmed certificate, not a new one.
+			conn, err := tls.Dial("tcp", secure.Listener.Addr().String(), &tls.Config{ServerName: host, RootCAs: ca.roots()})
+			if err != nil {
+				t.Fatalf("handshake: %v", err)
+			}
+			peer := conn.ConnectionState().PeerCertificates[0]
+			conn.Close()
+			if !peer.Equal(leaf) {
+				t.Fatalf("served certificate mismatch: got serial=%v want=%v", peer.SerialNumber, leaf.SerialNumber)
+			}
+			if got := ca.issuedCount(); got != 1 {
+				t.Fatalf("issued count mismatch: got=%d want=1", got)
+			}
+			if got := served.Load(); got != 1 {
+				t.Fatalf("OnCertificate calls mismatch: got=%d want=1", got)
+			}
+		})
+	}
+}
+
+// testCA is an in-process ACME server in the spirit of Pebble: it keeps
+// one account, checks request signatures and validates the one challenge
+// type it offers by connecting to the servers under test.
+type testCA struct {
+	server    *httptest.Server
+	challenge string
+	key       *ecdsa.PrivateKey
+	root      *x509.Certificate
+
+	mu       sync.Mutex
+	httpAddr string
+	tlsAddr  string
+	account  *ecdsa.PublicKey
+	orders   []*testOrder
+	issued   int
+}
+
+type testOrder struct {
+	host   string
+	token  string
+	authz  string
+	status string
+	chain  []byte
+}
+
+func newTestCA(t *testing.T, challenge string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test ACME Root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &testCA{challenge: challenge, key: key, root: root}
+	ca.server = httptest.NewTLSServer(http.HandlerFunc(ca.handle))
+	t.Cleanup(ca.server.Close)
+	return ca
+}
+
+// trustFile writes the directory's own TLS certificate for CAFile.
+func (ca *testCA) trustFile(t *testing.T) string {
+	name := filepath.Join(t.TempDir(), "directory.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.server.Certificate().Raw})
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func (ca *testCA) roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.root)
+	return pool
+}
+
+// route points validation for every host at the given addresses, as DNS
+// would in production.
+func (ca *testCA) route(httpAddr, tlsAddr string) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	ca.httpAddr, ca.tlsAddr = httpAddr, tlsAddr
+}
+
+func (ca *testCA) issuedCount() int {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	return ca.issued
+}
+
+func (ca *testCA) url(format string, args ...any) string {
+	return ca.server.URL + fmt.Sprintf(format, args...)
+}
+
+func (ca *testCA) handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", rand.Text())
+	if r.URL.Path == "/directory" {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"newNonce":   ca.url("/nonce"),
+			"newAccount": ca.url("/account"),
+			"newOrder":   ca.url("/order"),
+		})
+		return
+	}
+	if r.URL.Path == "/nonce" {
+		return
+	}
+
+	payload, err := ca.verify(r)
+	if err != nil {
+		writeProblem(w, http.StatusUnauthorized, "unauthorized", err)
+		return
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	kind, n, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if kind == "account" {
+		w.Header().Set("Location", ca.url("/account/1"))
+		writeJSON(w, http.StatusCreated, map[string]string{"status": "valid"})
+		return
+	}
+	if kind == "order" && n == "" {
+		var req struct{ Identifiers []struct{ Value string } }
+		if err := json.Unmarshal(payload, &req); err != nil || len(req.Identifiers) != 1 {
+			writeProblem(w, http.StatusBadRequest, "malformed", fmt.Errorf("want one identifier: %v", err))
+			return
+		}
+		o := &testOrder{host: req.Identifiers[0].Value, token: rand.Text(), authz: "pending", status: "pending"}
+		ca.orders = append(ca.orders, o)
+		w.Header().Set("Location", ca.url("/order/%d", len(ca.orders)-1))
+		writeJSON(w, http.StatusCreated, ca.orderJSON(len(ca.orders)-1))
+		return
+	}
+
+	i, err := strconv.Atoi(n)
+	if err != nil || i < 0 || i >= len(ca.orders) {
+		writeProblem(w, http.StatusNotFound, "malformed", fmt.Errorf("no such %s %q", kind, n))
+		return
+	}
+	o := ca.orders[i]
+	switch kind {
+	case "order":
+		writeJSON(w, http.StatusOK, ca.orderJSON(i))
+	case "authz":
+		var req struct{ Status string }
+		json.Unmarshal(payload, &req)
+		if req.Status == "deactivated" {
+			o.authz = "deactivated"
+		}
+		writeJSON(w, http.StatusOK, ca.authzJSON(i))
+	case "challenge":
+		if err := ca.validate(o); err != nil {
+			o.authz, o.status = "invalid", "invalid"
+			writeProblem(w, http.StatusBadRequest, "unauthorized", err)
+			return
+		}
+		o.authz, o.status = "valid", "ready"
+		writeJSON(w, http.StatusOK, ca.authzJSON(i)["challenges"].([]any)[0])
+	case "finalize":
+		var req struct{ CSR string }
+		json.Unmarshal(payload, &req)
+		if err := ca.issue(o, req.CSR); err != nil {
+			writeProblem(w, http.StatusBadRequest, "badCSR", err)
+			return
+		}
+		w.Header().Set("Location", ca.url("/order/%d", i))
+		writeJSON(w, http.StatusOK, ca.orderJSON(i))
+	case "cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(o.chain)
+	default:
+		writeProblem(w, http.StatusNotFound, "malformed", fmt.Errorf("unknown path %q", r.URL.Path))
+	}
+}
+
+// verify checks a JWS request and returns its payload. The account is
+// identified by its key on registration and by its URL afterwards.
+func (ca *testCA) verify(r *http.Request) ([]byte, error) {
+	var jws struct{ Protected, Payload, Signature string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return nil, err
+	}
+	protected, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		Alg string
+		URL string
+		JWK *struct{ Crv, X, Y string }
+	}
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "ES256" || header.URL != ca.url("%s", r.URL.Path) {
+		return nil, fmt.Errorf("unexpected alg %q or url %q", header.Alg, header.URL)
+	}
+
+	ca.mu.Lock()
+	key := ca.account
+	if header.JWK != nil {
+		x, _ := base64.RawURLEncoding.DecodeString(header.JWK.X)
+		y, _ := base64.RawURLEncoding.DecodeString(header.JWK.Y)
+		key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		ca.account = key
+	}
+	ca.mu.Unlock()
+	if key == nil {
+		return nil, errors.New("no account")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(jws.Signature)
+	if err != nil || len(sig) != 64 {
+		return nil, fmt.Errorf("bad signature encoding: %v", err)
+	}
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if !ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, errors.New("signature does not verify")
+	}
+	return base64.RawURLEncoding.DecodeString(jws.Payload)
+}
+
+func (ca *testCA) orderJSON(i int) map[string]any {
+	o := ca.orders[i]
+	res := map[string]any{
+		"status":         o.status,
+		"identifiers":    []map[string]string{{"type": "dns", "value": o.host}},
+		"authorizations": []string{ca.url("/authz/%d", i)},
+		"finalize":       ca.url("/finalize/%d", i),
+	}
+	if o.chain != nil {
+		res["certificate"] = ca.url("/cert/%d", i)
+	}
+	return res
+}
+
+func (ca *testCA) authzJSON(i int) map[string]any {
+	o := ca.orders[i]
+	return map[string]any{
+		"status":     o.authz,
+		"identifier": map[string]string{"type": "dns", "value": o.host},
+		"challenges": []any{map[string]string{
+			"type":   ca.challenge,
+			"url":    ca.url("/challenge/%d", i),
+			"token":  o.token,
+			"status": o.authz,
+		}},
+	}
+}
+
+// validate connects to the server under test the way a CA would and
+// checks the key authorization it presents.
+func (ca *testCA) validate(o *testOrder) error {
+	thumb, err := acme.JWKThumbprint(ca.account)
+	if err != nil {
+		return err
+	}
+	keyAuth := o.token + "." + thumb
+
+	switch ca.challenge {
+	case "http-01":
+		req, err := http.NewRequest(http.MethodGet, "http://"+ca.httpAddr+"/.well-known/acme-challenge/"+o.token, nil)
+		if err != nil {
+			return err
+		}
+		req.Host = o.host
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != http.StatusOK || string(body) != keyAuth {
+			return fmt.Errorf("http-01 response mismatch: status=%d body=%q", res.StatusCode, body)
+		}
+		return nil
+	case "tls-alpn-01":
+		conn, err := tls.Dial("tcp", ca.tlsAddr, &tls.Config{
+			ServerName:         o.host,
+			NextProtos:         []string{acme.ALPNProto},
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		state := conn.ConnectionState()
+		if state.NegotiatedProtocol != acme.ALPNProto || len(state.PeerCertificates) != 1 {
+			return fmt.Errorf("tls-alpn-01 handshake mismatch: proto=%q certs=%d", state.NegotiatedProtocol, len(state.PeerCertificates))
+		}
+		// RFC 8737: a critical id-pe-acmeIdentifier holding the digest.
+		digest := sha256.Sum256([]byte(keyAuth))
+		want, err := asn1.Marshal(digest[:])
+		if err != nil {
+			return err
+		}
+		for _, ext := range state.PeerCertificates[0].Extensions {
+			if ext.Id.Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}) && ext.Critical && bytes.Equal(ext.Value, want) {
+				return nil
+			}
+		}
+		return errors.New("tls-alpn-01 certificate lacks the key authorization")
+	}
+	return fmt.Errorf("unsupported challenge %q", ca.challenge)
+}
+
+func (ca *testCA) issue(o *testOrder, encoded string) error {
+	if o.status != "ready" {
+		return fmt.Errorf("order is %s", o.status)
+	}
+	der, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return err
+	}
+	if !slices.Equal(csr.DNSNames, []string{o.host}) {
+		return fmt.Errorf("csr names mismatch: got=%v want=[%s]", csr.DNSNames, o.host)
+	}
+	ca.issued++
+	leaf, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(int64(ca.issued) + 1),
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     csr.DNSNames,
+	}, ca.root, csr.PublicKey, ca.key)
+	if err != nil {
+		return err
+	}
+	o.chain = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.root.Raw})...)
+	o.status = "valid"
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeProblem(w http.ResponseWriter, status int, typ string, err error) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"type": "urn:ietf:params:acme:error:" + typ, "detail": err.Error()})
+}
@@ -0,0 +1,61 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sync/atomic"
+)
+
+// Reloader serves a certificate/key pair that can be swapped while the
+// server runs. Handshakes in flight keep the pair they started with.
+type Reloader struct {
+	current atomic.Pointer[tls.Certificate]
+}
+
+// NewReloader loads the initial pair.
+func NewReloader(certPath, keyPath string) (*Reloader, error) {
+	r := &Reloader{}
+	if _, err := r.Load(certPath, keyPath); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Load reads a new pair from disk and, only if it parses, makes it the one
+// served to new handshakes. It returns the new leaf certificate.
+func (r *Reloader) Load(certPath, keyPath string) (*x509.Certificate, error) {
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("load key pair: %w", err)
+	}
+	if pair.Leaf == nil {
+		if pair.Leaf, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("parse certificate %q: %w", certPath, err)
+		}
+	}
+	r.current.Store(&pair)
+	return pair.Leaf, nil
+}
+
+// Leaf returns the certificate currently being served.
+func (r *Reloader) Leaf() *x509.Certificate {
+	if pair := r.current.Load(); pair != nil {
+		return pair.Leaf
+	}
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	pair := r.current.Load()
+	if pair == nil {
+		return nil, fmt.Errorf("no certificate loaded")
+	}
+	return pair, nil
+}
+
+// TLSConfig returns a server config that always presents the current pair.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{GetCertificate: r.GetCertificate}
+}
@@ -0,0 +1,263 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultHosts are always included in generated development certificates.
+var DefaultHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+// DevOrganization marks generated development certificates so they can be
+// told apart from operator-provided ones.
+const DevOrganization = "sonare.media development"
+
+// devCAValidity is long because the CA is meant to be trusted once.
+const devCAValidity = 10 * 365 * 24 * time.Hour
+
+// DevCA is a locally persisted certificate authority used to sign
+// development certificates so browsers only need to trust it once.
+type DevCA struct {
+	Cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// LoadOrCreateDevCA reads the CA pair from disk, creating it when neither
+// file exists. created reports whether a new CA was written.
+func LoadOrCreateDevCA(certPath, keyPath string) (ca *DevCA, created bool, err error) {
+	certExists, err := exists(certPath)
+	if err != nil {
+		return nil, false, err
+	}
+	keyExists, err := exists(keyPath)
+	if err != nil {
+		return nil, false, err
+	}
+	if certExists != keyExists {
+		return nil, false, fmt.Errorf("dev CA: only one of %q and %q exists", certPath, keyPath)
+	}
+	if certExists {
+		ca, err := loadDevCA(certPath, keyPath)
+		return ca, false, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, false, fmt.Errorf("dev CA: generate key: %w", err)
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, false, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "sonare.media development CA", Organization: []string{"sonare.media"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(devCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, false, fmt.Errorf("dev CA: create certificate: %w", err)
+	}
+	if err := writePair(certPath, keyPath, der, key); err != nil {
+		return nil, false, fmt.Errorf("dev CA: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, false, fmt.Errorf("dev CA: %w", err)
+	}
+	return &DevCA{Cert: cert, key: key}, true, nil
+}
+
+func loadDevCA(certPath, keyPath string) (*DevCA, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("dev CA: %w", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("dev CA: no certificate in %q", certPath)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("dev CA: parse %q: %w", certPath, err)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("dev CA: %q is not a CA certificate", certPath)
+	}
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("dev CA: %w", err)
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("dev CA: no key in %q", keyPath)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("dev CA: parse %q: %w", keyPath, err)
+	}
+	key, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("dev CA: unsupported key type %T", parsed)
+	}
+	return &DevCA{Cert: cert, key: key}, nil
+}
+
+// GenerateSelfSigned writes an ECDSA P-256 certificate and key valid for
+// DefaultHosts plus hosts. With a nil ca the certificate signs itself;
+// otherwise it is issued by ca.
+func GenerateSelfSigned(certPath, keyPath string, hosts []string, validity time.Duration, ca *DevCA) (*x509.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "localhost", Organization: []string{DevOrganization}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	seen := make(map[string]bool)
+	for _, host := range append(append([]string{}, DefaultHosts...), hosts...) {
+		if host == "" || seen[host] {
+			continue
+		}
+		seen[host] = true
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+
+	parent, signer := tmpl, crypto.Signer(key)
+	if ca != nil {
+		parent, signer = ca.Cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		return nil, fmt.Errorf("create certificate: %w", err)
+	}
+	if err := writePair(certPath, keyPath, der, key); err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// writePair writes the certificate and PKCS#8 key, each via a temporary
+// file and rename so a crash never leaves a half-written pair behind.
+func writePair(certPath, keyPath string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("marshal key: %w", err)
+	}
+	if err := writeFileAtomic(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	return writeFileAtomic(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create %q: %w", dir, err)
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("write %q: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write %q: %w", path, err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write %q: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write %q: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write %q: %w", path, err)
+	}
+	return nil
+}
+
+func newSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("generate serial: %w", err)
+	}
+	return serial, nil
+}
+
+func exists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, fs.ErrNotExist):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// ReadChain returns every certificate in the PEM file at path, leaf first.
+// Private key blocks, as found in the ACME cache, are skipped.
+func ReadChain(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse %q: %w", path, err)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificates in %q", path)
+	}
+	return chain, nil
+}
@@ -0,0 +1,67 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestGenerateSelfSignedWithDevCA(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	caCert, caKey := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	ca, created, err := LoadOrCreateDevCA(caCert, caKey)
+	if err != nil || !created {
+		t.Fatalf("create dev CA: created=%v err=%v", created, err)
+	}
+	if _, created, err = LoadOrCreateDevCA(caCert, caKey); err != nil || created {
+		t.Fatalf("reload dev CA: created=%v err=%v", created, err)
+	}
+
+	certPath, keyPath := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	leaf, err := GenerateSelfSigned(certPath, keyPath, []string{"dev.sonare.media", "localhost", "10.0.0.5"}, 24*time.Hour, ca)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if _, err := tls.LoadX509KeyPair(certPath, keyPath); err != nil {
+		t.Fatalf("load generated pair: %v", err)
+	}
+
+	if got, want := leaf.DNSNames, []string{"localhost", "dev.sonare.media"}; !slices.Equal(got, want) {
+		t.Fatalf("dns names mismatch: got=%v want=%v", got, want)
+	}
+	if got := len(leaf.IPAddresses); got != 3 {
+		t.Fatalf("ip address count mismatch: got=%d want=3", got)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: "dev.sonare.media"}); err != nil {
+		t.Fatalf("leaf does not chain to dev CA: %v", err)
+	}
+
+	chain, err := ReadChain(certPath)
+	if err != nil || len(chain) != 1 || !chain[0].Equal(leaf) {
+		t.Fatalf("read chain mismatch: len=%d err=%v", len(chain), err)
+	}
+}
+
+func TestGenerateSelfSignedWithoutCA(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	leaf, err := GenerateSelfSigned(filepath.Join(dir, "c.crt"), filepath.Join(dir, "c.key"), nil, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if err := leaf.CheckSignature(leaf.SignatureAlgorithm, leaf.RawTBSCertificate, leaf.Signature); err != nil {
+		t.Fatalf("expected self-signed certificate: %v", err)
+	}
+	if !slices.Contains(leaf.Subject.Organization, DevOrganization) {
+		t.Fatalf("organization mismatch: got=%v want=%q", leaf.Subject.Organization, DevOrganization)
+	}
+}
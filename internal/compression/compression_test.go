@@ -0,0 +1,161 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestNegotiate(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: ""},
+		{header: "identity", want: ""},
+		{header: "gzip, deflate, br, zstd", want: "br"},
+		{header: "gzip, zstd", want: "zstd"},
+		{header: "gzip;q=1.0, br;q=0.5", want: "gzip"},
+		{header: "br;q=0, gzip", want: "gzip"},
+		{header: "*", want: "br"},
+		{header: "*;q=0.2, zstd;q=0.9", want: "zstd"},
+	}
+	for _, tc := range cases {
+		got := ""
+		if enc := negotiate(tc.header); enc != nil {
+			got = enc.name
+		}
+		if got != tc.want {
+			t.Errorf("negotiate(%q) mismatch: got=%q want=%q", tc.header, got, tc.want)
+		}
+	}
+}
+
+func compressed(t *testing.T, name string, data []byte) []byte {
+	t.Helper()
+	for _, enc := range encodings {
+		if enc.name == name {
+			var buf bytes.Buffer
+			zw := enc.newBestWriter(&buf)
+			zw.Write(data)
+			zw.Close()
+			return buf.Bytes()
+		}
+	}
+	t.Fatalf("unknown encoding %q", name)
+	return nil
+}
+
+func decoded(t *testing.T, name string, body []byte) string {
+	t.Helper()
+	for _, enc := range encodings {
+		if enc.name == name {
+			zr, err := enc.newReader(bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatalf("decode %s: %v", name, err)
+			}
+			return string(out)
+		}
+	}
+	return string(body)
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	js := strings.Repeat("console.log('sonare');\n", 200)
+	css := strings.Repeat("body { color: #000; }\n", 200)
+	fsys := fstest.MapFS{
+		"assets/app.js":        {Data: []byte(js)},
+		"assets/app.js.br":     {Data: compressed(t, "br", []byte(js))},
+		"assets/styles.css":    {Data: []byte(css)},
+		"assets/styles.css.gz": {Data: compressed(t, "gzip", []byte("stale"))},
+		"small.txt":            {Data: []byte("tiny")},
+		"music/A.m4a":          {Data: bytes.Repeat([]byte{0}, 4096)},
+	}
+	h := NewHandler(fsys, http.FileServer(http.FS(fsys)), DefaultMinSize)
+
+	cases := []struct {
+		name     string
+		path     string
+		accept   string
+		encoding string
+		vary     bool
+		body     string
+	}{
+		{name: "precompressed sibling", path: "/assets/app.js", accept: "br, gzip", encoding: "br", vary: true, body: js},
+		{name: "on the fly when no sibling", path: "/assets/app.js", accept: "gzip", encoding: "gzip", vary: true, body: js},
+		{name: "stale sibling ignored", path: "/assets/styles.css", accept: "gzip", encoding: "gzip", vary: true, body: css},
+		{name: "identity", path: "/assets/app.js", accept: "", encoding: "", vary: true, body: js},
+		{name: "below threshold", path: "/small.txt", accept: "zstd", encoding: "", vary: true, body: "tiny"},
+		{name: "media skipped", path: "/music/A.m4a", accept: "br", encoding: "", vary: false, body: string(fsys["music/A.m4a"].Data)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept-Encoding", tc.accept)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status mismatch: got=%d want=200", rec.Code)
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != tc.encoding {
+				t.Fatalf("encoding mismatch: got=%q want=%q", got, tc.encoding)
+			}
+			if got := rec.Header().Get("Vary") == "Accept-Encoding"; got != tc.vary {
+				t.Fatalf("vary mismatch: got=%q want=%v", rec.Header().Get("Vary"), tc.vary)
+			}
+			if got := decoded(t, tc.encoding, rec.Body.Bytes()); got != tc.body {
+				t.Fatalf("body mismatch: got %d bytes want %d", len(got), len(tc.body))
+			}
+			if tc.encoding != "" && rec.Header().Get("Content-Type") == "" {
+				t.Fatal("missing Content-Type on encoded response")
+			}
+		})
+	}
+}
+
+func TestPrecompress(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	js := strings.Repeat("console.log('sonare');\n", 200)
+	for name, data := range map[string]string{"app.js": js, "tiny.css": "a{}", "A.m4a": js} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	written, err := Precompress(dir, DefaultMinSize)
+	if err != nil {
+		t.Fatalf("precompress: %v", err)
+	}
+	if len(written) != len(encodings) {
+		t.Fatalf("written mismatch: got=%v want one sibling per encoding of app.js", written)
+	}
+	for _, enc := range encodings {
+		data, err := os.ReadFile(filepath.Join(dir, "app.js"+enc.ext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := decoded(t, enc.name, data); got != js {
+			t.Fatalf("%s sibling does not decode to the original", enc.name)
+		}
+	}
+}
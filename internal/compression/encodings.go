@@ -0,0 +1,152 @@
+// Package compression negotiates Accept-Encoding for static assets. It
+// serves precompressed .br/.zst/.gz siblings when they exist and match the
+// original, and otherwise compresses compressible responses on the fly.
+package compression
+
+import (
+	"io"
+	"mime"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// resetWriter is the shape shared by the pooled encoders.
+type resetWriter interface {
+	io.WriteCloser
+	Reset(io.Writer)
+}
+
+// encoding is one supported Content-Encoding.
+type encoding struct {
+	name string
+	// ext is the suffix of precompressed siblings.
+	ext string
+	// newWriter compresses at a level suited to each request.
+	newWriter func(io.Writer) resetWriter
+	// newBestWriter compresses as hard as possible, for build-time output.
+	newBestWriter func(io.Writer) io.WriteCloser
+	newReader     func(io.Reader) (io.ReadCloser, error)
+	pool          sync.Pool
+}
+
+// encodings are listed in server preference order, used to break ties
+// between equal client q-values.
+var encodings = []*encoding{
+	{
+		name:          "br",
+		ext:           ".br",
+		newWriter:     func(w io.Writer) resetWriter { return brotli.NewWriterLevel(w, 5) },
+		newBestWriter: func(w io.Writer) io.WriteCloser { return brotli.NewWriterLevel(w, brotli.BestCompression) },
+		newReader:     func(r io.Reader) (io.ReadCloser, error) { return io.NopCloser(brotli.NewReader(r)), nil },
+	},
+	{
+		name: "zstd",
+		ext:  ".zst",
+		newWriter: func(w io.Writer) resetWriter {
+			zw, _ := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+			return zw
+		},
+		newBestWriter: func(w io.Writer) io.WriteCloser {
+			zw, _ := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+			return zw
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return zr.IOReadCloser(), nil
+		},
+	},
+	{
+		name: "gzip",
+		ext:  ".gz",
+		newWriter: func(w io.Writer) resetWriter {
+			return gzip.NewWriter(w)
+		},
+		newBestWriter: func(w io.Writer) io.WriteCloser {
+			zw, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+			return zw
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	},
+}
+
+func (e *encoding) get(w io.Writer) resetWriter {
+	if zw, ok := e.pool.Get().(resetWriter); ok {
+		zw.Reset(w)
+		return zw
+	}
+	return e.newWriter(w)
+}
+
+func (e *encoding) put(zw resetWriter) {
+	zw.Reset(io.Discard)
+	e.pool.Put(zw)
+}
+
+// negotiate picks the encoding for an Accept-Encoding header, or nil for
+// identity. Unlisted codings are refused unless "*" accepts them.
+func negotiate(header string) *encoding {
+	if header == "" {
+		return nil
+	}
+	q := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				weight = f
+			}
+		}
+		q[name] = weight
+	}
+
+	var best *encoding
+	bestQ := 0.0
+	for _, e := range encodings {
+		weight, ok := q[e.name]
+		if !ok {
+			weight, ok = q["*"]
+		}
+		if ok && weight > bestQ {
+			best, bestQ = e, weight
+		}
+	}
+	return best
+}
+
+// compressibleType reports whether a Content-Type benefits from
+// compression. Audio, video, most images and archives are already
+// compressed and are left alone.
+func compressibleType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case mediaType == "application/javascript", mediaType == "application/json",
+		mediaType == "application/xml", mediaType == "application/manifest+json",
+		mediaType == "application/wasm", mediaType == "image/svg+xml",
+		strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	default:
+		return false
+	}
+}
+
+// compressibleName applies compressibleType to a file name's extension.
+func compressibleName(name string) bool {
+	return compressibleType(mime.TypeByExtension(path.Ext(name)))
+}
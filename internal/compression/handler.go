@@ -0,0 +1,257 @@
+package compression
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultMinSize is the smallest response worth compressing on the fly;
+// below it the encoding overhead outweighs the savings.
+const DefaultMinSize = 1024
+
+// Handler negotiates the response encoding for files served from fsys by
+// next (typically an http.FileServer over the same fsys).
+type Handler struct {
+	fsys    fs.FS
+	next    http.Handler
+	minSize int
+	// verified caches whether a sibling decodes to its original, keyed by
+	// sibling name, original size and modification time.
+	verified sync.Map
+}
+
+// NewHandler wraps next. Responses smaller than minSize are sent as is.
+func NewHandler(fsys fs.FS, next http.Handler, minSize int) *Handler {
+	return &Handler{fsys: fsys, next: next, minSize: minSize}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := fileName(r.URL.Path)
+	if (r.Method != http.MethodGet && r.Method != http.MethodHead) || !compressibleName(name) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	enc := negotiate(r.Header.Get("Accept-Encoding"))
+	if enc == nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	// Each encoding is its own representation and needs its own validator.
+	if etag := w.Header().Get("ETag"); strings.HasSuffix(etag, `"`) {
+		w.Header().Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+enc.name+`"`)
+	}
+	if h.servePrecompressed(w, r, name, enc) {
+		return
+	}
+	if r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	cw := &compressWriter{ResponseWriter: w, enc: enc, minSize: h.minSize}
+	defer cw.finish()
+	h.next.ServeHTTP(cw, r)
+}
+
+// fileName maps a request path onto the fs.FS name http.FileServer would
+// serve, including the index.html of a directory.
+func fileName(urlPath string) string {
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if strings.HasSuffix(urlPath, "/") {
+		name = path.Join(name, "index.html")
+	}
+	if name == "" {
+		name = "index.html"
+	}
+	return name
+}
+
+// servePrecompressed answers from name+enc.ext when that sibling exists
+// and decodes to the current contents of name.
+func (h *Handler) servePrecompressed(w http.ResponseWriter, r *http.Request, name string, enc *encoding) bool {
+	orig, err := fs.Stat(h.fsys, name)
+	if err != nil || orig.IsDir() {
+		return false
+	}
+	sibling := name + enc.ext
+	f, err := h.fsys.Open(sibling)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	rs, ok := f.(io.ReadSeeker)
+	if !ok || !h.siblingMatches(name, sibling, enc, orig) {
+		return false
+	}
+
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Encoding", enc.name)
+	http.ServeContent(w, r, name, orig.ModTime(), rs)
+	return true
+}
+
+// siblingMatches decodes a precompressed sibling once and compares it with
+// the original, so a stale .br left behind by an edit is never served.
+func (h *Handler) siblingMatches(name, sibling string, enc *encoding, orig fs.FileInfo) bool {
+	key := fmt.Sprintf("%s|%d|%d", sibling, orig.Size(), orig.ModTime().UnixNano())
+	if ok, seen := h.verified.Load(key); seen {
+		return ok.(bool)
+	}
+	ok := decodesTo(h.fsys, name, sibling, enc)
+	h.verified.Store(key, ok)
+	return ok
+}
+
+func decodesTo(fsys fs.FS, name, sibling string, enc *encoding) bool {
+	want, err := fileHash(fsys, name, nil)
+	if err != nil {
+		return false
+	}
+	got, err := fileHash(fsys, sibling, enc)
+	return err == nil && bytes.Equal(got, want)
+}
+
+func fileHash(fsys fs.FS, name string, enc *encoding) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if enc != nil {
+		zr, err := enc.newReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	sum := sha256.New()
+	if _, err := io.Copy(sum, r); err != nil {
+		return nil, err
+	}
+	return sum.Sum(nil), nil
+}
+
+// compressWriter compresses a 200 response with a compressible type once
+// it is known to reach minSize, buffering until then.
+type compressWriter struct {
+	http.ResponseWriter
+	enc     *encoding
+	minSize int
+
+	status  int
+	buf     []byte
+	decided bool
+	zw      resetWriter
+}
+
+func (c *compressWriter) WriteHeader(code int) {
+	if c.status != 0 {
+		return
+	}
+	c.status = code
+	hdr := c.Header()
+	switch {
+	case code != http.StatusOK, hdr.Get("Content-Encoding") != "", !compressibleType(hdr.Get("Content-Type")):
+		c.passthrough()
+	case hdr.Get("Content-Length") != "":
+		if n, err := strconv.Atoi(hdr.Get("Content-Length")); err != nil || n < c.minSize {
+			c.passthrough()
+		} else {
+			c.start()
+		}
+	}
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.decided {
+		if c.zw != nil {
+			return c.zw.Write(p)
+		}
+		return c.ResponseWriter.Write(p)
+	}
+	c.buf = append(c.buf, p...)
+	if len(c.buf) >= c.minSize {
+		c.start()
+	}
+	return len(p), nil
+}
+
+// Flush commits to compression so streamed bodies are not held back.
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		if c.status == 0 {
+			c.WriteHeader(http.StatusOK)
+		}
+		if !c.decided {
+			c.start()
+		}
+	}
+	if f, ok := c.zw.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(c.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+func (c *compressWriter) start() {
+	c.decided = true
+	hdr := c.Header()
+	hdr.Del("Content-Length")
+	hdr.Del("Accept-Ranges")
+	hdr.Set("Content-Encoding", c.enc.name)
+	c.ResponseWriter.WriteHeader(c.status)
+	c.zw = c.enc.get(c.ResponseWriter)
+	if len(c.buf) > 0 {
+		c.zw.Write(c.buf)
+		c.buf = nil
+	}
+}
+
+func (c *compressWriter) passthrough() {
+	c.decided = true
+	c.ResponseWriter.WriteHeader(c.status)
+	if len(c.buf) > 0 {
+		c.ResponseWriter.Write(c.buf)
+		c.buf = nil
+	}
+}
+
+// finish sends whatever is still buffered and closes the encoder.
+func (c *compressWriter) finish() {
+	if !c.decided {
+		if c.status == 0 {
+			// The handler wrote nothing at all; leave the default response.
+			return
+		}
+		c.passthrough()
+	}
+	if c.zw != nil {
+		c.zw.Close()
+		c.enc.put(c.zw)
+		c.zw = nil
+	}
+}
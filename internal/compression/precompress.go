@@ -0,0 +1,67 @@
+package compression
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Precompress writes a .br, .zst and .gz sibling, at maximum compression,
+// for every compressible file under dir of at least minSize bytes. A
+// sibling that would not be smaller than its original is removed instead.
+// It returns the siblings written.
+func Precompress(dir string, minSize int) ([]string, error) {
+	var written []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || isSibling(p) || !compressibleName(p) {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		for _, enc := range encodings {
+			sibling := p + enc.ext
+			if len(data) < minSize {
+				removeIfExists(sibling)
+				continue
+			}
+			var buf bytes.Buffer
+			zw := enc.newBestWriter(&buf)
+			if _, err := zw.Write(data); err != nil {
+				return fmt.Errorf("%s: %w", sibling, err)
+			}
+			if err := zw.Close(); err != nil {
+				return fmt.Errorf("%s: %w", sibling, err)
+			}
+			if buf.Len() >= len(data) {
+				removeIfExists(sibling)
+				continue
+			}
+			if err := os.WriteFile(sibling, buf.Bytes(), 0o644); err != nil {
+				return err
+			}
+			written = append(written, sibling)
+		}
+		return nil
+	})
+	return written, err
+}
+
+func isSibling(name string) bool {
+	for _, enc := range encodings {
+		if strings.HasSuffix(name, enc.ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func removeIfExists(name string) {
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "remove %s: %v\n", name, err)
+	}
+}
@@ -0,0 +1,714 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"sonare.media/internal/signedurl"
+)
+
+// EnvPrefix namespaces the environment overrides: log.level is read from
+// SONARE_LOG_LEVEL, tls.cert_file from SONARE_TLS_CERT_FILE, and so on.
+const EnvPrefix = "SONARE_"
+
+// EnvConfigFile names the config file when -config is not given.
+const EnvConfigFile = "SONARE_CONFIG"
+
+// Config is the effective server configuration. It is assembled from
+// Default, then the YAML file, then SONARE_* variables, then flags.
+type Config struct {
+	Mode      string `yaml:"mode"`
+	Port      string `yaml:"port"`
+	DB        string `yaml:"db"`
+	AdminAddr string `yaml:"admin_addr"`
+	// AdminToken authorises the catalog endpoints of the admin listener
+	// (sent as "Authorization: Bearer <token>"); they are off without it.
+	AdminToken string `yaml:"admin_token" secret:"true"`
+	// WebDir, if set, is overlaid on the site embedded in the binary.
+	WebDir string `yaml:"web_dir"`
+	// ShutdownTimeout bounds how long in-flight requests, such as long
+	// preview downloads, may run after SIGTERM or a SIGUSR2 upgrade.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// UpgradeTimeout bounds how long a SIGUSR2 upgrade waits for the new
+	// process to report ready before giving up and keeping this one.
+	UpgradeTimeout time.Duration `yaml:"upgrade_timeout"`
+	RunAs          RunAs         `yaml:"run_as"`
+	TLS            TLS           `yaml:"tls"`
+	Log            Log           `yaml:"log"`
+	Tracing        Tracing       `yaml:"tracing"`
+	Security       Security      `yaml:"security"`
+	Cache          Cache         `yaml:"cache"`
+	Catalog        Catalog       `yaml:"catalog"`
+}
+
+// RunAs names the unprivileged account the server switches to after
+// binding its listeners when started as root. Everything opened afterwards
+// (database, logs, certificates, ACME cache) must be accessible to it.
+type RunAs struct {
+	User  string `yaml:"user"`
+	Group string `yaml:"group"`
+}
+
+type TLS struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	ACME     ACME   `yaml:"acme"`
+	// SelfSigned is used by serve-test when cert_file and key_file are both
+	// missing.
+	SelfSigned SelfSigned `yaml:"self_signed"`
+}
+
+// SelfSigned controls development certificates generated for serve-test.
+type SelfSigned struct {
+	// Hosts are added to localhost, 127.0.0.1 and ::1.
+	Hosts    []string      `yaml:"hosts"`
+	Validity time.Duration `yaml:"validity"`
+	// DevCA signs generated certificates with a persisted local CA so it
+	// only has to be trusted once.
+	DevCA      bool   `yaml:"dev_ca"`
+	CACertFile string `yaml:"ca_cert_file"`
+	CAKeyFile  string `yaml:"ca_key_file"`
+}
+
+// ACME replaces cert_file/key_file with certificates issued by an ACME CA.
+type ACME struct {
+	Enabled      bool     `yaml:"enabled"`
+	DirectoryURL string   `yaml:"directory_url"`
+	Email        string   `yaml:"email"`
+	Hosts        []string `yaml:"hosts"`
+	CacheDir     string   `yaml:"cache_dir"`
+	// CAFile is trusted when talking to the directory, e.g. a Pebble root.
+	CAFile string `yaml:"ca_file"`
+}
+
+type Log struct {
+	Level           string        `yaml:"level"`
+	Format          string        `yaml:"format"`
+	File            string        `yaml:"file"`
+	MaxSizeMB       int           `yaml:"max_size_mb"`
+	MaxBackups      int           `yaml:"max_backups"`
+	MaxAgeDays      int           `yaml:"max_age_days"`
+	RotateEvery     time.Duration `yaml:"rotate_every"`
+	Compress        bool          `yaml:"compress"`
+	AccessLog       string        `yaml:"access_log"`
+	AccessLogFormat string        `yaml:"access_log_format"`
+}
+
+type Tracing struct {
+	OTLPEndpoint string            `yaml:"otlp_endpoint"`
+	Insecure     bool              `yaml:"insecure"`
+	SampleRatio  float64           `yaml:"sample_ratio"`
+	Headers      map[string]string `yaml:"headers" secret:"true"`
+}
+
+type Security struct {
+	// CSP lists Content-Security-Policy directives; they are joined with
+	// "; " and so are separated by ";" in SONARE_SECURITY_CSP. "{nonce}"
+	// is replaced with a fresh nonce on every request.
+	CSP []string `yaml:"csp" sep:";"`
+	// CSPReportOnly, when set, is sent as Content-Security-Policy-Report-Only
+	// so a stricter policy can be trialled before it is enforced.
+	CSPReportOnly []string `yaml:"csp_report_only" sep:";"`
+	// CSPReportURI receives violation reports for both policies; empty
+	// disables reporting.
+	CSPReportURI string     `yaml:"csp_report_uri"`
+	HSTS         string     `yaml:"hsts"`
+	SignedURLs   SignedURLs `yaml:"signed_urls"`
+}
+
+// SignedURLs makes /api/preview-sources hand out expiring, HMAC-signed
+// /music URLs and the music route refuse any other request.
+type SignedURLs struct {
+	Enabled bool `yaml:"enabled"`
+	// Keys are "id:secret" pairs with secrets of at least 32 bytes. The
+	// first signs and all verify: rotate by prepending a new key, then drop
+	// the old one once ttl plus grace has passed.
+	Keys []string `yaml:"keys" secret:"true"`
+	// TTL is how long a URL stays valid after it is handed out.
+	TTL time.Duration `yaml:"ttl"`
+	// Grace keeps accepting URLs this long past their expiry, so playback
+	// that started in time is not cut off mid-track.
+	Grace time.Duration `yaml:"grace"`
+	// BindSession ties URLs to the visitor's session cookie, so a copied
+	// URL does not play in another browser.
+	BindSession bool `yaml:"bind_session"`
+}
+
+// Cache sets Cache-Control max-age per class of static file; 0 sends
+// "no-cache".
+type Cache struct {
+	Music  time.Duration `yaml:"music"`
+	Assets time.Duration `yaml:"assets"`
+	Static time.Duration `yaml:"static"`
+}
+
+// Catalog configures what the server derives from the music previews.
+type Catalog struct {
+	// WaveformDir persists computed waveforms across restarts; empty keeps
+	// them in memory only.
+	WaveformDir string `yaml:"waveform_dir"`
+	// RescanDelay is how long the music directory under web_dir must be
+	// quiet before it is rescanned, and how long a changed file must go
+	// unmodified before it is listed.
+	RescanDelay time.Duration `yaml:"rescan_delay"`
+	// Strategy picks among a phase's tracks when the preview request does
+	// not name one: first, random, weighted or round-robin.
+	Strategy string `yaml:"strategy"`
+	// TrackDuration and BeaconDuration are the running times `catalog
+	// check` accepts for phase tracks and beacons.
+	TrackDuration  DurationRange `yaml:"track_duration"`
+	BeaconDuration DurationRange `yaml:"beacon_duration"`
+	HLS            HLS           `yaml:"hls"`
+	// MaxUploadSize bounds, in bytes, the audio files the admin API
+	// accepts.
+	MaxUploadSize int `yaml:"max_upload_size"`
+	// RetiredDir receives the files of retired tracks. Keep it outside
+	// web_dir, or they would still be served.
+	RetiredDir string `yaml:"retired_dir"`
+}
+
+// HLS controls streaming previews as HLS alongside the progressive files.
+type HLS struct {
+	// Enabled offers each track's HLS playlist to browsers that play HLS
+	// natively; the rest keep downloading the file.
+	Enabled bool `yaml:"enabled"`
+	// SegmentDuration is the shortest media segment but the last; players
+	// fetch one at a time, so shorter segments waste less when a preview
+	// is stopped early.
+	SegmentDuration time.Duration `yaml:"segment_duration"`
+}
+
+// DurationRange is an inclusive range; a zero Max leaves it open-ended.
+type DurationRange struct {
+	Min time.Duration `yaml:"min"`
+	Max time.Duration `yaml:"max"`
+}
+
+// Contains reports whether d lies within the range.
+func (r DurationRange) Contains(d time.Duration) bool {
+	return d >= r.Min && (r.Max == 0 || d <= r.Max)
+}
+
+// Default returns the configuration the server used before it had a
+// config file.
+func Default() Config {
+	return Config{
+		Mode:            "serve-test",
+		Port:            "8080",
+		DB:              "sonare.db",
+		AdminAddr:       "127.0.0.1:9090",
+		ShutdownTimeout: 30 * time.Second,
+		UpgradeTimeout:  time.Minute,
+		TLS: TLS{
+			CertFile: "certs/server.crt",
+			KeyFile:  "certs/server.key",
+			ACME: ACME{
+				DirectoryURL: "https://acme-v02.api.letsencrypt.org/directory",
+				CacheDir:     "certs/acme",
+			},
+			SelfSigned: SelfSigned{
+				Validity:   90 * 24 * time.Hour,
+				CACertFile: "certs/dev-ca.crt",
+				CAKeyFile:  "certs/dev-ca.key",
+			},
+		},
+		Log: Log{
+			Level:           "info",
+			Format:          "text",
+			File:            "server.log",
+			MaxSizeMB:       100,
+			MaxBackups:      10,
+			MaxAgeDays:      30,
+			RotateEvery:     24 * time.Hour,
+			Compress:        true,
+			AccessLog:       "access.log",
+			AccessLogFormat: "combined",
+		},
+		Tracing: Tracing{
+			Insecure:    true,
+			SampleRatio: 1.0,
+		},
+		Security: Security{
+			CSP: []string{
+				"default-src 'self'",
+				"script-src 'self' 'nonce-{nonce}'",
+				"style-src 'self' 'unsafe-inline'",
+				"img-src 'self' data: https:",
+				"font-src 'self' data:",
+				"media-src 'self'",
+				"connect-src 'self'",
+				"object-src 'none'",
+				"base-uri 'self'",
+				"frame-ancestors 'none'",
+				"form-action 'self'",
+			},
+			CSPReportURI: "/api/csp-report",
+			HSTS:         "max-age=31536000; includeSubDomains; preload",
+			SignedURLs: SignedURLs{
+				TTL:   time.Hour,
+				Grace: 5 * time.Minute,
+			},
+		},
+		Cache: Cache{
+			Music:  7 * 24 * time.Hour,
+			Assets: 24 * time.Hour,
+			Static: 24 * time.Hour,
+		},
+		Catalog: Catalog{
+			WaveformDir:    "cache/waveforms",
+			RescanDelay:    time.Second,
+			Strategy:       "first",
+			TrackDuration:  DurationRange{Min: 30 * time.Second, Max: 10 * time.Minute},
+			BeaconDuration: DurationRange{Min: 3 * time.Second, Max: time.Minute},
+			HLS: HLS{
+				Enabled:         true,
+				SegmentDuration: 6 * time.Second,
+			},
+			MaxUploadSize: 64 << 20,
+			RetiredDir:    "retired",
+		},
+	}
+}
+
+// Load reads path (if non-empty) over the defaults and then applies
+// SONARE_* environment overrides from environ (os.Environ() format).
+func Load(path string, environ []string) (Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("read config: %w", err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return Config{}, fmt.Errorf("parse config %s: %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(environ); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) applyEnv(environ []string) error {
+	var errs []error
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, EnvPrefix) || name == EnvConfigFile {
+			continue
+		}
+		key := envKey(name)
+		if !c.HasKey(key) {
+			// Unknown SONARE_* variables are most likely typos.
+			errs = append(errs, fmt.Errorf("%s: no such config key %q", name, key))
+			continue
+		}
+		if err := c.Set(key, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid setting at once.
+func (c Config) Validate() error {
+	var errs []error
+	fail := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	switch c.Mode {
+	case "serve-test", "serve-http", "serve-cfd", "serve-prod", "view":
+	default:
+		fail("mode", "invalid mode %q (want serve-test, serve-http, serve-cfd, serve-prod or view)", c.Mode)
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		fail("port", "%q is not a port number between 1 and 65535", c.Port)
+	}
+	if c.DB == "" {
+		fail("db", "must not be empty")
+	}
+	if c.AdminAddr != "" {
+		if _, _, err := net.SplitHostPort(c.AdminAddr); err != nil {
+			fail("admin_addr", "%v", err)
+		}
+	}
+	if c.AdminToken != "" && len(c.AdminToken) < 16 {
+		fail("admin_token", "must be at least 16 characters")
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		fail("shutdown_timeout", "must be positive")
+	}
+	if c.UpgradeTimeout <= 0 {
+		fail("upgrade_timeout", "must be positive")
+	}
+
+	if c.RunAs.Group != "" && c.RunAs.User == "" {
+		fail("run_as.group", "requires run_as.user")
+	}
+
+	if c.WebDir != "" {
+		if info, err := os.Stat(c.WebDir); err != nil {
+			fail("web_dir", "%v", err)
+		} else if !info.IsDir() {
+			fail("web_dir", "%q is not a directory", c.WebDir)
+		}
+	}
+
+	if c.TLS.CertFile == "" {
+		fail("tls.cert_file", "must not be empty")
+	}
+	if c.TLS.KeyFile == "" {
+		fail("tls.key_file", "must not be empty")
+	}
+	if c.TLS.SelfSigned.Validity <= 0 {
+		fail("tls.self_signed.validity", "must be positive")
+	}
+	if c.TLS.SelfSigned.DevCA && (c.TLS.SelfSigned.CACertFile == "" || c.TLS.SelfSigned.CAKeyFile == "") {
+		fail("tls.self_signed", "dev_ca requires ca_cert_file and ca_key_file")
+	}
+	if c.TLS.ACME.Enabled {
+		if u, err := url.Parse(c.TLS.ACME.DirectoryURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			fail("tls.acme.directory_url", "%q is not an http(s) URL", c.TLS.ACME.DirectoryURL)
+		}
+		if len(c.TLS.ACME.Hosts) == 0 {
+			fail("tls.acme.hosts", "must list at least one host name")
+		}
+		for i, host := range c.TLS.ACME.Hosts {
+			if host == "" || strings.ContainsAny(host, "/: ") {
+				fail(fmt.Sprintf("tls.acme.hosts[%d]", i), "%q is not a host name", host)
+			}
+		}
+		if c.TLS.ACME.CacheDir == "" {
+			fail("tls.acme.cache_dir", "must not be empty")
+		}
+		if c.TLS.ACME.CAFile != "" {
+			if _, err := os.Stat(c.TLS.ACME.CAFile); err != nil {
+				fail("tls.acme.ca_file", "%v", err)
+			}
+		}
+	}
+
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		fail("log.level", "invalid level %q (want debug, info, warn or error)", c.Log.Level)
+	}
+	switch c.Log.Format {
+	case "text", "json":
+	default:
+		fail("log.format", "invalid format %q (want text or json)", c.Log.Format)
+	}
+	switch c.Log.AccessLogFormat {
+	case "combined", "json", "off":
+	default:
+		fail("log.access_log_format", "invalid format %q (want combined, json or off)", c.Log.AccessLogFormat)
+	}
+	if c.Log.MaxSizeMB < 0 {
+		fail("log.max_size_mb", "must not be negative")
+	}
+	if c.Log.MaxBackups < 0 {
+		fail("log.max_backups", "must not be negative")
+	}
+	if c.Log.MaxAgeDays < 0 {
+		fail("log.max_age_days", "must not be negative")
+	}
+	if c.Log.RotateEvery < 0 {
+		fail("log.rotate_every", "must not be negative")
+	}
+
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		fail("tracing.sample_ratio", "%v is outside [0, 1]", c.Tracing.SampleRatio)
+	}
+	if c.Tracing.OTLPEndpoint != "" {
+		if _, _, err := net.SplitHostPort(c.Tracing.OTLPEndpoint); err != nil {
+			fail("tracing.otlp_endpoint", "%v", err)
+		}
+	}
+
+	if len(c.Security.CSP) == 0 {
+		fail("security.csp", "must list at least one directive")
+	}
+	for i, d := range c.Security.CSP {
+		if strings.TrimSpace(d) == "" || strings.ContainsAny(d, ";\r\n") {
+			fail(fmt.Sprintf("security.csp[%d]", i), "invalid directive %q", d)
+		}
+	}
+	for i, d := range c.Security.CSPReportOnly {
+		if strings.TrimSpace(d) == "" || strings.ContainsAny(d, ";\r\n") {
+			fail(fmt.Sprintf("security.csp_report_only[%d]", i), "invalid directive %q", d)
+		}
+	}
+	if uri := c.Security.CSPReportURI; uri != "" {
+		if u, err := url.Parse(uri); err != nil || strings.ContainsAny(uri, " ;,\"\r\n") ||
+			!(strings.HasPrefix(uri, "/") || u.Scheme == "https" || u.Scheme == "http") {
+			fail("security.csp_report_uri", "%q is not a path or http(s) URL", uri)
+		}
+	}
+
+	if su := c.Security.SignedURLs; su.Enabled {
+		if len(su.Keys) == 0 {
+			fail("security.signed_urls.keys", "at least one key is required when signed URLs are enabled")
+		}
+		if _, err := signedurl.ParseKeys(su.Keys); err != nil {
+			fail("security.signed_urls.keys", "%v", err)
+		}
+		if su.TTL <= 0 {
+			fail("security.signed_urls.ttl", "must be positive")
+		}
+	}
+	if c.Security.SignedURLs.Grace < 0 {
+		fail("security.signed_urls.grace", "must not be negative")
+	}
+
+	if c.Cache.Music < 0 {
+		fail("cache.music", "must not be negative")
+	}
+	if c.Cache.Assets < 0 {
+		fail("cache.assets", "must not be negative")
+	}
+	if c.Cache.Static < 0 {
+		fail("cache.static", "must not be negative")
+	}
+	if c.Catalog.RescanDelay <= 0 {
+		fail("catalog.rescan_delay", "must be positive")
+	}
+	switch c.Catalog.Strategy {
+	case "first", "random", "weighted", "round-robin":
+	default:
+		fail("catalog.strategy", "invalid strategy %q (want first, random, weighted or round-robin)", c.Catalog.Strategy)
+	}
+	if c.Catalog.MaxUploadSize <= 0 {
+		fail("catalog.max_upload_size", "must be positive")
+	}
+	if c.Catalog.HLS.Enabled && (c.Catalog.HLS.SegmentDuration < time.Second || c.Catalog.HLS.SegmentDuration > 30*time.Second) {
+		fail("catalog.hls.segment_duration", "must be between 1s and 30s")
+	}
+	checkRange := func(key string, r DurationRange) {
+		if r.Min < 0 || r.Max < 0 || (r.Max != 0 && r.Max < r.Min) {
+			fail(key, "min %s and max %s do not form a range", r.Min, r.Max)
+		}
+	}
+	checkRange("catalog.track_duration", c.Catalog.TrackDuration)
+	checkRange("catalog.beacon_duration", c.Catalog.BeaconDuration)
+
+	return errors.Join(errs...)
+}
+
+// Masked returns a copy with every `secret:"true"` field blanked out, for
+// printing.
+func (c Config) Masked() Config {
+	masked := c
+	maskSecrets(reflect.ValueOf(&masked).Elem())
+	return masked
+}
+
+// Print writes the configuration as YAML with secrets masked.
+func (c Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Masked()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+const mask = "********"
+
+func maskSecrets(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field, fv := t.Field(i), v.Field(i)
+		if field.Tag.Get("secret") != "true" {
+			if fv.Kind() == reflect.Struct {
+				maskSecrets(fv)
+			}
+			continue
+		}
+		// Maps and slices are rebuilt rather than edited in place so the
+		// original Config, which shares them, keeps its values.
+		switch fv.Kind() {
+		case reflect.String:
+			if fv.Len() > 0 {
+				fv.SetString(mask)
+			}
+		case reflect.Map:
+			if fv.IsNil() {
+				continue
+			}
+			m := reflect.MakeMapWithSize(fv.Type(), fv.Len())
+			for _, k := range fv.MapKeys() {
+				m.SetMapIndex(k, reflect.ValueOf(mask))
+			}
+			fv.Set(m)
+		case reflect.Slice:
+			if fv.IsNil() {
+				continue
+			}
+			s := reflect.MakeSlice(fv.Type(), fv.Len(), fv.Len())
+			for j := 0; j < fv.Len(); j++ {
+				s.Index(j).SetString(mask)
+			}
+			fv.Set(s)
+		}
+	}
+}
+
+// envKey turns SONARE_LOG_MAX_SIZE_MB into log.max_size_mb by matching
+// against the known keys, since both "." and "_" map to "_".
+func envKey(name string) string {
+	want := strings.ToLower(strings.TrimPrefix(name, EnvPrefix))
+	for _, key := range Keys() {
+		if strings.ReplaceAll(key, ".", "_") == want {
+			return key
+		}
+	}
+	return want
+}
+
+// Keys lists every settable dotted key, e.g. "log.level".
+func Keys() []string {
+	var keys []string
+	collectKeys(reflect.TypeOf(Config{}), "", &keys)
+	return keys
+}
+
+func collectKeys(t reflect.Type, prefix string, keys *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := prefix + yamlName(field)
+		if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+			collectKeys(field.Type, key+".", keys)
+			continue
+		}
+		*keys = append(*keys, key)
+	}
+}
+
+// HasKey reports whether key names a setting.
+func (c *Config) HasKey(key string) bool {
+	_, _, err := lookup(reflect.ValueOf(c).Elem(), key)
+	return err == nil
+}
+
+// Set parses value into the setting named by a dotted key. It is how
+// environment variables and command-line flags are layered on top.
+func (c *Config) Set(key, value string) error {
+	fv, field, err := lookup(reflect.ValueOf(c).Elem(), key)
+	if err != nil {
+		return err
+	}
+	return setValue(fv, field, value)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func lookup(v reflect.Value, key string) (reflect.Value, reflect.StructField, error) {
+	parts := strings.Split(key, ".")
+	var field reflect.StructField
+	for i, part := range parts {
+		t := v.Type()
+		found := false
+		for j := 0; j < t.NumField(); j++ {
+			if yamlName(t.Field(j)) == part {
+				field, v, found = t.Field(j), v.Field(j), true
+				break
+			}
+		}
+		if !found || (i < len(parts)-1 && v.Kind() != reflect.Struct) {
+			return reflect.Value{}, reflect.StructField{}, fmt.Errorf("no such config key %q", key)
+		}
+	}
+	if v.Kind() == reflect.Struct && v.Type() != durationType {
+		return reflect.Value{}, reflect.StructField{}, fmt.Errorf("config key %q is a section, not a setting", key)
+	}
+	return v, field, nil
+}
+
+func setValue(v reflect.Value, field reflect.StructField, s string) error {
+	s = strings.TrimSpace(s)
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", s)
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", s)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", s)
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		items := splitList(s, separator(field))
+		v.Set(reflect.ValueOf(items))
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String && v.Type().Elem().Kind() == reflect.String:
+		m := map[string]string{}
+		for _, pair := range splitList(s, separator(field)) {
+			k, val, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("%q is not a key=value pair", pair)
+			}
+			m[strings.TrimSpace(k)] = strings.TrimSpace(val)
+		}
+		v.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+func separator(field reflect.StructField) string {
+	if sep := field.Tag.Get("sep"); sep != "" {
+		return sep
+	}
+	return ","
+}
+
+func splitList(s, sep string) []string {
+	var items []string
+	for _, item := range strings.Split(s, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func yamlName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadLayersFileThenEnv(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "sonare.yaml")
+	file := "port: \"9000\"\nlog:\n  level: debug\n  max_size_mb: 5\ncache:\n  music: 1h\n"
+	if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	cfg, err := Load(path, []string{
+		"SONARE_LOG_LEVEL=warn",
+		"SONARE_LOG_MAX_SIZE_MB=7",
+		"SONARE_SECURITY_CSP=default-src 'self'; img-src 'self'",
+		"SONARE_TRACING_HEADERS=authorization=Bearer abc",
+		"SONARE_CONFIG=ignored.yaml",
+		"PATH=/usr/bin",
+	})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if cfg.Port != "9000" {
+		t.Errorf("port from file: got=%q", cfg.Port)
+	}
+	if cfg.Log.Level != "warn" || cfg.Log.MaxSizeMB != 7 {
+		t.Errorf("env should override file: level=%q max_size_mb=%d", cfg.Log.Level, cfg.Log.MaxSizeMB)
+	}
+	if cfg.Cache.Music != time.Hour {
+		t.Errorf("cache.music: got=%v want=1h", cfg.Cache.Music)
+	}
+	if len(cfg.Security.CSP) != 2 || cfg.Security.CSP[1] != "img-src 'self'" {
+		t.Errorf("security.csp: got=%q", cfg.Security.CSP)
+	}
+	if cfg.Tracing.Headers["authorization"] != "Bearer abc" {
+		t.Errorf("tracing.headers: got=%v", cfg.Tracing.Headers)
+	}
+	if cfg.DB != Default().DB {
+		t.Errorf("unset keys should keep defaults: db=%q", cfg.DB)
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "sonare.yaml")
+	if err := os.WriteFile(path, []byte("prot: 80\n"), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if _, err := Load(path, nil); err == nil {
+		t.Fatal("expected error for unknown file key")
+	}
+	if _, err := Load("", []string{"SONARE_PROT=80"}); err == nil {
+		t.Fatal("expected error for unknown environment key")
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	t.Parallel()
+
+	cfg := Default()
+	cfg.WebDir = t.TempDir()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("defaults should validate: %v", err)
+	}
+
+	cfg.Port = "http"
+	cfg.Log.Format = "xml"
+	cfg.Tracing.SampleRatio = 2
+	cfg.TLS.ACME.Enabled = true
+	cfg.TLS.ACME.DirectoryURL = "ftp://ca.example"
+	cfg.Security.CSPReportURI = "javascript:alert(1)"
+	cfg.Catalog.BeaconDuration = DurationRange{Min: time.Minute, Max: time.Second}
+	cfg.Security.SignedURLs.Enabled = true
+	cfg.Security.SignedURLs.Keys = []string{"k1:short"}
+	cfg.AdminToken = "hunter2"
+	cfg.Catalog.MaxUploadSize = 0
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, key := range []string{"port:", "log.format:", "tracing.sample_ratio:", "tls.acme.directory_url:", "tls.acme.hosts:", "security.csp_report_uri:", "security.signed_urls.keys:", "admin_token:", "catalog.max_upload_size:", "catalog.beacon_duration:"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("missing %s in %q", key, err)
+		}
+	}
+}
+
+func TestMaskedLeavesOriginalIntact(t *testing.T) {
+	t.Parallel()
+
+	cfg := Default()
+	cfg.Tracing.Headers = map[string]string{"authorization": "Bearer abc"}
+
+	masked := cfg.Masked()
+	if masked.Tracing.Headers["authorization"] != mask {
+		t.Fatalf("secret not masked: %v", masked.Tracing.Headers)
+	}
+	if cfg.Tracing.Headers["authorization"] != "Bearer abc" {
+		t.Fatalf("masking mutated the original: %v", cfg.Tracing.Headers)
+	}
+}
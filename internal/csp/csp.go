@@ -0,0 +1,216 @@
+// Package csp issues per-request Content-Security-Policy nonces, expands
+// them into policy headers, and parses the violation reports browsers send
+// to the report-uri and report-to endpoints.
+package csp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	// NonceToken is replaced with the request's nonce wherever it appears
+	// in a configured directive, e.g. "script-src 'self' 'nonce-{nonce}'".
+	NonceToken = "{nonce}"
+	// ReportGroup names the Reporting-Endpoints entry used by report-to.
+	ReportGroup = "csp-endpoint"
+	// MaxReportsPerRequest bounds how many reports one body may carry.
+	MaxReportsPerRequest = 32
+	// maxFieldLen truncates report fields, which are attacker-controlled.
+	maxFieldLen = 2048
+)
+
+type nonceKey struct{}
+
+// Middleware gives every request a fresh nonce. It must run outside any
+// middleware that needs to see the same *http.Request that ServeMux routes,
+// because attaching the nonce clones the request.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), nonceKey{}, NewNonce())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Nonce returns the nonce assigned by Middleware, or "" outside of it.
+func Nonce(ctx context.Context) string {
+	n, _ := ctx.Value(nonceKey{}).(string)
+	return n
+}
+
+// NewNonce returns 128 random bits in base64, as CSP Level 3 recommends.
+func NewNonce() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return base64.StdEncoding.EncodeToString(b[:])
+}
+
+// Policy joins directives into a header value, substituting nonce for
+// NonceToken and appending report-uri and report-to when reportURI is set.
+// Directives that mention the token are dropped when there is no nonce, so
+// a policy never names an empty one.
+func Policy(directives []string, nonce, reportURI string) string {
+	parts := make([]string, 0, len(directives)+2)
+	for _, d := range directives {
+		if strings.Contains(d, NonceToken) {
+			if nonce == "" {
+				d = strings.TrimSpace(strings.ReplaceAll(d, "'nonce-"+NonceToken+"'", ""))
+			} else {
+				d = strings.ReplaceAll(d, NonceToken, nonce)
+			}
+		}
+		parts = append(parts, d)
+	}
+	if reportURI != "" {
+		parts = append(parts, "report-uri "+reportURI, "report-to "+ReportGroup)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// ReportingEndpoints is the Reporting-Endpoints header value that points
+// the report-to group at uri.
+func ReportingEndpoints(uri string) string {
+	return ReportGroup + `="` + uri + `"`
+}
+
+// Report is one CSP violation, normalised across the legacy report-uri
+// format and the Reporting API.
+type Report struct {
+	DocumentURI        string
+	Referrer           string
+	EffectiveDirective string
+	BlockedURI         string
+	SourceFile         string
+	Line               int
+	Column             int
+	// Disposition is "enforce" or "report" (from a Report-Only policy).
+	Disposition string
+	Sample      string
+	StatusCode  int
+}
+
+// legacyReport is the body browsers POST to report-uri as
+// application/csp-report.
+type legacyReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		Referrer           string `json:"referrer"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		BlockedURI         string `json:"blocked-uri"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		ColumnNumber       int    `json:"column-number"`
+		Disposition        string `json:"disposition"`
+		ScriptSample       string `json:"script-sample"`
+		StatusCode         int    `json:"status-code"`
+	} `json:"csp-report"`
+}
+
+// reportingAPIReport is one entry of an application/reports+json body.
+type reportingAPIReport struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		Referrer           string `json:"referrer"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		BlockedURL         string `json:"blockedURL"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+		ColumnNumber       int    `json:"columnNumber"`
+		Disposition        string `json:"disposition"`
+		Sample             string `json:"sample"`
+		StatusCode         int    `json:"statusCode"`
+	} `json:"body"`
+}
+
+// ErrUnsupportedType is returned for bodies that are neither report format.
+var ErrUnsupportedType = errors.New("unsupported report content type")
+
+// ParseReports decodes a report body by its Content-Type. Reporting API
+// entries other than csp-violation are skipped.
+func ParseReports(contentType string, body []byte) ([]Report, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/csp-report", "application/json":
+		var lr legacyReport
+		if err := json.Unmarshal(body, &lr); err != nil {
+			return nil, err
+		}
+		r := lr.Report
+		directive := r.EffectiveDirective
+		if directive == "" {
+			// Older browsers only send the violated directive.
+			directive, _, _ = strings.Cut(r.ViolatedDirective, " ")
+		}
+		return []Report{clip(Report{
+			DocumentURI:        r.DocumentURI,
+			Referrer:           r.Referrer,
+			EffectiveDirective: directive,
+			BlockedURI:         r.BlockedURI,
+			SourceFile:         r.SourceFile,
+			Line:               r.LineNumber,
+			Column:             r.ColumnNumber,
+			Disposition:        disposition(r.Disposition),
+			Sample:             r.ScriptSample,
+			StatusCode:         r.StatusCode,
+		})}, nil
+	case "application/reports+json":
+		var entries []reportingAPIReport
+		if err := json.Unmarshal(body, &entries); err != nil {
+			return nil, err
+		}
+		var reports []Report
+		for _, e := range entries {
+			if e.Type != "csp-violation" {
+				continue
+			}
+			if len(reports) == MaxReportsPerRequest {
+				break
+			}
+			doc := e.Body.DocumentURL
+			if doc == "" {
+				doc = e.URL
+			}
+			reports = append(reports, clip(Report{
+				DocumentURI:        doc,
+				Referrer:           e.Body.Referrer,
+				EffectiveDirective: e.Body.EffectiveDirective,
+				BlockedURI:         e.Body.BlockedURL,
+				SourceFile:         e.Body.SourceFile,
+				Line:               e.Body.LineNumber,
+				Column:             e.Body.ColumnNumber,
+				Disposition:        disposition(e.Body.Disposition),
+				Sample:             e.Body.Sample,
+				StatusCode:         e.Body.StatusCode,
+			}))
+		}
+		return reports, nil
+	default:
+		return nil, ErrUnsupportedType
+	}
+}
+
+// disposition keeps the metric label bounded.
+func disposition(d string) string {
+	if d == "report" {
+		return "report"
+	}
+	return "enforce"
+}
+
+func clip(r Report) Report {
+	for _, s := range []*string{&r.DocumentURI, &r.Referrer, &r.EffectiveDirective, &r.BlockedURI, &r.SourceFile, &r.Sample} {
+		if len(*s) > maxFieldLen {
+			*s = (*s)[:maxFieldLen]
+		}
+	}
+	return r
+}
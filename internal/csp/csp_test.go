@@ -0,0 +1,181 @@
+package csp
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPolicy(t *testing.T) {
+	t.Parallel()
+
+	directives := []string{"default-src 'self'", "script-src 'self' 'nonce-{nonce}'"}
+	tests := []struct {
+		name      string
+		nonce     string
+		reportURI string
+		want      string
+	}{
+		{
+			name:  "nonce",
+			nonce: "abc",
+			want:  "default-src 'self'; script-src 'self' 'nonce-abc'",
+		},
+		{
+			name: "no nonce",
+			want: "default-src 'self'; script-src 'self'",
+		},
+		{
+			name:      "reporting",
+			nonce:     "abc",
+			reportURI: "/api/csp-report",
+			want:      "default-src 'self'; script-src 'self' 'nonce-abc'; report-uri /api/csp-report; report-to csp-endpoint",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := Policy(directives, tt.nonce, tt.reportURI); got != tt.want {
+				t.Errorf("policy mismatch: got=%q want=%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareAssignsFreshNonces(t *testing.T) {
+	t.Parallel()
+
+	var seen []string
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, Nonce(r.Context()))
+	}))
+	for range 2 {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	for _, n := range seen {
+		if b, err := base64.StdEncoding.DecodeString(n); err != nil || len(b) != 16 {
+			t.Fatalf("nonce mismatch: got=%q err=%v", n, err)
+		}
+	}
+	if seen[0] == seen[1] {
+		t.Fatalf("nonce reused: %q", seen[0])
+	}
+	if got := Nonce(context.Background()); got != "" {
+		t.Fatalf("nonce outside middleware: got=%q want=\"\"", got)
+	}
+}
+
+func TestParseReports(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        []Report
+		wantErr     bool
+	}{
+		{
+			name:        "report-uri",
+			contentType: "application/csp-report",
+			body: `{"csp-report":{"document-uri":"https://sonare.media/","violated-directive":"script-src-elem 'self'",` +
+				`"blocked-uri":"inline","source-file":"https://sonare.media/","line-number":12,"column-number":3,"disposition":"report"}}`,
+			want: []Report{{
+				DocumentURI:        "https://sonare.media/",
+				EffectiveDirective: "script-src-elem",
+				BlockedURI:         "inline",
+				SourceFile:         "https://sonare.media/",
+				Line:               12,
+				Column:             3,
+				Disposition:        "report",
+			}},
+		},
+		{
+			name:        "reporting api",
+			contentType: "application/reports+json",
+			body: `[{"type":"deprecation","body":{}},` +
+				`{"type":"csp-violation","url":"https://sonare.media/","body":{"effectiveDirective":"script-src-elem",` +
+				`"blockedURL":"https://evil.example/x.js","lineNumber":4,"disposition":"enforce","sample":""}}]`,
+			want: []Report{{
+				DocumentURI:        "https://sonare.media/",
+				EffectiveDirective: "script-src-elem",
+				BlockedURI:         "https://evil.example/x.js",
+				Line:               4,
+				Disposition:        "enforce",
+			}},
+		},
+		{
+			name:        "wrong type",
+			contentType: "text/plain",
+			body:        `{}`,
+			wantErr:     true,
+		},
+		{
+			name:        "malformed",
+			contentType: "application/csp-report",
+			body:        `{"csp-report":`,
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseReports(tt.contentType, []byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error mismatch: got=%v wantErr=%v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("report count mismatch: got=%d want=%d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("report %d mismatch: got=%+v want=%+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseReportsClipsFields(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("a", 3*maxFieldLen)
+	got, err := ParseReports("application/csp-report", []byte(`{"csp-report":{"blocked-uri":"`+long+`"}}`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(got[0].BlockedURI) != maxFieldLen {
+		t.Fatalf("blocked-uri length mismatch: got=%d want=%d", len(got[0].BlockedURI), maxFieldLen)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	t.Parallel()
+
+	l := NewLimiter(60, 3)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	steps := []struct {
+		client string
+		after  time.Duration
+		want   bool
+	}{
+		{"a", 0, true},
+		{"a", 0, true},
+		{"a", 0, true},
+		{"a", 0, false},
+		{"b", 0, true},
+		{"a", 500 * time.Millisecond, false},
+		{"a", time.Second, true},
+		{"a", time.Second, false},
+		{"a", time.Hour, true},
+	}
+	for i, s := range steps {
+		if got := l.Allow(s.client, start.Add(s.after)); got != s.want {
+			t.Errorf("step %d (%s at +%v) mismatch: got=%v want=%v", i, s.client, s.after, got, s.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package csp
+
+import (
+	"sync"
+	"time"
+)
+
+// maxLimiterClients bounds how many addresses a Limiter remembers.
+const maxLimiterClients = 4096
+
+// Limiter bounds how often each client may post reports. A page load
+// sends a handful at once and little after, so a token bucket per address
+// lets real browsers through while capping what any one client can make
+// the server write.
+type Limiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]bucket
+}
+
+type bucket struct {
+	tokens float64
+	seen   time.Time
+}
+
+// NewLimiter allows each client burst requests at once, refilled at
+// perMinute.
+func NewLimiter(perMinute, burst int) *Limiter {
+	return &Limiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]bucket),
+	}
+}
+
+// Allow reports whether client may post now, spending a token if so.
+func (l *Limiter) Allow(client string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= maxLimiterClients {
+			l.forgetIdle(now)
+		}
+		b = bucket{tokens: l.burst}
+	} else {
+		b.tokens = min(l.burst, b.tokens+now.Sub(b.seen).Seconds()*l.rate)
+	}
+	b.seen = now
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	l.buckets[client] = b
+	return allowed
+}
+
+// forgetIdle drops clients whose buckets have refilled, which a new bucket
+// would match anyway. If that frees nothing, it starts over rather than
+// track recency.
+func (l *Limiter) forgetIdle(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.seen).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+	if len(l.buckets) >= maxLimiterClients {
+		clear(l.buckets)
+	}
+}
@@ -0,0 +1,100 @@
+package hls
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// Packager opens the files of one filesystem as tracks and remembers
+// them. There is one entry per name, stamped with the file's size and
+// modification time, so a replaced file is cut again and takes its old
+// entry's place while an unchanged one is never cut twice.
+type Packager struct {
+	fsys    fs.FS
+	target  time.Duration
+	entries sync.Map // name → packagerEntry
+}
+
+type packagerEntry struct {
+	size    int64
+	mod     time.Time
+	track   *Track
+	version string
+	err     error
+}
+
+// NewPackager returns a packager cutting the files of fsys into segments
+// of about target.
+func NewPackager(fsys fs.FS, target time.Duration) *Packager {
+	return &Packager{fsys: fsys, target: target}
+}
+
+// Source is an open file and its track.
+type Source struct {
+	Track *Track
+	// Version is a hash of the file's content; it suits an ETag.
+	Version string
+	File    io.ReaderAt
+	closer  io.Closer
+}
+
+// Close closes the file.
+func (s *Source) Close() error {
+	return s.closer.Close()
+}
+
+// Open opens name and returns it with its track. Failures are cached
+// too, so a broken file costs one parse until it changes.
+func (p *Packager) Open(name string) (*Source, error) {
+	f, err := p.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		// Files without ReadAt are read whole; previews are small.
+		data, err := io.ReadAll(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		ra = bytes.NewReader(data)
+	}
+
+	v, ok := p.entries.Load(name)
+	e, _ := v.(packagerEntry)
+	if !ok || e.size != stat.Size() || !e.mod.Equal(stat.ModTime()) {
+		e = open(ra, stat.Size(), p.target)
+		e.size, e.mod = stat.Size(), stat.ModTime()
+		p.entries.Store(name, e)
+	}
+	if e.err != nil {
+		f.Close()
+		return nil, e.err
+	}
+	return &Source{Track: e.track, Version: e.version, File: ra, closer: f}, nil
+}
+
+func open(r io.ReaderAt, size int64, target time.Duration) packagerEntry {
+	track, err := Open(r, size, target)
+	if err != nil {
+		return packagerEntry{err: err}
+	}
+	// Embedded files all have a zero modification time, so the content
+	// itself has to tell versions apart.
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, size)); err != nil {
+		return packagerEntry{err: err}
+	}
+	return packagerEntry{track: track, version: hex.EncodeToString(h.Sum(nil)[:16])}
+}
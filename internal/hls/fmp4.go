@@ -0,0 +1,119 @@
+package hls
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// trackID is the ID of the single track of every presentation.
+const trackID = 1
+
+// Init returns the initialization segment: a movie with the source's
+// sample description and no samples, extended for fragments.
+//
+// The source's edit list is not carried over, so the encoder delay (a few
+// dozen milliseconds of silence) is played rather than trimmed.
+func (t *Track) Init() []byte {
+	ftyp := mkbox("ftyp", []byte("iso6"), u32(0), []byte("iso6mp41"))
+
+	matrix := concat(u32(0x00010000), u32(0), u32(0), u32(0), u32(0x00010000), u32(0), u32(0), u32(0), u32(0x40000000))
+	mvhd := mkbox("mvhd", u32(0), u32(0), u32(0), u32(t.Timescale), u32(0),
+		u32(0x00010000), u16(0x0100), make([]byte, 10), matrix, make([]byte, 24), u32(trackID+1))
+	// Flags: track enabled and in movie.
+	tkhd := mkbox("tkhd", u32(3), u32(0), u32(0), u32(trackID), u32(0), u32(0),
+		make([]byte, 8), u16(0), u16(0), u16(0x0100), u16(0), matrix, u32(0), u32(0))
+	// Language "und", packed as three five-bit letters.
+	mdhd := mkbox("mdhd", u32(0), u32(0), u32(0), u32(t.Timescale), u32(0), u16(0x55c4), u16(0))
+	hdlr := mkbox("hdlr", u32(0), u32(0), []byte("soun"), make([]byte, 12), []byte("SoundHandler\x00"))
+	dinf := mkbox("dinf", mkbox("dref", u32(0), u32(1), mkbox("url ", u32(1))))
+	stbl := mkbox("stbl",
+		mkbox("stsd", u32(0), u32(1), t.entry),
+		mkbox("stts", u32(0), u32(0)),
+		mkbox("stsc", u32(0), u32(0)),
+		mkbox("stsz", u32(0), u32(0), u32(0)),
+		mkbox("stco", u32(0), u32(0)),
+	)
+	minf := mkbox("minf", mkbox("smhd", u32(0), u16(0), u16(0)), dinf, stbl)
+	trak := mkbox("trak", tkhd, mkbox("mdia", mdhd, hdlr, minf))
+	mvex := mkbox("mvex", mkbox("trex", u32(0), u32(trackID), u32(1), u32(0), u32(0), u32(0)))
+
+	return concat(ftyp, mkbox("moov", mvhd, trak, mvex))
+}
+
+// WriteSegment writes media segment n, reading its frames from src, the
+// file the track was opened from.
+func (t *Track) WriteSegment(w io.Writer, src io.ReaderAt, n int) error {
+	if n < 0 || n >= len(t.Segments) {
+		return fmt.Errorf("segment %d out of range", n)
+	}
+	seg := t.Segments[n]
+	samples := t.samples[seg.first : seg.first+seg.count]
+
+	// Flags: data offset, sample durations and sample sizes present.
+	entries := make([]byte, 0, 8*len(samples))
+	for _, s := range samples {
+		entries = binary.BigEndian.AppendUint32(entries, s.Duration)
+		entries = binary.BigEndian.AppendUint32(entries, s.Size)
+	}
+	trun := func(dataOffset uint32) []byte {
+		return mkbox("trun", u32(0x000301), u32(uint32(len(samples))), u32(dataOffset), entries)
+	}
+	moof := func(dataOffset uint32) []byte {
+		// Flags: offsets are relative to the start of the moof.
+		tfhd := mkbox("tfhd", u32(0x020000), u32(trackID))
+		tfdt := mkbox("tfdt", u32(1<<24), u64(seg.Start))
+		return mkbox("moof", mkbox("mfhd", u32(0), u32(uint32(n+1))), mkbox("traf", tfhd, tfdt, trun(dataOffset)))
+	}
+	// The data offset does not change the moof's size, so measure it with
+	// a placeholder first.
+	header := moof(0)
+	header = moof(uint32(len(header) + 8))
+
+	data := make([]byte, seg.size)
+	off := 0
+	for i := 0; i < len(samples); {
+		// Frames are usually stored back to back; read each run at once.
+		j, end := i+1, samples[i].Offset+int64(samples[i].Size)
+		for j < len(samples) && samples[j].Offset == end {
+			end += int64(samples[j].Size)
+			j++
+		}
+		size := int(end - samples[i].Offset)
+		if _, err := src.ReadAt(data[off:off+size], samples[i].Offset); err != nil {
+			return fmt.Errorf("read frames: %w", err)
+		}
+		off += size
+		i = j
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(concat(u32(uint32(8+len(data))), []byte("mdat"))); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func mkbox(typ string, parts ...[]byte) []byte {
+	body := concat(parts...)
+	return concat(u32(uint32(8+len(body))), []byte(typ), body)
+}
+
+func concat(parts ...[]byte) []byte {
+	var n int
+	for _, p := range parts {
+		n += len(p)
+	}
+	b := make([]byte, 0, n)
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func u64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
@@ -0,0 +1,156 @@
+// Package hls repackages progressive MP4 audio into HTTP Live Streaming
+// presentations: an fMP4 initialization segment, media segments cut at
+// frame boundaries, and the playlists that list them (RFC 8216). Nothing is
+// transcoded; segments are built on request from the frames of the source
+// file, so a preview that is stopped after a few seconds costs only the
+// segments that were played.
+package hls
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"sonare.media/internal/mp4"
+)
+
+// Content types of the files of a presentation.
+const (
+	PlaylistType = "application/vnd.apple.mpegurl"
+	SegmentType  = "audio/mp4"
+)
+
+// Names of the files of a presentation, relative to its directory.
+// Segment n is named SegmentName(n).
+const (
+	MasterName = "master.m3u8"
+	MediaName  = "media.m3u8"
+	InitName   = "init.mp4"
+)
+
+// SegmentName returns the file name of media segment n.
+func SegmentName(n int) string {
+	return fmt.Sprintf("%d.m4s", n)
+}
+
+// Track is one rendition cut into segments. It holds only the sample
+// table; frames are read from the source when a segment is written.
+type Track struct {
+	// Codec is the RFC 6381 codec string, e.g. "mp4a.40.2".
+	Codec     string
+	Timescale uint32
+	Segments  []Segment
+	// PeakBitrate and AverageBitrate are in bits per second, as the
+	// master playlist's BANDWIDTH and AVERAGE-BANDWIDTH want them.
+	PeakBitrate    int
+	AverageBitrate int
+
+	entry   []byte
+	samples []mp4.Sample
+}
+
+// Segment is a run of frames played back to back.
+type Segment struct {
+	// Start is the decode time of the first frame and Duration the
+	// length of the run, both in units of Track.Timescale.
+	Start    uint64
+	Duration uint64
+	first    int
+	count    int
+	size     int64
+}
+
+// Seconds returns the segment's length in seconds.
+func (s Segment) Seconds(timescale uint32) float64 {
+	return float64(s.Duration) / float64(timescale)
+}
+
+// Open reads the sample table of the file in r and cuts it into segments
+// of at least target, except the last.
+func Open(r io.ReaderAt, size int64, target time.Duration) (*Track, error) {
+	if target <= 0 {
+		return nil, fmt.Errorf("segment duration must be positive")
+	}
+	info, err := mp4.Read(r, size)
+	if err != nil {
+		return nil, err
+	}
+	st, err := mp4.ReadSamples(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if st.Timescale == 0 || st.Entry == nil || len(st.Samples) == 0 {
+		return nil, fmt.Errorf("audio track has no playable samples")
+	}
+
+	t := &Track{Codec: info.Codec, Timescale: st.Timescale, entry: st.Entry, samples: st.Samples}
+	limit := uint64(target.Seconds() * float64(st.Timescale))
+	seg := Segment{}
+	var total int64
+	var end uint64
+	for i, s := range st.Samples {
+		if seg.count > 0 && seg.Duration >= limit {
+			t.Segments = append(t.Segments, seg)
+			seg = Segment{Start: end, first: i}
+		}
+		seg.count++
+		seg.Duration += uint64(s.Duration)
+		seg.size += int64(s.Size)
+		end += uint64(s.Duration)
+		total += int64(s.Size)
+	}
+	t.Segments = append(t.Segments, seg)
+
+	for _, s := range t.Segments {
+		if secs := s.Seconds(t.Timescale); secs > 0 {
+			t.PeakBitrate = max(t.PeakBitrate, int(math.Ceil(float64(s.size*8)/secs)))
+		}
+	}
+	if secs := float64(end) / float64(t.Timescale); secs > 0 {
+		t.AverageBitrate = int(math.Ceil(float64(total*8) / secs))
+	}
+	return t, nil
+}
+
+// MediaPlaylist returns the rendition's playlist. uri maps the names of
+// the initialization and media segments to the URIs listed, so they can
+// carry a query string.
+func (t *Track) MediaPlaylist(uri func(name string) string) string {
+	target := 0.0
+	for _, s := range t.Segments {
+		target = max(target, s.Seconds(t.Timescale))
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:7\n")
+	// EXT-X-TARGETDURATION is an upper bound on the rounded durations.
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Round(target)))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+	fmt.Fprintf(&b, "#EXT-X-MAP:URI=%q\n", uri(InitName))
+	for i, s := range t.Segments {
+		fmt.Fprintf(&b, "#EXTINF:%.5f,\n%s\n", s.Seconds(t.Timescale), uri(SegmentName(i)))
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.String()
+}
+
+// Variant is one rendition as the master playlist lists it.
+type Variant struct {
+	// URI locates the rendition's media playlist.
+	URI   string
+	Track *Track
+}
+
+// MasterPlaylist lists the renditions of one track. Players start with
+// the first and switch between them as bandwidth allows.
+func MasterPlaylist(variants []Variant) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for _, v := range variants {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d,CODECS=%q\n%s\n",
+			v.Track.PeakBitrate, v.Track.AverageBitrate, v.Track.Codec, v.URI)
+	}
+	return b.String()
+}
@@ -0,0 +1,81 @@
+package hls
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"sonare.media/internal/mp4"
+)
+
+func TestSegmentsCoverTheSource(t *testing.T) {
+	t.Parallel()
+
+	data, err := os.ReadFile("../../web/music/WARM_Sonare.m4a")
+	if err != nil {
+		t.Fatalf("read preview: %v", err)
+	}
+	src := bytes.NewReader(data)
+	track, err := Open(src, int64(len(data)), 2*time.Second)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	table, err := mp4.ReadSamples(src, int64(len(data)))
+	if err != nil {
+		t.Fatalf("read samples: %v", err)
+	}
+
+	init, err := mp4.Read(bytes.NewReader(track.Init()), int64(len(track.Init())))
+	if err != nil {
+		t.Fatalf("read init segment: %v", err)
+	}
+	if init.Codec != track.Codec || init.SampleRate == 0 {
+		t.Errorf("init segment mismatch: got=%s@%d want=%s", init.Codec, init.SampleRate, track.Codec)
+	}
+
+	var frames, next uint64
+	for n, seg := range track.Segments {
+		if seg.Start != next {
+			t.Errorf("segment %d start mismatch: got=%d want=%d", n, seg.Start, next)
+		}
+		next += seg.Duration
+		if n < len(track.Segments)-1 && seg.Seconds(track.Timescale) < 2 {
+			t.Errorf("segment %d is %.3fs, shorter than the target", n, seg.Seconds(track.Timescale))
+		}
+
+		var out bytes.Buffer
+		if err := track.WriteSegment(&out, src, n); err != nil {
+			t.Fatalf("write segment %d: %v", n, err)
+		}
+		var want []byte
+		for _, s := range table.Samples[seg.first : seg.first+seg.count] {
+			want = append(want, data[s.Offset:s.Offset+int64(s.Size)]...)
+		}
+		b := out.Bytes()
+		moof := int(binary.BigEndian.Uint32(b))
+		if string(b[4:8]) != "moof" || moof+8+len(want) != len(b) || int(binary.BigEndian.Uint32(b[moof:])) != 8+len(want) ||
+			string(b[moof+4:moof+8]) != "mdat" || !bytes.Equal(b[moof+8:], want) {
+			t.Errorf("segment %d is not a moof and an mdat of its frames", n)
+		}
+		frames += uint64(seg.count)
+	}
+	if frames != uint64(len(table.Samples)) {
+		t.Errorf("frame count mismatch: got=%d want=%d", frames, len(table.Samples))
+	}
+
+	playlist := track.MediaPlaylist(func(name string) string { return name + "?sig=x" })
+	if got := strings.Count(playlist, "#EXTINF:"); got != len(track.Segments) {
+		t.Errorf("playlist segment count mismatch: got=%d want=%d", got, len(track.Segments))
+	}
+	for _, line := range []string{`#EXT-X-MAP:URI="init.mp4?sig=x"`, "0.m4s?sig=x", "#EXT-X-ENDLIST"} {
+		if !strings.Contains(playlist, line+"\n") {
+			t.Errorf("playlist lacks %q:\n%s", line, playlist)
+		}
+	}
+	if track.PeakBitrate < track.AverageBitrate || track.AverageBitrate == 0 {
+		t.Errorf("bitrate mismatch: peak=%d average=%d", track.PeakBitrate, track.AverageBitrate)
+	}
+}
@@ -0,0 +1,138 @@
+// Package listeners opens the server's TCP sockets, preferring ones
+// inherited from the parent process through systemd-style socket
+// activation (LISTEN_FDS) over binding new ones.
+package listeners
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// firstFD is the first inherited descriptor (SD_LISTEN_FDS_START).
+const firstFD = 3
+
+// Socket activation environment variables.
+const (
+	EnvPID   = "LISTEN_PID"
+	EnvFDs   = "LISTEN_FDS"
+	EnvNames = "LISTEN_FDNAMES"
+)
+
+type inherited struct {
+	name string
+	ln   net.Listener
+}
+
+// Set hands out listeners by name, taking inherited sockets first.
+type Set struct {
+	inherited []inherited
+}
+
+// FromEnv adopts the sockets passed through LISTEN_FDS. The variables are
+// cleared so that child processes do not mistake them for their own. A
+// LISTEN_PID naming another process means the sockets are not for us.
+func FromEnv() (*Set, error) {
+	pid, count, names := os.Getenv(EnvPID), os.Getenv(EnvFDs), os.Getenv(EnvNames)
+	os.Unsetenv(EnvPID)
+	os.Unsetenv(EnvFDs)
+	os.Unsetenv(EnvNames)
+
+	s := &Set{}
+	if count == "" || (pid != "" && pid != strconv.Itoa(os.Getpid())) {
+		return s, nil
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("%s=%q is not a count", EnvFDs, count)
+	}
+	var fdNames []string
+	if names != "" {
+		fdNames = strings.Split(names, ":")
+	}
+
+	for i := 0; i < n; i++ {
+		name := "unknown"
+		if i < len(fdNames) && fdNames[i] != "" {
+			name = fdNames[i]
+		}
+		f := os.NewFile(uintptr(firstFD+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("inherited fd %d (%s): %w", firstFD+i, name, err)
+		}
+		s.inherited = append(s.inherited, inherited{name: name, ln: ln})
+	}
+	return s, nil
+}
+
+// Listen returns the inherited socket called name, else an inherited one
+// bound to addr, else a newly bound TCP listener on addr. inheritedOK
+// reports whether the socket came from the parent.
+func (s *Set) Listen(name, addr string) (ln net.Listener, inheritedOK bool, err error) {
+	if i := s.find(func(in inherited) bool { return in.name == name }); i >= 0 {
+		return s.take(i), true, nil
+	}
+	if i := s.find(func(in inherited) bool { return sameAddr(in.ln.Addr(), addr) }); i >= 0 {
+		return s.take(i), true, nil
+	}
+	ln, err = net.Listen("tcp", addr)
+	return ln, false, err
+}
+
+// Unused returns the names of inherited sockets nobody asked for.
+func (s *Set) Unused() []string {
+	names := make([]string, len(s.inherited))
+	for i, in := range s.inherited {
+		names[i] = in.name
+	}
+	return names
+}
+
+// Close closes every inherited socket that was not handed out.
+func (s *Set) Close() {
+	for _, in := range s.inherited {
+		in.ln.Close()
+	}
+	s.inherited = nil
+}
+
+func (s *Set) find(match func(inherited) bool) int {
+	for i, in := range s.inherited {
+		if match(in) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Set) take(i int) net.Listener {
+	ln := s.inherited[i].ln
+	s.inherited = append(s.inherited[:i], s.inherited[i+1:]...)
+	return ln
+}
+
+// sameAddr reports whether a bound address satisfies a listen address
+// such as ":443" or "127.0.0.1:9090". An empty host matches any host.
+func sameAddr(bound net.Addr, addr string) bool {
+	tcp, ok := bound.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || port != strconv.Itoa(tcp.Port) {
+		return false
+	}
+	if host == "" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil && host == "localhost" {
+		return tcp.IP.IsLoopback()
+	}
+	return ip != nil && ip.Equal(tcp.IP)
+}
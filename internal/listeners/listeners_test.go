@@ -0,0 +1,66 @@
+package listeners
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSameAddr(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		bound string
+		addr  string
+		want  bool
+	}{
+		{bound: "0.0.0.0:443", addr: ":443", want: true},
+		{bound: "[::]:443", addr: ":443", want: true},
+		{bound: "0.0.0.0:80", addr: ":443", want: false},
+		{bound: "127.0.0.1:9090", addr: "127.0.0.1:9090", want: true},
+		{bound: "127.0.0.1:9090", addr: "localhost:9090", want: true},
+		{bound: "10.0.0.1:9090", addr: "127.0.0.1:9090", want: false},
+		{bound: "10.0.0.1:9090", addr: "bogus", want: false},
+	}
+	for _, tc := range cases {
+		bound, err := net.ResolveTCPAddr("tcp", tc.bound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sameAddr(bound, tc.addr); got != tc.want {
+			t.Errorf("sameAddr(%s, %s) mismatch: got=%v want=%v", tc.bound, tc.addr, got, tc.want)
+		}
+	}
+}
+
+func TestListenPrefersInherited(t *testing.T) {
+	t.Parallel()
+
+	byName, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byAddr, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Set{inherited: []inherited{{name: "https", ln: byName}, {name: "unknown", ln: byAddr}}}
+
+	ln, ok, err := s.Listen("https", ":443")
+	if err != nil || !ok || ln != byName {
+		t.Fatalf("name match mismatch: ok=%v err=%v", ok, err)
+	}
+	ln, ok, err = s.Listen("admin", byAddr.Addr().String())
+	if err != nil || !ok || ln != byAddr {
+		t.Fatalf("address match mismatch: ok=%v err=%v", ok, err)
+	}
+	ln, ok, err = s.Listen("serve", "127.0.0.1:0")
+	if err != nil || ok {
+		t.Fatalf("fresh bind mismatch: ok=%v err=%v", ok, err)
+	}
+	for _, l := range []net.Listener{byName, byAddr, ln} {
+		l.Close()
+	}
+	if unused := s.Unused(); len(unused) != 0 {
+		t.Fatalf("unused mismatch: got=%v want=[]", unused)
+	}
+}
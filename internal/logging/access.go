@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessEntry describes one completed HTTP request.
+type AccessEntry struct {
+	Time       time.Time     `json:"time"`
+	RequestID  string        `json:"request_id,omitempty"`
+	RemoteIP   string        `json:"remote_ip"`
+	Method     string        `json:"method"`
+	URI        string        `json:"uri"`
+	Proto      string        `json:"proto"`
+	Status     int           `json:"status"`
+	Bytes      int64         `json:"bytes"`
+	Duration   time.Duration `json:"-"`
+	Referer    string        `json:"referer,omitempty"`
+	UserAgent  string        `json:"user_agent,omitempty"`
+	TLSVersion string        `json:"tls_version,omitempty"`
+}
+
+// AccessLog writes AccessEntry records in Apache combined or JSON format.
+type AccessLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+}
+
+// NewAccessLog returns an access log writing to w. format is "combined"
+// (the Apache combined format followed by the request time in seconds and
+// the TLS version) or "json".
+func NewAccessLog(w io.Writer, format string) (*AccessLog, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	switch format {
+	case "combined", "json":
+	default:
+		return nil, fmt.Errorf("invalid access log format %q (want combined or json)", format)
+	}
+	return &AccessLog{w: w, format: format}, nil
+}
+
+// Log writes one entry. Write errors are dropped: losing an access line
+// must never fail the request it describes.
+func (a *AccessLog) Log(e AccessEntry) {
+	var line []byte
+	if a.format == "json" {
+		line = a.jsonLine(e)
+	} else {
+		line = []byte(combinedLine(e))
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.w.Write(line)
+}
+
+func (a *AccessLog) jsonLine(e AccessEntry) []byte {
+	b, err := json.Marshal(struct {
+		AccessEntry
+		DurationMS float64 `json:"duration_ms"`
+	}{e, float64(e.Duration.Microseconds()) / 1000})
+	if err != nil {
+		return nil
+	}
+	return append(b, '\n')
+}
+
+func combinedLine(e AccessEntry) string {
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = fmt.Sprintf("%d", e.Bytes)
+	}
+	tlsVersion := e.TLSVersion
+	if tlsVersion == "" {
+		tlsVersion = "-"
+	}
+	return fmt.Sprintf("%s - - [%s] %q %d %s %q %q %.6f %s\n",
+		dashIfEmpty(e.RemoteIP),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method+" "+e.URI+" "+e.Proto,
+		e.Status,
+		bytes,
+		dashIfEmpty(e.Referer),
+		dashIfEmpty(e.UserAgent),
+		e.Duration.Seconds(),
+		tlsVersion,
+	)
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
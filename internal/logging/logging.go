@@ -0,0 +1,188 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Config controls log format, verbosity and file rotation.
+type Config struct {
+	Level  string // debug, info, warn, error
+	Format string // text or json
+
+	// File is the rotated log file path; empty logs to stdout only.
+	File string
+	// MaxSizeMB rotates the file once it grows past this size.
+	MaxSizeMB int
+	// MaxBackups and MaxAgeDays bound how many rotated files are kept.
+	MaxBackups int
+	MaxAgeDays int
+	// RotateEvery forces a rotation on a fixed interval (0 disables it).
+	RotateEvery time.Duration
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+// Logger owns the process-wide slog handler and its rotating files.
+type Logger struct {
+	*slog.Logger
+	cfg   Config
+	level slog.LevelVar
+
+	mu    sync.Mutex
+	files []*lumberjack.Logger
+	stop  chan struct{}
+}
+
+// Setup builds the logger described by cfg and installs it as the slog and
+// log package default, so stray log.Printf calls land in the same stream.
+func Setup(cfg Config) (*Logger, error) {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Logger{
+		cfg:  cfg,
+		stop: make(chan struct{}),
+	}
+
+	var out io.Writer = os.Stdout
+	if cfg.File != "" {
+		file, err := l.OpenFile(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		out = io.MultiWriter(os.Stdout, file)
+	}
+
+	l.level.Set(level)
+	opts := &slog.HandlerOptions{Level: &l.level}
+	var handler slog.Handler
+	switch strings.ToLower(strings.TrimSpace(cfg.Format)) {
+	case "", "text":
+		handler = slog.NewTextHandler(out, opts)
+	case "json":
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q (want text or json)", cfg.Format)
+	}
+
+	l.Logger = slog.New(handler)
+	slog.SetDefault(l.Logger)
+
+	if cfg.RotateEvery > 0 {
+		go l.rotateEvery(cfg.RotateEvery)
+	}
+	return l, nil
+}
+
+// OpenFile returns a writer for path that shares the logger's rotation
+// and compression settings, e.g. for the access log.
+func (l *Logger) OpenFile(path string) (io.Writer, error) {
+	// Older builds created log files world-writable; tighten them.
+	if _, err := os.Stat(path); err == nil {
+		if err := os.Chmod(path, 0o600); err != nil {
+			return nil, fmt.Errorf("restrict log file permissions: %w", err)
+		}
+	}
+
+	file := &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    l.cfg.MaxSizeMB,
+		MaxBackups: l.cfg.MaxBackups,
+		MaxAge:     l.cfg.MaxAgeDays,
+		Compress:   l.cfg.Compress,
+		LocalTime:  true,
+	}
+
+	l.mu.Lock()
+	l.files = append(l.files, file)
+	l.mu.Unlock()
+	return file, nil
+}
+
+func (l *Logger) rotateEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.mu.Lock()
+			files := append([]*lumberjack.Logger(nil), l.files...)
+			l.mu.Unlock()
+
+			for _, f := range files {
+				if err := f.Rotate(); err != nil {
+					l.Error("log rotation failed", "file", f.Filename, "err", err)
+				}
+			}
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// SetLevel changes the minimum level of the running logger.
+func (l *Logger) SetLevel(name string) error {
+	level, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	l.level.Set(level)
+	return nil
+}
+
+// Reopen closes every log file; each is reopened by path on its next write.
+// This picks up files moved away by an external logrotate.
+func (l *Logger) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var firstErr error
+	for _, f := range l.files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Close stops scheduled rotation and closes every log file.
+func (l *Logger) Close() error {
+	close(l.stop)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var firstErr error
+	for _, f := range l.files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// ParseLevel maps a level name to its slog.Level.
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("invalid log level %q (want debug, info, warn or error)", name)
+	}
+}
@@ -0,0 +1,115 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestRedact(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{name: "email", got: RedactEmail("jane.doe@example.com"), want: "j***@example.com"},
+		{name: "unicode email", got: RedactEmail("émile@example.com"), want: "é***@example.com"},
+		{name: "email without domain", got: RedactEmail("jane"), want: "j***"},
+		{name: "empty email", got: RedactEmail(""), want: ""},
+		{name: "full name", got: RedactName("Jane  Doe"), want: "J*** D***"},
+		{name: "unicode name", got: RedactName("Émile"), want: "É***"},
+	}
+
+	for _, tc := range tests {
+		if tc.got != tc.want {
+			t.Errorf("%s: got=%q want=%q", tc.name, tc.got, tc.want)
+		}
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	t.Parallel()
+
+	var seen string
+	handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "cf-ray-123")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if seen != "cf-ray-123" || rec.Header().Get(RequestIDHeader) != "cf-ray-123" {
+		t.Fatalf("inbound ID not propagated: ctx=%q header=%q", seen, rec.Header().Get(RequestIDHeader))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "bad id\r\nX-Evil: 1")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if seen == "" || seen == req.Header.Get(RequestIDHeader) {
+		t.Fatalf("malformed inbound ID should be replaced, got %q", seen)
+	}
+	if rec.Header().Get(RequestIDHeader) != seen {
+		t.Fatalf("response header %q does not match context ID %q", rec.Header().Get(RequestIDHeader), seen)
+	}
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	t.Parallel()
+
+	var buf strings.Builder
+	al, err := NewAccessLog(&buf, "combined")
+	if err != nil {
+		t.Fatalf("NewAccessLog: %v", err)
+	}
+
+	al.Log(AccessEntry{
+		Time:       time.Date(2026, 2, 14, 9, 30, 0, 0, time.UTC),
+		RemoteIP:   "203.0.113.7",
+		Method:     http.MethodPost,
+		URI:        "/api/lead",
+		Proto:      "HTTP/2.0",
+		Status:     http.StatusBadRequest,
+		Bytes:      12,
+		Duration:   1500 * time.Microsecond,
+		UserAgent:  "curl/8.0",
+		TLSVersion: "TLSv1.3",
+	})
+
+	want := `203.0.113.7 - - [14/Feb/2026:09:30:00 +0000] "POST /api/lead HTTP/2.0" 400 12 "-" "curl/8.0" 0.001500 TLSv1.3` + "\n"
+	if buf.String() != want {
+		t.Fatalf("combined line mismatch:\n got: %q\nwant: %q", buf.String(), want)
+	}
+
+	if _, err := NewAccessLog(&buf, "xml"); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
+
+func TestFromContextAddsTraceIDs(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("0af7651916cd43dd8448eb211c80319c")
+	spanID, _ := trace.SpanIDFromHex("b7ad6b7169203331")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  spanID,
+	}))
+
+	var buf strings.Builder
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer slog.SetDefault(prev)
+
+	FromContext(ctx).Info("hello")
+	if !strings.Contains(buf.String(), "trace_id=0af7651916cd43dd8448eb211c80319c span_id=b7ad6b7169203331") {
+		t.Fatalf("trace IDs missing from log line: %q", buf.String())
+	}
+}
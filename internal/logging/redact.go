@@ -0,0 +1,32 @@
+package logging
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// RedactEmail keeps the first character of the local part and the domain:
+// "jane.doe@example.com" becomes "j***@example.com".
+func RedactEmail(email string) string {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return RedactName(email)
+	}
+	first, _ := utf8.DecodeRuneInString(email)
+	return string(first) + "***" + email[at:]
+}
+
+// RedactName keeps only the first character of each word:
+// "Jane Doe" becomes "J*** D***".
+func RedactName(name string) string {
+	fields := strings.Fields(name)
+	if len(fields) == 0 {
+		return ""
+	}
+	for i, f := range fields {
+		r := []rune(f)
+		fields[i] = string(r[0]) + "***"
+	}
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds every Sonare collector. A dedicated registry keeps the
+// exposition free of anything third-party packages register globally.
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "sonare",
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests served, by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "sonare",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency, by route and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "status"})
+
+	HTTPInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "sonare",
+		Subsystem: "http",
+		Name:      "requests_in_flight",
+		Help:      "HTTP requests currently being served.",
+	})
+
+	LeadsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "sonare",
+		Name:      "leads_created_total",
+		Help:      "Leads persisted to the database.",
+	})
+
+	AnalyticsDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "sonare",
+		Subsystem: "analytics",
+		Name:      "dropped_total",
+		Help:      "Analytics events dropped because the queue was full.",
+	})
+
+	GeoIPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "sonare",
+		Subsystem: "geoip",
+		Name:      "lookup_duration_seconds",
+		Help:      "Latency of upstream GeoIP lookups, by result.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2, 5},
+	}, []string{"result"})
+
+	GeoIPCache = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "sonare",
+		Subsystem: "geoip",
+		Name:      "cache_requests_total",
+		Help:      "GeoIP cache lookups, by outcome (hit or miss).",
+	}, []string{"outcome"})
+
+	DBQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "sonare",
+		Subsystem: "db",
+		Name:      "query_duration_seconds",
+		Help:      "SQLite query latency, by query name.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, 1},
+	}, []string{"query"})
+
+	CSPReports = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "sonare",
+		Subsystem: "csp",
+		Name:      "reports_total",
+		Help:      "CSP violation reports received, by disposition (enforce or report).",
+	}, []string{"disposition"})
+
+	CSPReportsLimited = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "sonare",
+		Subsystem: "csp",
+		Name:      "report_requests_limited_total",
+		Help:      "CSP report requests refused because their client exceeded the rate limit.",
+	})
+
+	SignedURLRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "sonare",
+		Subsystem: "music",
+		Name:      "signed_url_rejections_total",
+		Help:      "Music requests refused for a missing or invalid signature, by reason.",
+	}, []string{"reason"})
+
+	TLSCertExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sonare",
+		Subsystem: "tls",
+		Name:      "certificate_expiry_timestamp_seconds",
+		Help:      "NotAfter of the loaded TLS certificate as a Unix timestamp.",
+	}, []string{"cert"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		HTTPInFlight,
+		LeadsCreated,
+		AnalyticsDropped,
+		GeoIPDuration,
+		GeoIPCache,
+		DBQueryDuration,
+		CSPReports,
+		CSPReportsLimited,
+		SignedURLRejections,
+		TLSCertExpiry,
+	)
+}
+
+// RegisterQueueDepth exposes the current length of a queue as a gauge that
+// is sampled at scrape time.
+func RegisterQueueDepth(name, help string, depth func() int) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "sonare",
+		Subsystem: name,
+		Name:      "queue_depth",
+		Help:      help,
+	}, func() float64 { return float64(depth()) }))
+}
+
+// ObserveQuery records how long a named DB query took. Use it as
+// `defer metrics.ObserveQuery("save_lead", time.Now())`.
+func ObserveQuery(name string, start time.Time) {
+	DBQueryDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+}
+
+// SetCertExpiry publishes the expiry time of the certificate loaded from path.
+func SetCertExpiry(path string, notAfter time.Time) {
+	TLSCertExpiry.WithLabelValues(path).Set(float64(notAfter.Unix()))
+}
+
+// Handler serves the registry in the Prometheus text exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -0,0 +1,79 @@
+package mp4
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// ReadFile parses name in fsys.
+func ReadFile(fsys fs.FS, name string) (*Info, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return readOpen(f, stat.Size())
+}
+
+func readOpen(f fs.File, size int64) (*Info, error) {
+	if ra, ok := f.(io.ReaderAt); ok {
+		return Read(ra, size)
+	}
+	// Files without ReadAt are read whole; previews are small.
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return Read(bytes.NewReader(data), int64(len(data)))
+}
+
+// Cache memoises ReadFile for the files of one filesystem. There is one
+// entry per name, stamped with the file's size and modification time, so
+// a replaced file is read again and takes its old entry's place while an
+// unchanged one is never read twice.
+type Cache struct {
+	fsys    fs.FS
+	entries sync.Map // name → cacheEntry
+}
+
+type cacheEntry struct {
+	size int64
+	mod  time.Time
+	info *Info
+	err  error
+}
+
+// NewCache returns an empty cache over fsys.
+func NewCache(fsys fs.FS) *Cache {
+	return &Cache{fsys: fsys}
+}
+
+// Info returns the parsed metadata of name. Failures are cached too, so a
+// broken file costs one parse until it changes.
+func (c *Cache) Info(name string) (*Info, error) {
+	f, err := c.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if v, ok := c.entries.Load(name); ok {
+		if e := v.(cacheEntry); e.size == stat.Size() && e.mod.Equal(stat.ModTime()) {
+			return e.info, e.err
+		}
+	}
+	info, err := readOpen(f, stat.Size())
+	c.entries.Store(name, cacheEntry{size: stat.Size(), mod: stat.ModTime(), info: info, err: err})
+	return info, err
+}
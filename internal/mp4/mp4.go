@@ -0,0 +1,480 @@
+// Package mp4 reads the metadata of MP4/M4A files: duration, the audio
+// track's codec, sample rate, channel count and bitrate, and iTunes-style
+// tags. It walks the ISO BMFF box tree directly and never decodes audio.
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrNotMP4 is returned for files that do not start with an ftyp box.
+var ErrNotMP4 = errors.New("not an MP4 file")
+
+// maxMoovSize bounds the metadata read into memory; preview files keep
+// theirs in a few kilobytes.
+const maxMoovSize = 64 << 20
+
+// Info is what Read learns about a file.
+type Info struct {
+	// Brand is the ftyp major brand, e.g. "M4A " or "isom".
+	Brand    string
+	Duration time.Duration
+	// Codec is the RFC 6381 codec string, e.g. "mp4a.40.2" for AAC-LC.
+	Codec      string
+	SampleRate int
+	Channels   int
+	// Bitrate in bits per second: the encoder's declared average, or the
+	// media data size over the duration when none is declared.
+	Bitrate int
+	Tags    Tags
+	// FastStart reports whether moov precedes mdat, which lets browsers
+	// start playback before the whole file has arrived.
+	FastStart bool
+}
+
+// Tags are the iTunes metadata items of moov/udta/meta/ilst.
+type Tags struct {
+	Title   string
+	Artist  string
+	Album   string
+	Genre   string
+	Comment string
+	Year    string
+	Encoder string
+	// Freeform holds "----" items by name, such as iTunNORM or
+	// replaygain_track_gain, which carry loudness information.
+	Freeform map[string]string
+}
+
+// box is a parsed box header; body is the offset of its payload.
+type box struct {
+	typ  string
+	off  int64
+	body int64
+	end  int64
+}
+
+// Read parses the file in r, which is size bytes long.
+func Read(r io.ReaderAt, size int64) (*Info, error) {
+	m, err := readMovie(r, size)
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{Brand: m.brand, FastStart: m.fastStart}
+	if err := info.parseMoov(m.moov); err != nil {
+		return nil, err
+	}
+	if info.Bitrate == 0 && info.Duration > 0 {
+		info.Bitrate = int(float64(m.mdatSize*8) / info.Duration.Seconds())
+	}
+	return info, nil
+}
+
+// movie is the top level of a file with its moov payload in memory.
+type movie struct {
+	brand     string
+	moov      []byte
+	mdatSize  int64
+	fastStart bool
+}
+
+func readMovie(r io.ReaderAt, size int64) (*movie, error) {
+	top, err := readBoxes(r, 0, size)
+	if err != nil || len(top) == 0 || top[0].typ != "ftyp" {
+		return nil, ErrNotMP4
+	}
+
+	m := &movie{}
+	var moov *box
+	for i := range top {
+		b := &top[i]
+		switch b.typ {
+		case "ftyp":
+			brand := make([]byte, 4)
+			if _, err := r.ReadAt(brand, b.body); err == nil {
+				m.brand = string(brand)
+			}
+		case "moov":
+			if moov == nil {
+				moov = b
+				m.fastStart = m.mdatSize == 0
+			}
+		case "mdat":
+			m.mdatSize += b.end - b.body
+		}
+	}
+	if moov == nil {
+		return nil, fmt.Errorf("no moov box")
+	}
+	if moov.end-moov.body > maxMoovSize {
+		return nil, fmt.Errorf("moov box of %d bytes is too large", moov.end-moov.body)
+	}
+
+	m.moov = make([]byte, moov.end-moov.body)
+	if _, err := r.ReadAt(m.moov, moov.body); err != nil {
+		return nil, fmt.Errorf("read moov: %w", err)
+	}
+	return m, nil
+}
+
+// readBoxes lists the boxes between off and end of r.
+func readBoxes(r io.ReaderAt, off, end int64) ([]box, error) {
+	var boxes []box
+	var hdr [16]byte
+	for off+8 <= end {
+		if _, err := r.ReadAt(hdr[:8], off); err != nil {
+			return nil, err
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[:4]))
+		b := box{typ: string(hdr[4:8]), off: off, body: off + 8}
+		switch size {
+		case 0:
+			size = end - off
+		case 1:
+			if _, err := r.ReadAt(hdr[8:16], off+8); err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			b.body = off + 16
+		}
+		if size < b.body-off || off+size > end {
+			return nil, fmt.Errorf("box %q at %d: bad size %d", b.typ, off, size)
+		}
+		b.end = off + size
+		boxes = append(boxes, b)
+		off = b.end
+	}
+	return boxes, nil
+}
+
+// children lists the boxes inside data, which is a box payload.
+func children(data []byte) []box {
+	boxes, err := readBoxes(bytesReaderAt(data), 0, int64(len(data)))
+	if err != nil {
+		return nil
+	}
+	return boxes
+}
+
+func find(data []byte, typ string) []byte {
+	for _, b := range children(data) {
+		if b.typ == typ {
+			return data[b.body:b.end]
+		}
+	}
+	return nil
+}
+
+type bytesReaderAt []byte
+
+func (b bytesReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off >= int64(len(b)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (info *Info) parseMoov(moov []byte) error {
+	var movieDuration time.Duration
+	if mvhd := find(moov, "mvhd"); mvhd != nil {
+		movieDuration = parseDuration(mvhd)
+	}
+
+	audio := false
+	for _, b := range children(moov) {
+		switch b.typ {
+		case "trak":
+			if !audio && info.parseTrak(moov[b.body:b.end]) {
+				audio = true
+			}
+		case "udta":
+			if meta := find(moov[b.body:b.end], "meta"); meta != nil {
+				info.Tags = parseMeta(meta)
+			}
+		}
+	}
+	if !audio {
+		return fmt.Errorf("no audio track")
+	}
+	if info.Duration == 0 {
+		info.Duration = movieDuration
+	}
+	return nil
+}
+
+// isSound reports whether an mdia box belongs to an audio track.
+func isSound(mdia []byte) bool {
+	hdlr := find(mdia, "hdlr")
+	return len(hdlr) >= 12 && string(hdlr[8:12]) == "soun"
+}
+
+// parseTrak fills in the audio fields from a sound track and reports
+// whether trak was one.
+func (info *Info) parseTrak(trak []byte) bool {
+	mdia := find(trak, "mdia")
+	if !isSound(mdia) {
+		return false
+	}
+	if mdhd := find(mdia, "mdhd"); mdhd != nil {
+		info.Duration = parseDuration(mdhd)
+	}
+	stsd := find(find(find(mdia, "minf"), "stbl"), "stsd")
+	if len(stsd) < 8 {
+		return true
+	}
+	entries := children(stsd[8:])
+	if len(entries) == 0 {
+		return true
+	}
+	entry := stsd[8:][entries[0].body:entries[0].end]
+	info.Codec = entries[0].typ
+	info.parseAudioSampleEntry(entry)
+	return true
+}
+
+// parseDuration reads the timescale and duration of an mvhd or mdhd box.
+func parseDuration(b []byte) time.Duration {
+	var timescale, duration uint64
+	switch {
+	case len(b) >= 32 && b[0] == 1:
+		timescale = uint64(binary.BigEndian.Uint32(b[20:24]))
+		duration = binary.BigEndian.Uint64(b[24:32])
+	case len(b) >= 20:
+		timescale = uint64(binary.BigEndian.Uint32(b[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(b[16:20]))
+	}
+	if timescale == 0 || duration == 0 || duration == 1<<32-1 {
+		return 0
+	}
+	return time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+}
+
+// parseAudioSampleEntry reads an mp4a (or other audio) sample entry.
+func (info *Info) parseAudioSampleEntry(e []byte) {
+	if len(e) < 28 {
+		return
+	}
+	info.Channels = int(binary.BigEndian.Uint16(e[16:18]))
+	info.SampleRate = int(binary.BigEndian.Uint32(e[24:28]) >> 16)
+	if esds := find(entryChildren(e), "esds"); esds != nil {
+		info.parseESDS(esds)
+	}
+}
+
+// entryChildren returns the child boxes of an audio sample entry body.
+// QuickTime sound description versions 1 and 2 append fields before them.
+func entryChildren(e []byte) []byte {
+	if len(e) < 28 {
+		return nil
+	}
+	childOff := 28
+	switch binary.BigEndian.Uint16(e[8:10]) {
+	case 1:
+		childOff += 16
+	case 2:
+		childOff += 36
+	}
+	if childOff > len(e) {
+		return nil
+	}
+	return e[childOff:]
+}
+
+// parseESDS reads the decoder configuration of an MPEG-4 elementary
+// stream descriptor (ISO/IEC 14496-1 and, for AAC, 14496-3).
+func (info *Info) parseESDS(b []byte) {
+	oti, avg, asc, ok := decoderConfig(b)
+	if !ok {
+		return
+	}
+	if avg > 0 {
+		info.Bitrate = avg
+	}
+	info.Codec = fmt.Sprintf("mp4a.%x", oti)
+	if oti != 0x40 || asc == nil {
+		return
+	}
+	aot, rate, channels := parseAudioSpecificConfig(asc)
+	if aot > 0 {
+		info.Codec = fmt.Sprintf("mp4a.40.%d", aot)
+	}
+	if rate > 0 {
+		info.SampleRate = rate
+	}
+	if channels > 0 {
+		info.Channels = channels
+	}
+}
+
+// decoderConfig digs the object type, average bitrate and decoder
+// specific info (for AAC, the AudioSpecificConfig) out of an esds box.
+func decoderConfig(b []byte) (oti byte, avg int, asc []byte, ok bool) {
+	if len(b) < 4 {
+		return 0, 0, nil, false
+	}
+	d := b[4:]
+	tag, body, _ := descriptor(d)
+	if tag != 0x03 || len(body) < 3 {
+		return 0, 0, nil, false
+	}
+	flags := body[2]
+	d = body[3:]
+	if flags&0x80 != 0 {
+		d = skip(d, 2)
+	}
+	if flags&0x40 != 0 && len(d) > 0 {
+		d = skip(d, 1+int(d[0]))
+	}
+	if flags&0x20 != 0 {
+		d = skip(d, 2)
+	}
+
+	tag, dcd, _ := descriptor(d)
+	if tag != 0x04 || len(dcd) < 13 {
+		return 0, 0, nil, false
+	}
+	oti = dcd[0]
+	avg = int(binary.BigEndian.Uint32(dcd[9:13]))
+	if tag, dsi, _ := descriptor(dcd[13:]); tag == 0x05 {
+		asc = dsi
+	}
+	return oti, avg, asc, true
+}
+
+// descriptor splits one tag-length-value descriptor off d.
+func descriptor(d []byte) (tag byte, body, rest []byte) {
+	if len(d) < 2 {
+		return 0, nil, nil
+	}
+	tag = d[0]
+	n, i := 0, 1
+	for ; i < len(d) && i <= 4; i++ {
+		n = n<<7 | int(d[i]&0x7f)
+		if d[i]&0x80 == 0 {
+			i++
+			break
+		}
+	}
+	if i+n > len(d) {
+		return 0, nil, nil
+	}
+	return tag, d[i : i+n], d[i+n:]
+}
+
+func skip(d []byte, n int) []byte {
+	if n > len(d) {
+		return nil
+	}
+	return d[n:]
+}
+
+// sampleRates indexes the sampling frequencies of ISO/IEC 14496-3.
+var sampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// parseAudioSpecificConfig returns the audio object type, sampling rate
+// and channel count of an AudioSpecificConfig.
+func parseAudioSpecificConfig(b []byte) (aot, rate, channels int) {
+	br := bitReader{b: b}
+	aot = br.read(5)
+	if aot == 31 {
+		aot = 32 + br.read(6)
+	}
+	if idx := br.read(4); idx == 0xf {
+		rate = br.read(24)
+	} else if idx < len(sampleRates) {
+		rate = sampleRates[idx]
+	}
+	channels = br.read(4)
+	if br.err {
+		return 0, 0, 0
+	}
+	return aot, rate, channels
+}
+
+type bitReader struct {
+	b   []byte
+	pos int
+	err bool
+}
+
+func (r *bitReader) read(n int) int {
+	v := 0
+	for range n {
+		if r.pos/8 >= len(r.b) {
+			r.err = true
+			return 0
+		}
+		v = v<<1 | int(r.b[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+	return v
+}
+
+// parseMeta reads the ilst items of a meta box, which is a full box in
+// ISO files but a plain container in older QuickTime ones.
+func parseMeta(meta []byte) Tags {
+	if len(meta) >= 8 && string(meta[4:8]) != "hdlr" {
+		meta = meta[4:]
+	}
+	tags := Tags{}
+	ilst := find(meta, "ilst")
+	for _, item := range children(ilst) {
+		body := ilst[item.body:item.end]
+		if item.typ == "----" {
+			name, value := parseFreeform(body)
+			if name != "" {
+				if tags.Freeform == nil {
+					tags.Freeform = make(map[string]string)
+				}
+				tags.Freeform[name] = value
+			}
+			continue
+		}
+		value := dataString(find(body, "data"))
+		switch item.typ {
+		case "\xa9nam":
+			tags.Title = value
+		case "\xa9ART", "aART":
+			if tags.Artist == "" || item.typ == "\xa9ART" {
+				tags.Artist = value
+			}
+		case "\xa9alb":
+			tags.Album = value
+		case "\xa9gen":
+			tags.Genre = value
+		case "\xa9cmt":
+			tags.Comment = value
+		case "\xa9day":
+			tags.Year = value
+		case "\xa9too":
+			tags.Encoder = value
+		}
+	}
+	return tags
+}
+
+// parseFreeform reads a "----" item's name and data.
+func parseFreeform(body []byte) (name, value string) {
+	if n := find(body, "name"); len(n) >= 4 {
+		name = string(n[4:])
+	}
+	return name, dataString(find(body, "data"))
+}
+
+// dataString returns the UTF-8 value of a data box: 4 bytes of type
+// indicator and 4 of locale precede it.
+func dataString(data []byte) string {
+	if len(data) < 8 {
+		return ""
+	}
+	return strings.TrimRight(string(data[8:]), "\x00")
+}
@@ -0,0 +1,183 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"slices"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// mkbox encodes a box of type typ around the concatenated payloads.
+func mkbox(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, typ...), body...)
+}
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+// testFile builds a minimal AAC-LC M4A: 44.1kHz stereo, 90 seconds.
+func testFile(avgBitrate uint32, moovFirst bool) []byte {
+	esds := mkbox("esds", u32(0),
+		[]byte{0x03, 25, 0, 1, 0},
+		[]byte{0x04, 17, 0x40, 0x15, 0, 0, 0}, u32(256000), u32(avgBitrate),
+		[]byte{0x05, 2, 0x12, 0x10}, // AOT 2, 44100 Hz, 2 channels
+		[]byte{0x06, 1, 2},
+	)
+	mp4a := mkbox("mp4a", make([]byte, 6), u16(1), make([]byte, 8), u16(2), u16(16), u16(0), u16(0), u32(44100<<16), esds)
+	stsd := mkbox("stsd", u32(0), u32(1), mp4a)
+	mdhd := mkbox("mdhd", u32(0), u32(0), u32(0), u32(44100), u32(90*44100), u32(0))
+	hdlr := mkbox("hdlr", u32(0), u32(0), []byte("soun"), make([]byte, 12), []byte("SoundHandler\x00"))
+	trak := mkbox("trak", mkbox("mdia", mdhd, hdlr, mkbox("minf", mkbox("stbl", stsd))))
+	mvhd := mkbox("mvhd", u32(0), u32(0), u32(0), u32(1000), u32(90000), make([]byte, 80))
+
+	data := func(s string) []byte { return mkbox("data", u32(1), u32(0), []byte(s)) }
+	ilst := mkbox("ilst",
+		mkbox("\xa9nam", data("First Light")),
+		mkbox("\xa9ART", data("sonare.media")),
+		mkbox("----", mkbox("mean", u32(0), []byte("com.apple.iTunes")), mkbox("name", u32(0), []byte("iTunNORM")), data(" 000003E8")),
+	)
+	meta := mkbox("meta", u32(0), mkbox("hdlr", u32(0), u32(0), []byte("mdir"), make([]byte, 12), []byte{0}), ilst)
+	moov := mkbox("moov", mvhd, trak, mkbox("udta", meta))
+
+	ftyp := mkbox("ftyp", []byte("M4A "), u32(0), []byte("isomM4A "))
+	mdat := mkbox("mdat", make([]byte, 1000))
+	if moovFirst {
+		return bytes.Join([][]byte{ftyp, moov, mdat}, nil)
+	}
+	return bytes.Join([][]byte{ftyp, mdat, moov}, nil)
+}
+
+func TestRead(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		file        []byte
+		wantBitrate int
+		wantFast    bool
+	}{
+		{name: "declared bitrate", file: testFile(128000, true), wantBitrate: 128000, wantFast: true},
+		{name: "derived bitrate", file: testFile(0, false), wantBitrate: 1000 * 8 / 90},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			info, err := Read(bytes.NewReader(tt.file), int64(len(tt.file)))
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if info.Brand != "M4A " || info.Codec != "mp4a.40.2" || info.SampleRate != 44100 || info.Channels != 2 {
+				t.Errorf("format mismatch: got=%+v", info)
+			}
+			if info.Duration != 90*time.Second {
+				t.Errorf("duration mismatch: got=%v want=90s", info.Duration)
+			}
+			if info.Bitrate != tt.wantBitrate {
+				t.Errorf("bitrate mismatch: got=%d want=%d", info.Bitrate, tt.wantBitrate)
+			}
+			if info.FastStart != tt.wantFast {
+				t.Errorf("fast start mismatch: got=%v want=%v", info.FastStart, tt.wantFast)
+			}
+			if info.Tags.Title != "First Light" || info.Tags.Artist != "sonare.media" || info.Tags.Freeform["iTunNORM"] != " 000003E8" {
+				t.Errorf("tags mismatch: got=%+v", info.Tags)
+			}
+		})
+	}
+}
+
+func TestReadRejectsOtherFiles(t *testing.T) {
+	t.Parallel()
+
+	for _, data := range [][]byte{nil, []byte("ID3\x04\x00\x00\x00\x00\x00\x00"), mkbox("free", make([]byte, 4))} {
+		if _, err := Read(bytes.NewReader(data), int64(len(data))); !errors.Is(err, ErrNotMP4) {
+			t.Errorf("read %q: got=%v want=%v", data, err, ErrNotMP4)
+		}
+	}
+	truncated := testFile(0, true)[:60]
+	if _, err := Read(bytes.NewReader(truncated), int64(len(truncated))); err == nil {
+		t.Error("expected error for truncated file")
+	}
+}
+
+func TestCacheRereadsChangedFiles(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{"a.m4a": {Data: testFile(128000, true)}}
+	c := NewCache(fsys)
+	first, err := c.Info("a.m4a")
+	if err != nil {
+		t.Fatalf("info: %v", err)
+	}
+	if again, _ := c.Info("a.m4a"); again != first {
+		t.Fatal("expected cached result")
+	}
+
+	fsys["a.m4a"] = &fstest.MapFile{Data: testFile(64000, true), ModTime: time.Now()}
+	changed, err := c.Info("a.m4a")
+	if err != nil || changed.Bitrate != 64000 {
+		t.Fatalf("changed file mismatch: info=%+v err=%v", changed, err)
+	}
+	entries := 0
+	c.entries.Range(func(any, any) bool { entries++; return true })
+	if entries != 1 {
+		t.Errorf("entry count mismatch: got=%d want=1", entries)
+	}
+}
+
+func TestReadSamples(t *testing.T) {
+	t.Parallel()
+
+	// Three frames of 3, 4 and 5 bytes in two chunks: two frames, then one.
+	ftyp := mkbox("ftyp", []byte("M4A "), u32(0), []byte("isomM4A "))
+	mdat := mkbox("mdat", make([]byte, 12))
+	base := uint32(len(ftyp) + 8)
+	esds := mkbox("esds", u32(0),
+		[]byte{0x03, 22, 0, 1, 0},
+		[]byte{0x04, 17, 0x40, 0x15, 0, 0, 0}, u32(0), u32(0),
+		[]byte{0x05, 2, 0x12, 0x10},
+	)
+	mp4a := mkbox("mp4a", make([]byte, 6), u16(1), make([]byte, 8), u16(2), u16(16), u16(0), u16(0), u32(44100<<16), esds)
+	stbl := mkbox("stbl",
+		mkbox("stsd", u32(0), u32(1), mp4a),
+		mkbox("stsz", u32(0), u32(0), u32(3), u32(3), u32(4), u32(5)),
+		mkbox("stco", u32(0), u32(2), u32(base), u32(base+7)),
+		mkbox("stsc", u32(0), u32(2), u32(1), u32(2), u32(1), u32(2), u32(1), u32(1)),
+		mkbox("stts", u32(0), u32(1), u32(3), u32(1024)),
+	)
+	mdhd := mkbox("mdhd", u32(0), u32(0), u32(0), u32(44100), u32(3*1024), u32(0))
+	hdlr := mkbox("hdlr", u32(0), u32(0), []byte("soun"), make([]byte, 12), []byte{0})
+	// An empty edit, then 50ms of media from after 1024 samples of priming.
+	elst := mkbox("elst", u32(0), u32(2), u32(10), u32(0xffffffff), u32(1<<16), u32(50), u32(1024), u32(1<<16))
+	mvhd := mkbox("mvhd", u32(0), u32(0), u32(0), u32(1000), u32(70), make([]byte, 80))
+	trak := mkbox("trak", mkbox("edts", elst), mkbox("mdia", mdhd, hdlr, mkbox("minf", stbl)))
+	moov := mkbox("moov", mvhd, trak)
+	file := bytes.Join([][]byte{ftyp, mdat, moov}, nil)
+
+	table, err := ReadSamples(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatalf("read samples: %v", err)
+	}
+	want := []Sample{
+		{Offset: int64(base), Size: 3, Duration: 1024},
+		{Offset: int64(base) + 3, Size: 4, Duration: 1024},
+		{Offset: int64(base) + 7, Size: 5, Duration: 1024},
+	}
+	if table.Timescale != 44100 || !slices.Equal(table.Samples, want) {
+		t.Errorf("samples mismatch: got=%d %+v want=44100 %+v", table.Timescale, table.Samples, want)
+	}
+	if !bytes.Equal(table.Config, []byte{0x12, 0x10}) {
+		t.Errorf("config mismatch: got=%x want=1210", table.Config)
+	}
+	if table.Skip != 1024 || table.Length != 2205 {
+		t.Errorf("edit mismatch: got skip=%d length=%d want skip=1024 length=2205", table.Skip, table.Length)
+	}
+
+	if _, err := ReadSamples(bytes.NewReader(file), int64(len(file))-int64(len(moov))-1); err == nil {
+		t.Error("expected error for a file cut before its moov box")
+	}
+}
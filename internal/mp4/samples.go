@@ -0,0 +1,257 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// maxSamples bounds the sample table of one track; an hour of AAC at
+// 48kHz is under 170,000 frames.
+const maxSamples = 1 << 22
+
+// Sample is one coded frame (access unit) of the audio track.
+type Sample struct {
+	// Offset and Size locate the frame in the file.
+	Offset int64
+	Size   uint32
+	// Duration is in units of SampleTable.Timescale.
+	Duration uint32
+}
+
+// SampleTable lists the audio track's frames in decoding order.
+type SampleTable struct {
+	Timescale uint32
+	// Entry is the track's first sample description (the stsd entry, box
+	// header included), which a repackaged track can carry over as is.
+	Entry []byte
+	// Config is the entry's decoder specific info (for AAC, the
+	// AudioSpecificConfig), or nil if it has none.
+	Config []byte
+	// Skip and Length come from the track's edit list, in Timescale units:
+	// decoded audio before Skip is encoder priming, and playback lasts
+	// Length from there (0 when the file does not say).
+	Skip, Length uint64
+	Samples      []Sample
+}
+
+// ReadSamples resolves the audio track's sample table (stsz, stco/co64,
+// stsc and stts) into frame offsets, sizes and durations.
+func ReadSamples(r io.ReaderAt, size int64) (*SampleTable, error) {
+	m, err := readMovie(r, size)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range children(m.moov) {
+		if b.typ != "trak" {
+			continue
+		}
+		mdia := find(m.moov[b.body:b.end], "mdia")
+		if !isSound(mdia) {
+			continue
+		}
+		st, err := parseSampleTable(mdia)
+		if err != nil {
+			return nil, err
+		}
+		st.parseEdits(m.moov[b.body:b.end], movieTimescale(m.moov))
+		for _, s := range st.Samples {
+			if s.Offset < 0 || s.Offset+int64(s.Size) > size {
+				return nil, fmt.Errorf("sample at %d+%d lies outside the file", s.Offset, s.Size)
+			}
+		}
+		return st, nil
+	}
+	return nil, fmt.Errorf("no audio track")
+}
+
+func parseSampleTable(mdia []byte) (*SampleTable, error) {
+	st := &SampleTable{}
+	if mdhd := find(mdia, "mdhd"); len(mdhd) >= 32 && mdhd[0] == 1 {
+		st.Timescale = binary.BigEndian.Uint32(mdhd[20:24])
+	} else if len(mdhd) >= 16 {
+		st.Timescale = binary.BigEndian.Uint32(mdhd[12:16])
+	}
+	stbl := find(find(mdia, "minf"), "stbl")
+	if stsd := find(stbl, "stsd"); len(stsd) >= 8 {
+		if entries := children(stsd[8:]); len(entries) > 0 {
+			st.Entry = stsd[8:][entries[0].off:entries[0].end]
+			body := stsd[8:][entries[0].body:entries[0].end]
+			if esds := find(entryChildren(body), "esds"); esds != nil {
+				_, _, st.Config, _ = decoderConfig(esds)
+			}
+		}
+	}
+
+	sizes, err := parseStsz(find(stbl, "stsz"))
+	if err != nil {
+		return nil, err
+	}
+	offsets, err := parseChunkOffsets(stbl)
+	if err != nil {
+		return nil, err
+	}
+	st.Samples = make([]Sample, len(sizes))
+
+	// stsc maps runs of chunks to a samples-per-chunk count; each run lasts
+	// until the next entry's first chunk.
+	stsc := find(stbl, "stsc")
+	entries, ok := table(stsc, 12)
+	if !ok {
+		return nil, fmt.Errorf("bad stsc box")
+	}
+	sample := 0
+	for i := 0; i < len(entries); i++ {
+		first := int(binary.BigEndian.Uint32(entries[i][0:4]))
+		perChunk := int(binary.BigEndian.Uint32(entries[i][4:8]))
+		last := len(offsets)
+		if i+1 < len(entries) {
+			last = int(binary.BigEndian.Uint32(entries[i+1][0:4])) - 1
+		}
+		if first < 1 || last > len(offsets) {
+			return nil, fmt.Errorf("stsc entry %d names chunks outside stco", i)
+		}
+		for chunk := first; chunk <= last; chunk++ {
+			off := offsets[chunk-1]
+			for range perChunk {
+				if sample >= len(sizes) {
+					return nil, fmt.Errorf("stsc describes more samples than stsz")
+				}
+				st.Samples[sample].Offset = off
+				st.Samples[sample].Size = sizes[sample]
+				off += int64(sizes[sample])
+				sample++
+			}
+		}
+	}
+	if sample != len(sizes) {
+		return nil, fmt.Errorf("stsc describes %d of %d samples", sample, len(sizes))
+	}
+
+	runs, ok := table(find(stbl, "stts"), 8)
+	if !ok {
+		return nil, fmt.Errorf("bad stts box")
+	}
+	sample = 0
+	for _, run := range runs {
+		count := int(binary.BigEndian.Uint32(run[0:4]))
+		delta := binary.BigEndian.Uint32(run[4:8])
+		for ; count > 0 && sample < len(st.Samples); count-- {
+			st.Samples[sample].Duration = delta
+			sample++
+		}
+	}
+	return st, nil
+}
+
+// parseEdits reads the first edit of trak's edit list that plays media,
+// converting its duration from the movie's timescale to the track's.
+func (st *SampleTable) parseEdits(trak []byte, movieScale uint32) {
+	elst := find(find(trak, "edts"), "elst")
+	if len(elst) < 8 {
+		return
+	}
+	entrySize := 12
+	if elst[0] == 1 {
+		entrySize = 20
+	}
+	entries, ok := table(elst, entrySize)
+	if !ok {
+		return
+	}
+	for _, e := range entries {
+		var duration uint64
+		var mediaTime int64
+		if entrySize == 20 {
+			duration = binary.BigEndian.Uint64(e[0:8])
+			mediaTime = int64(binary.BigEndian.Uint64(e[8:16]))
+		} else {
+			duration = uint64(binary.BigEndian.Uint32(e[0:4]))
+			mediaTime = int64(int32(binary.BigEndian.Uint32(e[4:8])))
+		}
+		if mediaTime < 0 {
+			continue // an empty edit delays the track; nothing to trim
+		}
+		st.Skip = uint64(mediaTime)
+		if movieScale > 0 {
+			st.Length = duration * uint64(st.Timescale) / uint64(movieScale)
+		}
+		return
+	}
+}
+
+// movieTimescale reads the timescale of moov's mvhd box.
+func movieTimescale(moov []byte) uint32 {
+	mvhd := find(moov, "mvhd")
+	switch {
+	case len(mvhd) >= 24 && mvhd[0] == 1:
+		return binary.BigEndian.Uint32(mvhd[20:24])
+	case len(mvhd) >= 16:
+		return binary.BigEndian.Uint32(mvhd[12:16])
+	}
+	return 0
+}
+
+// table splits a full box holding an entry count and fixed-size entries.
+func table(b []byte, entrySize int) ([][]byte, bool) {
+	if len(b) < 8 {
+		return nil, false
+	}
+	n := int(binary.BigEndian.Uint32(b[4:8]))
+	if n > maxSamples || 8+n*entrySize > len(b) {
+		return nil, false
+	}
+	entries := make([][]byte, n)
+	for i := range entries {
+		entries[i] = b[8+i*entrySize : 8+(i+1)*entrySize]
+	}
+	return entries, true
+}
+
+func parseStsz(b []byte) ([]uint32, error) {
+	if len(b) < 12 {
+		return nil, fmt.Errorf("missing stsz box")
+	}
+	fixed := binary.BigEndian.Uint32(b[4:8])
+	n := int(binary.BigEndian.Uint32(b[8:12]))
+	if n > maxSamples {
+		return nil, fmt.Errorf("stsz lists %d samples", n)
+	}
+	sizes := make([]uint32, n)
+	if fixed != 0 {
+		for i := range sizes {
+			sizes[i] = fixed
+		}
+		return sizes, nil
+	}
+	if 12+4*n > len(b) {
+		return nil, fmt.Errorf("bad stsz box")
+	}
+	for i := range sizes {
+		sizes[i] = binary.BigEndian.Uint32(b[12+4*i:])
+	}
+	return sizes, nil
+}
+
+func parseChunkOffsets(stbl []byte) ([]int64, error) {
+	if stco := find(stbl, "stco"); stco != nil {
+		entries, ok := table(stco, 4)
+		if !ok {
+			return nil, fmt.Errorf("bad stco box")
+		}
+		offsets := make([]int64, len(entries))
+		for i, e := range entries {
+			offsets[i] = int64(binary.BigEndian.Uint32(e))
+		}
+		return offsets, nil
+	}
+	entries, ok := table(find(stbl, "co64"), 8)
+	if !ok {
+		return nil, fmt.Errorf("missing stco or co64 box")
+	}
+	offsets := make([]int64, len(entries))
+	for i, e := range entries {
+		offsets[i] = int64(binary.BigEndian.Uint64(e))
+	}
+	return offsets, nil
+}
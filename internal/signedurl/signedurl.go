@@ -0,0 +1,165 @@
+// Package signedurl issues and checks expiring, HMAC-signed URLs, so
+// media can be linked from pages the site renders without being hotlinked
+// or scraped.
+//
+// A signed URL carries its expiry, the ID of the key that signed it and
+// the signature in its query string, plus bind=1 when it is only valid
+// together with one session ID (sent by the browser as a cookie). Several
+// keys can be accepted at once: the first signs, the rest only verify, so
+// a key is rotated by putting its successor in front and dropping it once
+// the URLs it signed have expired.
+package signedurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query parameters of a signed URL.
+const (
+	ParamExpires   = "exp"
+	ParamKey       = "kid"
+	ParamBind      = "bind"
+	ParamSignature = "sig"
+)
+
+// MinSecretLen is the shortest accepted secret, in bytes.
+const MinSecretLen = 32
+
+// Reasons Verify rejects a URL. They double as metric labels via Reason.
+var (
+	ErrUnsigned     = errors.New("not signed")
+	ErrExpired      = errors.New("expired")
+	ErrUnknownKey   = errors.New("unknown key")
+	ErrBadSignature = errors.New("bad signature")
+)
+
+// Key is one signing secret and the ID URLs name it by.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// ParseKeys parses "id:secret" pairs, in order of preference.
+func ParseKeys(pairs []string) ([]Key, error) {
+	keys := make([]Key, 0, len(pairs))
+	seen := make(map[string]bool)
+	for i, pair := range pairs {
+		id, secret, ok := strings.Cut(pair, ":")
+		switch {
+		case !ok || id == "" || strings.ContainsAny(id, "&=#?/ "):
+			return nil, fmt.Errorf("key %d: want id:secret with a plain id", i)
+		case len(secret) < MinSecretLen:
+			return nil, fmt.Errorf("key %q: secret must be at least %d bytes", id, MinSecretLen)
+		case seen[id]:
+			return nil, fmt.Errorf("key %q: listed more than once", id)
+		}
+		seen[id] = true
+		keys = append(keys, Key{ID: id, Secret: []byte(secret)})
+	}
+	return keys, nil
+}
+
+// Signer signs with the first of its keys and verifies with any of them.
+type Signer struct {
+	keys []Key
+}
+
+// New returns a signer for keys, which must not be empty.
+func New(keys []Key) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	return &Signer{keys: keys}, nil
+}
+
+// Sign returns the query string that makes path, unescaped as the server
+// sees it, valid until expires. A non-empty session binds the URL to that
+// session ID.
+func (s *Signer) Sign(path string, expires time.Time, session string) string {
+	key := s.keys[0]
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	q := url.Values{}
+	q.Set(ParamExpires, exp)
+	q.Set(ParamKey, key.ID)
+	if session != "" {
+		q.Set(ParamBind, "1")
+	}
+	q.Set(ParamSignature, mac(key.Secret, path, exp, session))
+	return q.Encode()
+}
+
+// Verify checks the signature in query for path at now, accepting URLs
+// that expired less than grace ago. session is the requester's session ID,
+// used only when the URL is bound to one.
+func (s *Signer) Verify(path string, query url.Values, session string, now time.Time, grace time.Duration) error {
+	exp, id, sig := query.Get(ParamExpires), query.Get(ParamKey), query.Get(ParamSignature)
+	if exp == "" || id == "" || sig == "" {
+		return ErrUnsigned
+	}
+	var key *Key
+	for i := range s.keys {
+		if s.keys[i].ID == id {
+			key = &s.keys[i]
+			break
+		}
+	}
+	if key == nil {
+		return ErrUnknownKey
+	}
+	if query.Get(ParamBind) != "1" {
+		session = ""
+	} else if session == "" {
+		return ErrBadSignature
+	}
+	if !hmac.Equal([]byte(sig), []byte(mac(key.Secret, path, exp, session))) {
+		return ErrBadSignature
+	}
+	// Checked after the signature so a forged expiry is reported as such.
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	if now.After(time.Unix(unix, 0).Add(grace)) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// Expires returns the expiry a signed URL's query names.
+func Expires(query url.Values) (time.Time, bool) {
+	unix, err := strconv.ParseInt(query.Get(ParamExpires), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(unix, 0), true
+}
+
+// Reason returns a short label for an error from Verify.
+func Reason(err error) string {
+	switch {
+	case errors.Is(err, ErrUnsigned):
+		return "unsigned"
+	case errors.Is(err, ErrExpired):
+		return "expired"
+	case errors.Is(err, ErrUnknownKey):
+		return "unknown_key"
+	default:
+		return "bad_signature"
+	}
+}
+
+func mac(secret []byte, path, exp, session string) string {
+	h := hmac.New(sha256.New, secret)
+	// Catalog paths, expiries and session IDs never contain newlines, so
+	// no two signed inputs share an encoding.
+	fmt.Fprintf(h, "%s\n%s\n%s", path, exp, session)
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,92 @@
+package signedurl
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	oldKeys, err := ParseKeys([]string{"k1:" + strings.Repeat("a", 32)})
+	if err != nil {
+		t.Fatalf("parse keys: %v", err)
+	}
+	rotated, err := ParseKeys([]string{"k2:" + strings.Repeat("b", 32), "k1:" + strings.Repeat("a", 32)})
+	if err != nil {
+		t.Fatalf("parse keys: %v", err)
+	}
+	old, _ := New(oldKeys)
+	current, _ := New(rotated)
+
+	now := time.Unix(1_800_000_000, 0)
+	path := "/music/WARM_OPEN-FirstLight.m4a"
+	parse := func(query string) url.Values {
+		q, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatalf("parse %q: %v", query, err)
+		}
+		return q
+	}
+	tamper := func(q url.Values, key, value string) url.Values {
+		c := url.Values{}
+		for k, v := range q {
+			c[k] = v
+		}
+		c.Set(key, value)
+		return c
+	}
+
+	valid := parse(current.Sign(path, now.Add(time.Hour), ""))
+	bound := parse(current.Sign(path, now.Add(time.Hour), "session-a"))
+	tests := []struct {
+		name    string
+		signer  *Signer
+		path    string
+		query   url.Values
+		session string
+		at      time.Time
+		want    error
+	}{
+		{name: "valid", signer: current, path: path, query: valid, at: now},
+		{name: "signed by previous key", signer: current, path: path, query: parse(old.Sign(path, now.Add(time.Hour), "")), at: now},
+		{name: "new key before rollout", signer: old, path: path, query: valid, at: now, want: ErrUnknownKey},
+		{name: "within grace", signer: current, path: path, query: valid, at: now.Add(time.Hour + time.Minute)},
+		{name: "past grace", signer: current, path: path, query: valid, at: now.Add(time.Hour + 10*time.Minute), want: ErrExpired},
+		{name: "other path", signer: current, path: "/music/WARM_Sonare.m4a", query: valid, at: now, want: ErrBadSignature},
+		{name: "extended expiry", signer: current, path: path, query: tamper(valid, ParamExpires, "1900000000"), at: now, want: ErrBadSignature},
+		{name: "unsigned", signer: current, path: path, query: url.Values{}, at: now, want: ErrUnsigned},
+		{name: "bound, same session", signer: current, path: path, query: bound, session: "session-a", at: now},
+		{name: "bound, other session", signer: current, path: path, query: bound, session: "session-b", at: now, want: ErrBadSignature},
+		{name: "bound, no session", signer: current, path: path, query: bound, at: now, want: ErrBadSignature},
+		{name: "binding stripped", signer: current, path: path, query: tamper(bound, ParamBind, "0"), session: "session-b", at: now, want: ErrBadSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := tt.signer.Verify(tt.path, tt.query, tt.session, tt.at, 5*time.Minute)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("verify mismatch: got=%v want=%v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseKeysRejectsWeakOrDuplicateKeys(t *testing.T) {
+	t.Parallel()
+
+	secret := strings.Repeat("s", MinSecretLen)
+	for _, pairs := range [][]string{
+		{"short:secret"},
+		{"no-separator"},
+		{":" + secret},
+		{"a:" + secret, "a:" + secret},
+	} {
+		if _, err := ParseKeys(pairs); err == nil {
+			t.Errorf("expected error for %q", pairs)
+		}
+	}
+}
@@ -1,17 +1,40 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+	"go.opentelemetry.io/otel/trace"
+	"sonare.media/internal/logging"
+	"sonare.media/internal/metrics"
+	"sonare.media/internal/tracing"
 )
 
 var DB *sql.DB
 
+var tracer = tracing.Tracer("sonare.media/internal/store")
+
+// startQuerySpan opens a client span for one SQLite statement.
+func startQuerySpan(ctx context.Context, name, query string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "sqlite "+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemNameSQLite,
+			semconv.DBOperationName(name),
+			semconv.DBQueryText(query),
+		),
+	)
+}
+
 type Lead struct {
 	ID         int       `json:"id"`
 	Name       string    `json:"name"`
@@ -25,17 +48,61 @@ type Lead struct {
 	CreatedAt  time.Time `json:"created_at"`
 }
 
+// LogValue redacts the contact fields and drops the free-text message so a
+// lead can be logged without leaking PII.
+func (l Lead) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("name", logging.RedactName(l.Name)),
+		slog.String("business", l.Business),
+		slog.String("email", logging.RedactEmail(l.Email)),
+		slog.String("system", l.Playback),
+		slog.String("palette", l.Palette),
+		slog.Int("hours_est", l.HoursEst),
+		slog.Int("store_count", l.StoreCount),
+	)
+}
+
 type Analytics struct {
-	ID        int       `json:"id"`
-	IP        string    `json:"ip"`
-	UserAgent string    `json:"user_agent"`
-	Path      string    `json:"path"`
-	Method    string    `json:"method"`
-	Country   string    `json:"country"`
-	City      string    `json:"city"`
-	CreatedAt time.Time `json:"created_at"`
+	ID         int       `json:"id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Path       string    `json:"path"`
+	Method     string    `json:"method"`
+	Country    string    `json:"country"`
+	City       string    `json:"city"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMS float64   `json:"duration_ms"`
+	TLSVersion string    `json:"tls_version"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
+// CSPReport is a Content-Security-Policy violation sent by browsers. Count
+// is how many times it was reported and LastSeenAt when it last was; the
+// other fields come from the first report.
+type CSPReport struct {
+	ID                 int       `json:"id"`
+	DocumentURI        string    `json:"document_uri"`
+	Referrer           string    `json:"referrer"`
+	EffectiveDirective string    `json:"effective_directive"`
+	BlockedURI         string    `json:"blocked_uri"`
+	SourceFile         string    `json:"source_file"`
+	Line               int       `json:"line"`
+	Column             int       `json:"column"`
+	Disposition        string    `json:"disposition"`
+	Sample             string    `json:"sample"`
+	StatusCode         int       `json:"status_code"`
+	UserAgent          string    `json:"user_agent"`
+	Count              int       `json:"count"`
+	LastSeenAt         time.Time `json:"last_seen_at"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+// MaxCSPViolations bounds the csp_reports table. Rows are distinct
+// violations, so this is how many different problems are kept; the least
+// recently seen make room for new ones.
+const MaxCSPViolations = 1000
+
 func InitDB(filepath string) error {
 	var err error
 	DB, err = sql.Open("sqlite3", filepath)
@@ -52,7 +119,10 @@ func InitDB(filepath string) error {
 		return fmt.Errorf("failed to enable WAL mode: %w", err)
 	}
 
-	return createTables()
+	if err := createTables(); err != nil {
+		return err
+	}
+	return migrate()
 }
 
 func createTables() error {
@@ -89,18 +159,111 @@ func createTables() error {
 	return nil
 }
 
-func SaveLead(l Lead) error {
-	stmt, err := DB.Prepare("INSERT INTO leads(name, business, playback, email, message, palette, hours_est, store_count) VALUES(?, ?, ?, ?, ?, ?, ?, ?)")
+// migrations evolve the schema created by createTables. Entry i upgrades
+// the database from user_version i to i+1; append, never edit.
+var migrations = []string{
+	`ALTER TABLE analytics ADD COLUMN status INTEGER;
+	 ALTER TABLE analytics ADD COLUMN bytes INTEGER;
+	 ALTER TABLE analytics ADD COLUMN duration_ms REAL;
+	 ALTER TABLE analytics ADD COLUMN tls_version TEXT;`,
+	`CREATE TABLE IF NOT EXISTS health_probe (
+		id INTEGER PRIMARY KEY,
+		checked_at DATETIME
+	);`,
+	`CREATE TABLE IF NOT EXISTS csp_reports (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		document_uri TEXT,
+		referrer TEXT,
+		effective_directive TEXT,
+		blocked_uri TEXT,
+		source_file TEXT,
+		line INTEGER,
+		col INTEGER,
+		disposition TEXT,
+		sample TEXT,
+		status_code INTEGER,
+		user_agent TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS csp_reports_created_at ON csp_reports(created_at);`,
+	`ALTER TABLE csp_reports ADD COLUMN count INTEGER NOT NULL DEFAULT 1;
+	 ALTER TABLE csp_reports ADD COLUMN last_seen_at DATETIME;
+	 UPDATE csp_reports SET
+		count = (SELECT COUNT(*) FROM csp_reports d WHERE d.effective_directive IS csp_reports.effective_directive AND d.blocked_uri IS csp_reports.blocked_uri AND d.document_uri IS csp_reports.document_uri),
+		last_seen_at = (SELECT MAX(d.created_at) FROM csp_reports d WHERE d.effective_directive IS csp_reports.effective_directive AND d.blocked_uri IS csp_reports.blocked_uri AND d.document_uri IS csp_reports.document_uri);
+	 DELETE FROM csp_reports WHERE id NOT IN (SELECT MIN(id) FROM csp_reports GROUP BY effective_directive, blocked_uri, document_uri);
+	 CREATE UNIQUE INDEX csp_reports_violation ON csp_reports(effective_directive, blocked_uri, document_uri);
+	 CREATE INDEX csp_reports_last_seen_at ON csp_reports(last_seen_at);`,
+}
+
+// SchemaVersion reports the migration level recorded in the database.
+func SchemaVersion() (int, error) {
+	var version int
+	err := DB.QueryRow("PRAGMA user_version;").Scan(&version)
+	return version, err
+}
+
+// LatestSchemaVersion is the version a fully migrated database reports.
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+// CheckWritable performs a real write so readiness probes notice a
+// read-only filesystem or a locked database, which a ping would not.
+func CheckWritable(ctx context.Context) error {
+	_, err := DB.ExecContext(ctx, "INSERT OR REPLACE INTO health_probe(id, checked_at) VALUES(1, CURRENT_TIMESTAMP)")
+	return err
+}
+
+func migrate() error {
+	version, err := SchemaVersion()
+	if err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := DB.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d;", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func SaveLead(ctx context.Context, l Lead) (err error) {
+	defer metrics.ObserveQuery("save_lead", time.Now())
+
+	const query = "INSERT INTO leads(name, business, playback, email, message, palette, hours_est, store_count) VALUES(?, ?, ?, ?, ?, ?, ?, ?)"
+	ctx, span := startQuerySpan(ctx, "save_lead", query)
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	stmt, err := DB.PrepareContext(ctx, query)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(l.Name, l.Business, l.Playback, l.Email, l.Message, l.Palette, l.HoursEst, l.StoreCount)
+	_, err = stmt.ExecContext(ctx, l.Name, l.Business, l.Playback, l.Email, l.Message, l.Palette, l.HoursEst, l.StoreCount)
 	return err
 }
 
 func GetLeads() ([]Lead, error) {
+	defer metrics.ObserveQuery("get_leads", time.Now())
+
 	rows, err := DB.Query("SELECT id, name, business, playback, email, message, palette, hours_est, store_count, created_at FROM leads ORDER BY created_at DESC")
 	if err != nil {
 		return nil, err
@@ -118,24 +281,36 @@ func GetLeads() ([]Lead, error) {
 	return leads, nil
 }
 
-func SaveAnalytics(a Analytics) error {
+func SaveAnalytics(ctx context.Context, a Analytics) (err error) {
 	// Enrich with GeoIP
-	country, city := GetGeoLocation(a.IP)
+	country, city := GetGeoLocation(ctx, a.IP)
 	a.Country = country
 	a.City = city
 
-	stmt, err := DB.Prepare("INSERT INTO analytics(ip, user_agent, path, method, country, city) VALUES(?, ?, ?, ?, ?, ?)")
+	defer metrics.ObserveQuery("save_analytics", time.Now())
+
+	const query = "INSERT INTO analytics(ip, user_agent, path, method, country, city, status, bytes, duration_ms, tls_version) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	ctx, span := startQuerySpan(ctx, "save_analytics", query)
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	stmt, err := DB.PrepareContext(ctx, query)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(a.IP, a.UserAgent, a.Path, a.Method, a.Country, a.City)
+	_, err = stmt.ExecContext(ctx, a.IP, a.UserAgent, a.Path, a.Method, a.Country, a.City, a.Status, a.Bytes, a.DurationMS, a.TLSVersion)
 	return err
 }
 
 func GetAnalytics() ([]Analytics, error) {
-	rows, err := DB.Query("SELECT id, ip, user_agent, path, method, country, city, created_at FROM analytics ORDER BY created_at DESC LIMIT 100")
+	defer metrics.ObserveQuery("get_analytics", time.Now())
+
+	// Rows recorded before migration 1 have no response fields.
+	rows, err := DB.Query("SELECT id, ip, user_agent, path, method, country, city, COALESCE(status, 0), COALESCE(bytes, 0), COALESCE(duration_ms, 0), COALESCE(tls_version, ''), created_at FROM analytics ORDER BY created_at DESC LIMIT 100")
 	if err != nil {
 		return nil, err
 	}
@@ -144,7 +319,7 @@ func GetAnalytics() ([]Analytics, error) {
 	var analytics []Analytics
 	for rows.Next() {
 		var a Analytics
-		if err := rows.Scan(&a.ID, &a.IP, &a.UserAgent, &a.Path, &a.Method, &a.Country, &a.City, &a.CreatedAt); err != nil {
+		if err := rows.Scan(&a.ID, &a.IP, &a.UserAgent, &a.Path, &a.Method, &a.Country, &a.City, &a.Status, &a.Bytes, &a.DurationMS, &a.TLSVersion, &a.CreatedAt); err != nil {
 			return nil, err
 		}
 		analytics = append(analytics, a)
@@ -152,6 +327,38 @@ func GetAnalytics() ([]Analytics, error) {
 	return analytics, nil
 }
 
+// SaveCSPReports records a request's reports in one transaction. A
+// violation already on file is counted rather than stored again, and the
+// table is then trimmed to MaxCSPViolations.
+func SaveCSPReports(ctx context.Context, reports []CSPReport) (err error) {
+	defer metrics.ObserveQuery("save_csp_reports", time.Now())
+
+	const query = `INSERT INTO csp_reports(document_uri, referrer, effective_directive, blocked_uri, source_file, line, col, disposition, sample, status_code, user_agent, last_seen_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(effective_directive, blocked_uri, document_uri) DO UPDATE SET count = count + 1, last_seen_at = CURRENT_TIMESTAMP`
+	const trim = "DELETE FROM csp_reports WHERE id IN (SELECT id FROM csp_reports ORDER BY last_seen_at DESC, id DESC LIMIT -1 OFFSET ?)"
+	ctx, span := startQuerySpan(ctx, "save_csp_reports", query)
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, c := range reports {
+		if _, err := tx.ExecContext(ctx, query, c.DocumentURI, c.Referrer, c.EffectiveDirective, c.BlockedURI, c.SourceFile, c.Line, c.Column, c.Disposition, c.Sample, c.StatusCode, c.UserAgent); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, trim, MaxCSPViolations); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 // Simple GeoIP struct for JSON unmarshalling
 type GeoIPResponse struct {
 	Country string `json:"country"`
@@ -159,31 +366,95 @@ type GeoIPResponse struct {
 	Status  string `json:"status"`
 }
 
-func GetGeoLocation(ip string) (string, string) {
+type geoCacheEntry struct {
+	country string
+	city    string
+	expires time.Time
+}
+
+const (
+	geoCacheTTL     = 24 * time.Hour
+	geoCacheMaxSize = 4096
+)
+
+var (
+	geoCacheMu sync.Mutex
+	geoCache   = map[string]geoCacheEntry{}
+)
+
+func GetGeoLocation(ctx context.Context, ip string) (string, string) {
 	// Skip localhost/private IPs for external lookup speedup
 	if ip == "127.0.0.1" || ip == "::1" {
 		return "Localhost", "Localhost"
 	}
 
+	geoCacheMu.Lock()
+	entry, ok := geoCache[ip]
+	geoCacheMu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		metrics.GeoIPCache.WithLabelValues("hit").Inc()
+		return entry.country, entry.city
+	}
+	metrics.GeoIPCache.WithLabelValues("miss").Inc()
+
+	country, city, ok := lookupGeoLocation(ctx, ip)
+	if !ok {
+		return "Unknown", "Unknown"
+	}
+
+	geoCacheMu.Lock()
+	if len(geoCache) >= geoCacheMaxSize {
+		// Crude bound: start over rather than track recency.
+		geoCache = map[string]geoCacheEntry{}
+	}
+	geoCache[ip] = geoCacheEntry{country: country, city: city, expires: time.Now().Add(geoCacheTTL)}
+	geoCacheMu.Unlock()
+
+	return country, city
+}
+
+func lookupGeoLocation(ctx context.Context, ip string) (country, city string, ok bool) {
+	start := time.Now()
+	result := "error"
+
+	ctx, span := tracer.Start(ctx, "geoip lookup",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.ServerAddress("ip-api.com")),
+	)
+	defer func() {
+		metrics.GeoIPDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+		span.SetAttributes(attribute.String("geoip.result", result))
+		span.End()
+	}()
+
 	url := fmt.Sprintf("http://ip-api.com/json/%s", ip)
 	client := http.Client{
 		Timeout: 2 * time.Second,
 	}
 
-	resp, err := client.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return "Unknown", "Unknown"
+		tracing.RecordError(span, err)
+		return "", "", false
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return "", "", false
 	}
 	defer resp.Body.Close()
 
 	var geo GeoIPResponse
 	if err := json.NewDecoder(resp.Body).Decode(&geo); err != nil {
-		return "Unknown", "Unknown"
+		return "", "", false
 	}
 
 	if geo.Status == "fail" {
-		return "Unknown", "Unknown"
+		result = "fail"
+		return "", "", false
 	}
 
-	return geo.Country, geo.City
+	result = "ok"
+	return geo.Country, geo.City, true
 }
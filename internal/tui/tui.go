@@ -123,10 +123,13 @@ IP ADDRESS: %s
 LOCATION:   %s, %s
 PATH:       %s
 METHOD:     %s
+STATUS:     %d (%d bytes in %.1fms)
+TLS:        %s
 USER AGENT: %s
 TIME:       %s
 `,
-				a.ID, a.IP, a.City, a.Country, a.Path, a.Method, a.UserAgent,
+				a.ID, a.IP, a.City, a.Country, a.Path, a.Method,
+				a.Status, a.Bytes, a.DurationMS, a.TLSVersion, a.UserAgent,
 				formatTimestamp(a.CreatedAt, "Mon Jan 2 15:04:05 2006"))
 		}
 	}
@@ -208,6 +211,7 @@ func (m *model) refreshTable() {
 			{Title: "Loc", Width: 15},
 			{Title: "Path", Width: 15},
 			{Title: "Method", Width: 6},
+			{Title: "Status", Width: 6},
 		}
 
 		for _, a := range m.analytics {
@@ -218,6 +222,7 @@ func (m *model) refreshTable() {
 				truncate(loc, 15),
 				a.Path,
 				a.Method,
+				fmt.Sprintf("%d", a.Status),
 			})
 		}
 	}
@@ -0,0 +1,803 @@
+package waveform
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// This file decodes AAC-LC (ISO/IEC 14496-3 subpart 4) raw data blocks into
+// spectral coefficients: the element syntax, Huffman-coded scalefactors and
+// spectra, and the stereo and noise tools. filterbank.go turns the spectra
+// into PCM. Main, SSR and LTP profile tools, coupling channels and
+// 960-sample frames are not supported; none occur in AAC-LC files.
+
+// AAC syntactic element IDs (ISO/IEC 14496-3, Table 4.85).
+const (
+	idSCE = 0 // single channel element
+	idCPE = 1 // channel pair element
+	idCCE = 2 // coupling channel element
+	idLFE = 3 // low frequency effects element
+	idDSE = 4 // data stream element
+	idPCE = 5 // program config element
+	idFIL = 6 // fill element
+	idEND = 7
+)
+
+// Window sequences (Table 4.87).
+const (
+	onlyLongSequence   = 0
+	longStartSequence  = 1
+	eightShortSequence = 2
+	longStopSequence   = 3
+)
+
+// Section codebooks beyond the spectral ones (Table 4.93).
+const (
+	zeroHCB       = 0
+	escHCB        = 11
+	noiseHCB      = 13
+	intensityHCB2 = 14
+	intensityHCB  = 15
+)
+
+const (
+	// frameLength is the number of samples per channel in a frame.
+	frameLength = 1024
+	// shortLength is the number of lines of one short window.
+	shortLength = frameLength / 8
+	// maxSFB bounds the scalefactor bands of any window length.
+	maxSFB = 64
+	// aotLC is the audio object type of AAC-LC.
+	aotLC = 2
+)
+
+var (
+	errUnsupported = errors.New("unsupported AAC feature")
+	errBitstream   = errors.New("invalid AAC bitstream")
+)
+
+// decoder turns raw AAC-LC frames into PCM, one frame at a time.
+type decoder struct {
+	rate      int
+	rateIndex int
+	// channels are created in the order elements appear in a frame.
+	channels []*channel
+	// noise is the state of the generator for perceptual noise
+	// substitution, the same linear congruential one the reference
+	// decoders use.
+	noise uint32
+	bank  *filterbank
+}
+
+// channel is the state of one decoded channel.
+type channel struct {
+	ics ics
+	// cb and sf hold each band's codebook and scalefactor (or intensity
+	// position or noise energy), by window group.
+	cb [8][maxSFB]int
+	sf [8][maxSFB]int
+	// tns is the channel's temporal noise shaping, per window.
+	tns [8]tnsWindow
+	// spec holds the frame's dequantised spectrum, short windows one
+	// after another.
+	spec [frameLength]float64
+	// quant holds the quantised values while a frame is parsed.
+	quant [frameLength]int
+	// pcm is the frame's output; overlap is carried into the next frame.
+	pcm       [frameLength]float64
+	overlap   [frameLength]float64
+	prevShape int
+}
+
+// ics is the ics_info of a channel's frame.
+type ics struct {
+	sequence int
+	shape    int
+	maxSFB   int
+	groups   int
+	groupLen [8]int
+	windows  int
+	// offsets are the scalefactor band boundaries of the window length.
+	offsets     []uint16
+	tnsMaxBands int
+}
+
+type tnsWindow struct {
+	filters int
+	length  [4]int
+	order   [4]int
+	down    [4]bool
+	lpc     [4][21]float64
+}
+
+// newDecoder prepares a decoder for the stream an AudioSpecificConfig
+// describes.
+func newDecoder(config []byte) (*decoder, error) {
+	r := bitReader{b: config}
+	aot := r.read(5)
+	if aot == 31 {
+		aot = 32 + r.read(6)
+	}
+	rateIndex := r.read(4)
+	if rateIndex == 0xf {
+		r.read(24) // an explicit rate has no band tables
+		rateIndex = len(sampleRates)
+	}
+	r.read(4) // channelConfiguration; elements are taken as they come
+	frameLengthFlag := r.read(1)
+	if r.err {
+		return nil, fmt.Errorf("%w: short AudioSpecificConfig", errBitstream)
+	}
+	if aot != aotLC {
+		return nil, fmt.Errorf("%w: audio object type %d", errUnsupported, aot)
+	}
+	if rateIndex >= len(sampleRates) {
+		return nil, fmt.Errorf("%w: sampling frequency index %d", errUnsupported, rateIndex)
+	}
+	if frameLengthFlag != 0 {
+		return nil, fmt.Errorf("%w: 960-sample frames", errUnsupported)
+	}
+	return &decoder{
+		rate:      sampleRates[rateIndex],
+		rateIndex: rateIndex,
+		noise:     0x1f2e3d4c,
+		bank:      newFilterbank(),
+	}, nil
+}
+
+// sampleRates indexes the sampling frequencies of ISO/IEC 14496-3.
+var sampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// decode decodes one raw_data_block and returns its channels, whose pcm
+// holds the frame's samples scaled to [-1, 1].
+func (d *decoder) decode(frame []byte) ([]*channel, error) {
+	r := bitReader{b: frame}
+	n := 0
+	next := func() *channel {
+		if n == len(d.channels) {
+			d.channels = append(d.channels, &channel{})
+		}
+		n++
+		return d.channels[n-1]
+	}
+	for {
+		id := r.read(3)
+		if r.err {
+			return nil, fmt.Errorf("%w: frame ends without an END element", errBitstream)
+		}
+		var err error
+		switch id {
+		case idSCE, idLFE:
+			r.read(4) // element_instance_tag
+			err = d.channelStream(&r, next(), false)
+		case idCPE:
+			r.read(4) // element_instance_tag
+			err = d.channelPair(&r, next(), next())
+		case idDSE:
+			r.read(4) // element_instance_tag
+			align := r.read(1)
+			count := r.read(8)
+			if count == 255 {
+				count += r.read(8)
+			}
+			if align == 1 {
+				r.align()
+			}
+			r.skip(8 * count)
+		case idFIL:
+			count := r.read(4)
+			if count == 15 {
+				count += r.read(8) - 1
+			}
+			r.skip(8 * count)
+		case idCCE, idPCE:
+			return nil, fmt.Errorf("%w: element %d", errUnsupported, id)
+		case idEND:
+			if n == 0 {
+				return nil, fmt.Errorf("%w: frame has no audio elements", errBitstream)
+			}
+			for _, ch := range d.channels[:n] {
+				ch.applyTNS()
+				d.bank.synthesize(ch)
+			}
+			return d.channels[:n], nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if r.err {
+			return nil, fmt.Errorf("%w: truncated element %d", errBitstream, id)
+		}
+	}
+}
+
+// channelPair decodes a CPE, then undoes mid/side and intensity stereo.
+func (d *decoder) channelPair(r *bitReader, left, right *channel) error {
+	common := r.read(1) == 1
+	var ms [8][maxSFB]bool
+	msPresent := 0
+	if common {
+		if err := d.readICSInfo(r, &left.ics); err != nil {
+			return err
+		}
+		right.ics = left.ics
+		msPresent = r.read(2)
+		for g := range left.ics.groups {
+			for sfb := range left.ics.maxSFB {
+				switch msPresent {
+				case 1:
+					ms[g][sfb] = r.read(1) == 1
+				case 2:
+					ms[g][sfb] = true
+				}
+			}
+		}
+		if msPresent == 3 {
+			return fmt.Errorf("%w: reserved ms_mask_present", errBitstream)
+		}
+	}
+	if err := d.channelStream(r, left, common); err != nil {
+		return err
+	}
+	if err := d.channelStream(r, right, common); err != nil {
+		return err
+	}
+	if !common {
+		return nil
+	}
+
+	info := &left.ics
+	for g, w := 0, 0; g < info.groups; w, g = w+info.groupLen[g], g+1 {
+		for sfb := range info.maxSFB {
+			lo, hi := int(info.offsets[sfb]), int(info.offsets[sfb+1])
+			lcb, rcb := left.cb[g][sfb], right.cb[g][sfb]
+			switch {
+			case rcb == intensityHCB || rcb == intensityHCB2:
+				scale := math.Pow(0.5, 0.25*float64(right.sf[g][sfb]))
+				if rcb == intensityHCB2 {
+					scale = -scale
+				}
+				if ms[g][sfb] {
+					scale = -scale
+				}
+				for win := w; win < w+info.groupLen[g]; win++ {
+					base := win * shortLength
+					for k := lo; k < hi; k++ {
+						right.spec[base+k] = left.spec[base+k] * scale
+					}
+				}
+			case ms[g][sfb] && lcb == noiseHCB && rcb == noiseHCB:
+				// Noise in both channels of a mid/side band is the same
+				// noise, scaled to the right channel's energy.
+				for win := w; win < w+info.groupLen[g]; win++ {
+					base := win * shortLength
+					l, r := left.spec[base+lo:base+hi], right.spec[base+lo:base+hi]
+					scale := math.Sqrt(energy(r) / energy(l))
+					for k := range l {
+						r[k] = l[k] * scale
+					}
+				}
+			case ms[g][sfb] && lcb < noiseHCB && rcb < noiseHCB:
+				for win := w; win < w+info.groupLen[g]; win++ {
+					base := win * shortLength
+					for k := base + lo; k < base+hi; k++ {
+						m, s := left.spec[k], right.spec[k]
+						left.spec[k], right.spec[k] = m+s, m-s
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func energy(x []float64) float64 {
+	var e float64
+	for _, v := range x {
+		e += v * v
+	}
+	return e
+}
+
+// channelStream decodes an individual_channel_stream into ch.spec.
+func (d *decoder) channelStream(r *bitReader, ch *channel, commonWindow bool) error {
+	globalGain := r.read(8)
+	if !commonWindow {
+		if err := d.readICSInfo(r, &ch.ics); err != nil {
+			return err
+		}
+	}
+	info := &ch.ics
+	if err := ch.readSections(r); err != nil {
+		return err
+	}
+	if err := ch.readScalefactors(r, globalGain); err != nil {
+		return err
+	}
+
+	clear(ch.quant[:])
+	type pulse struct{ pos, amp int }
+	var pulses []pulse
+	if r.read(1) == 1 { // pulse_data_present
+		if info.sequence == eightShortSequence {
+			return fmt.Errorf("%w: pulse data in short windows", errBitstream)
+		}
+		count := r.read(2) + 1
+		startSFB := r.read(6)
+		if startSFB >= len(info.offsets)-1 {
+			return fmt.Errorf("%w: pulse start band %d", errBitstream, startSFB)
+		}
+		pos := int(info.offsets[startSFB])
+		for range count {
+			pos += r.read(5)
+			if pos >= frameLength {
+				return fmt.Errorf("%w: pulse beyond the spectrum", errBitstream)
+			}
+			pulses = append(pulses, pulse{pos: pos, amp: r.read(4)})
+		}
+	}
+	ch.tns = [8]tnsWindow{}
+	if r.read(1) == 1 { // tns_data_present
+		ch.readTNS(r)
+	}
+	if r.read(1) == 1 { // gain_control_data_present
+		return fmt.Errorf("%w: gain control", errUnsupported)
+	}
+	if err := ch.readSpectrum(r); err != nil {
+		return err
+	}
+	for _, p := range pulses {
+		if ch.quant[p.pos] > 0 {
+			ch.quant[p.pos] += p.amp
+		} else {
+			ch.quant[p.pos] -= p.amp
+		}
+	}
+	d.dequantise(ch)
+	return nil
+}
+
+// readICSInfo parses ics_info into info.
+func (d *decoder) readICSInfo(r *bitReader, info *ics) error {
+	r.read(1) // ics_reserved_bit
+	info.sequence = r.read(2)
+	info.shape = r.read(1)
+	if info.sequence == eightShortSequence {
+		info.maxSFB = r.read(4)
+		grouping := r.read(7)
+		info.windows = 8
+		info.groups = 1
+		info.groupLen = [8]int{1}
+		for bit := 6; bit >= 0; bit-- {
+			if grouping>>bit&1 == 1 {
+				info.groupLen[info.groups-1]++
+			} else {
+				info.groups++
+				info.groupLen[info.groups-1] = 1
+			}
+		}
+		info.offsets = swbOffsetShort[d.rateIndex]
+		info.tnsMaxBands = tnsMaxBandsShort[d.rateIndex]
+	} else {
+		info.maxSFB = r.read(6)
+		info.windows = 1
+		info.groups = 1
+		info.groupLen = [8]int{1}
+		info.offsets = swbOffsetLong[d.rateIndex]
+		info.tnsMaxBands = tnsMaxBandsLong[d.rateIndex]
+		if r.read(1) == 1 {
+			return fmt.Errorf("%w: prediction", errUnsupported)
+		}
+	}
+	if info.maxSFB > len(info.offsets)-1 {
+		return fmt.Errorf("%w: max_sfb %d", errBitstream, info.maxSFB)
+	}
+	return nil
+}
+
+// readSections parses section_data into ch.cb.
+func (ch *channel) readSections(r *bitReader) error {
+	info := &ch.ics
+	bits, esc := 5, 31
+	if info.sequence == eightShortSequence {
+		bits, esc = 3, 7
+	}
+	for g := range info.groups {
+		for k := 0; k < info.maxSFB; {
+			cb := r.read(4)
+			if cb == 12 {
+				return fmt.Errorf("%w: reserved codebook", errBitstream)
+			}
+			n := 0
+			for {
+				incr := r.read(bits)
+				if r.err {
+					return fmt.Errorf("%w: truncated section data", errBitstream)
+				}
+				n += incr
+				if incr != esc {
+					break
+				}
+			}
+			if k+n > info.maxSFB {
+				return fmt.Errorf("%w: section past max_sfb", errBitstream)
+			}
+			for ; n > 0; n-- {
+				ch.cb[g][k] = cb
+				k++
+			}
+		}
+	}
+	return nil
+}
+
+// readScalefactors parses scale_factor_data. Each kind of band carries its
+// own running value: scalefactors start at global_gain, intensity
+// positions at 0 and noise energies at global_gain-90.
+func (ch *channel) readScalefactors(r *bitReader, globalGain int) error {
+	info := &ch.ics
+	sf, position, noise := globalGain, 0, globalGain-90
+	firstNoise := true
+	for g := range info.groups {
+		for sfb := range info.maxSFB {
+			switch ch.cb[g][sfb] {
+			case zeroHCB:
+				ch.sf[g][sfb] = 0
+			case intensityHCB, intensityHCB2:
+				position += scalefactorHuffman.decode(r) - 60
+				ch.sf[g][sfb] = position
+			case noiseHCB:
+				if firstNoise {
+					noise += r.read(9) - 256
+					firstNoise = false
+				} else {
+					noise += scalefactorHuffman.decode(r) - 60
+				}
+				ch.sf[g][sfb] = noise
+			default:
+				sf += scalefactorHuffman.decode(r) - 60
+				if !r.err && (sf < 0 || sf > 255) {
+					return fmt.Errorf("%w: scalefactor %d", errBitstream, sf)
+				}
+				ch.sf[g][sfb] = sf
+			}
+			if r.err {
+				return fmt.Errorf("%w: truncated scalefactors", errBitstream)
+			}
+		}
+	}
+	return nil
+}
+
+// readTNS parses tns_data and converts each filter's reflection
+// coefficients to direct-form LPC coefficients.
+func (ch *channel) readTNS(r *bitReader) {
+	short := ch.ics.sequence == eightShortSequence
+	filterBits, lengthBits, orderBits := 2, 6, 5
+	if short {
+		filterBits, lengthBits, orderBits = 1, 4, 3
+	}
+	for w := range ch.ics.windows {
+		t := &ch.tns[w]
+		t.filters = r.read(filterBits)
+		if t.filters == 0 {
+			continue
+		}
+		res := r.read(1) + 3
+		for f := range t.filters {
+			t.length[f] = r.read(lengthBits)
+			t.order[f] = r.read(orderBits)
+			if t.order[f] == 0 {
+				continue
+			}
+			t.down[f] = r.read(1) == 1
+			compress := r.read(1)
+			bits := res - compress
+			// Coefficients are quantised arcsines at res bits, sent in
+			// two's complement with compress fewer bits.
+			half := float64(int(1) << (res - 1))
+			pos, neg := (half-0.5)/(math.Pi/2), (half+0.5)/(math.Pi/2)
+			var refl [20]float64
+			for i := range t.order[f] {
+				c := r.read(bits)
+				if c >= 1<<(bits-1) {
+					c -= 1 << bits
+				}
+				if c >= 0 {
+					refl[i] = math.Sin(float64(c) / pos)
+				} else {
+					refl[i] = math.Sin(float64(c) / neg)
+				}
+			}
+			lpc := &t.lpc[f]
+			lpc[0] = 1
+			var tmp [21]float64
+			for m := 1; m <= t.order[f]; m++ {
+				for i := 1; i < m; i++ {
+					tmp[i] = lpc[i] + refl[m-1]*lpc[m-i]
+				}
+				copy(lpc[1:m], tmp[1:m])
+				lpc[m] = refl[m-1]
+			}
+		}
+	}
+}
+
+// readSpectrum parses spectral_data into ch.quant, deinterleaving grouped
+// short windows so that window w's lines start at w*shortLength.
+func (ch *channel) readSpectrum(r *bitReader) error {
+	info := &ch.ics
+	win := 0
+	for g := range info.groups {
+		for sfb := range info.maxSFB {
+			cb := ch.cb[g][sfb]
+			if cb == zeroHCB || cb >= noiseHCB {
+				continue
+			}
+			book := &spectralBooks[cb-1]
+			lo, hi := int(info.offsets[sfb]), int(info.offsets[sfb+1])
+			for w := win; w < win+info.groupLen[g]; w++ {
+				q := ch.quant[w*shortLength:]
+				for k := lo; k < hi; k += book.dim {
+					if err := book.read(r, q[k:k+book.dim]); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		win += info.groupLen[g]
+	}
+	return nil
+}
+
+// dequantise scales ch.quant into ch.spec: x = sign(q)·|q|^(4/3)·2^((sf-100)/4),
+// filling noise bands with random values of the signalled energy.
+func (d *decoder) dequantise(ch *channel) {
+	clear(ch.spec[:])
+	info := &ch.ics
+	win := 0
+	for g := range info.groups {
+		for sfb := range info.maxSFB {
+			lo, hi := int(info.offsets[sfb]), int(info.offsets[sfb+1])
+			cb := ch.cb[g][sfb]
+			for w := win; w < win+info.groupLen[g]; w++ {
+				base := w * shortLength
+				spec := ch.spec[base+lo : base+hi]
+				switch {
+				case cb == noiseHCB:
+					for k := range spec {
+						d.noise = d.noise*1664525 + 1013904223
+						spec[k] = float64(int32(d.noise))
+					}
+					scale := math.Pow(2, 0.25*float64(ch.sf[g][sfb])) / math.Sqrt(energy(spec))
+					for k := range spec {
+						spec[k] *= scale
+					}
+				case cb != zeroHCB && cb < noiseHCB:
+					scale := math.Pow(2, 0.25*float64(ch.sf[g][sfb]-100))
+					for k, q := range ch.quant[base+lo : base+hi] {
+						spec[k] = dequant(q) * scale
+					}
+				}
+			}
+		}
+		win += info.groupLen[g]
+	}
+}
+
+// dequant returns sign(q)·|q|^(4/3).
+func dequant(q int) float64 {
+	switch {
+	case q == 0:
+		return 0
+	case q > 0 && q < len(pow43):
+		return pow43[q]
+	case q < 0 && -q < len(pow43):
+		return -pow43[-q]
+	}
+	v := math.Pow(math.Abs(float64(q)), 4.0/3)
+	if q < 0 {
+		return -v
+	}
+	return v
+}
+
+// pow43 caches |q|^(4/3) for the values codebooks produce without escapes.
+var pow43 = func() []float64 {
+	t := make([]float64, 17)
+	for i := range t {
+		t[i] = math.Pow(float64(i), 4.0/3)
+	}
+	return t
+}()
+
+// applyTNS runs the temporal noise shaping filters over ch.spec.
+func (ch *channel) applyTNS() {
+	info := &ch.ics
+	limit := min(info.tnsMaxBands, info.maxSFB)
+	if limit == 0 {
+		return
+	}
+	for w := range info.windows {
+		t := &ch.tns[w]
+		top := len(info.offsets) - 1
+		for f := range t.filters {
+			bottom := max(0, top-t.length[f])
+			start, end := int(info.offsets[min(bottom, limit)]), int(info.offsets[min(top, limit)])
+			top = bottom
+			order := t.order[f]
+			if order == 0 || end <= start {
+				continue
+			}
+			spec := ch.spec[w*shortLength:]
+			pos, inc := start, 1
+			if t.down[f] {
+				pos, inc = end-1, -1
+			}
+			lpc := &t.lpc[f]
+			for m := range end - start {
+				for i := 1; i <= min(m, order); i++ {
+					spec[pos] -= lpc[i] * spec[pos-i*inc]
+				}
+				pos += inc
+			}
+		}
+	}
+}
+
+// codebook is a spectral Huffman codebook and how its codewords unpack
+// into dim quantised values.
+type codebook struct {
+	huffman
+	dim      int
+	signed   bool
+	modulo   int
+	escapes  bool
+	maxValue int
+}
+
+var spectralBooks = func() [11]codebook {
+	var books [11]codebook
+	for i := range books {
+		b := &books[i]
+		b.huffman = newHuffman(spectralCodes[i], spectralBits[i])
+		switch i + 1 {
+		case 1, 2:
+			b.dim, b.signed, b.maxValue = 4, true, 1
+		case 3, 4:
+			b.dim, b.maxValue = 4, 2
+		case 5, 6:
+			b.dim, b.signed, b.maxValue = 2, true, 4
+		case 7, 8:
+			b.dim, b.maxValue = 2, 7
+		case 9, 10:
+			b.dim, b.maxValue = 2, 12
+		case escHCB:
+			b.dim, b.maxValue, b.escapes = 2, 16, true
+		}
+		if b.signed {
+			b.modulo = 2*b.maxValue + 1
+		} else {
+			b.modulo = b.maxValue + 1
+		}
+	}
+	return books
+}()
+
+// read decodes one codeword into len(q) == b.dim values.
+func (b *codebook) read(r *bitReader, q []int) error {
+	idx := b.decode(r)
+	if idx < 0 {
+		return fmt.Errorf("%w: bad spectral codeword", errBitstream)
+	}
+	for i := b.dim - 1; i >= 0; i-- {
+		q[i] = idx % b.modulo
+		idx /= b.modulo
+		if b.signed {
+			q[i] -= b.maxValue
+		}
+	}
+	if b.signed {
+		return nil
+	}
+	for i := range q {
+		if q[i] != 0 && r.read(1) == 1 {
+			q[i] = -q[i]
+		}
+	}
+	if !b.escapes {
+		return nil
+	}
+	for i := range q {
+		if q[i] != 16 && q[i] != -16 {
+			continue
+		}
+		n := 4
+		for r.read(1) == 1 {
+			if n++; n > 12 || r.err {
+				return fmt.Errorf("%w: bad escape", errBitstream)
+			}
+		}
+		v := 1<<n + r.read(n)
+		if q[i] < 0 {
+			v = -v
+		}
+		q[i] = v
+	}
+	return nil
+}
+
+var scalefactorHuffman = newHuffman(scalefactorCodes[:], scalefactorBits[:])
+
+// huffman is a prefix code as a binary tree. Node i's children are at
+// 2i and 2i+1; a child is another node's index, ^symbol for a leaf, or 0
+// (the root, which is no one's child) for an unused code.
+type huffman []int32
+
+func newHuffman[C uint16 | uint32](codes []C, bits []uint8) huffman {
+	h := huffman{0, 0}
+	for sym, code := range codes {
+		node := int32(0)
+		for i := int(bits[sym]) - 1; i >= 0; i-- {
+			child := 2*node + int32(code>>i&1)
+			if i == 0 {
+				h[child] = ^int32(sym)
+				break
+			}
+			if h[child] == 0 {
+				h[child] = int32(len(h) / 2)
+				h = append(h, 0, 0)
+			}
+			node = h[child]
+		}
+	}
+	return h
+}
+
+// decode reads one codeword and returns its symbol, or -1 for a code the
+// tree does not have.
+func (h huffman) decode(r *bitReader) int {
+	node := int32(0)
+	for {
+		child := h[2*node+int32(r.read(1))]
+		switch {
+		case r.err || child == 0:
+			r.err = true
+			return -1
+		case child < 0:
+			return int(^child)
+		}
+		node = child
+	}
+}
+
+type bitReader struct {
+	b   []byte
+	pos int
+	err bool
+}
+
+func (r *bitReader) read(n int) int {
+	v := 0
+	for range n {
+		if r.pos/8 >= len(r.b) {
+			r.err = true
+			return 0
+		}
+		v = v<<1 | int(r.b[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+	return v
+}
+
+func (r *bitReader) skip(n int) {
+	r.pos += n
+	if r.pos > 8*len(r.b) {
+		r.err = true
+	}
+}
+
+func (r *bitReader) align() {
+	r.pos = (r.pos + 7) &^ 7
+}
@@ -0,0 +1,227 @@
+package waveform
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// filterbank is the AAC synthesis filterbank (ISO/IEC 14496-3, 4.6.18): an
+// inverse MDCT of every window, windowed and overlap-added with the
+// previous frame.
+type filterbank struct {
+	long, short *imdct
+	// windows holds the rising halves of the sine (0) and KBD (1) windows
+	// for long and short blocks; falling halves are their mirror images.
+	longWindows  [2][]float64
+	shortWindows [2][]float64
+	buf          [2 * frameLength]float64
+	out          [2 * frameLength]float64
+}
+
+func newFilterbank() *filterbank {
+	return &filterbank{
+		long:         newIMDCT(frameLength),
+		short:        newIMDCT(shortLength),
+		longWindows:  [2][]float64{sineWindow(2 * frameLength), kbdWindow(2*frameLength, 4)},
+		shortWindows: [2][]float64{sineWindow(2 * shortLength), kbdWindow(2*shortLength, 6)},
+	}
+}
+
+// synthesize turns ch.spec into ch.pcm, scaled so that full scale is 1.
+func (f *filterbank) synthesize(ch *channel) {
+	info := &ch.ics
+	buf := f.buf[:]
+	longPrev, longCur := f.longWindows[ch.prevShape], f.longWindows[info.shape]
+	shortPrev, shortCur := f.shortWindows[ch.prevShape], f.shortWindows[info.shape]
+	const (
+		flat  = (frameLength - shortLength) / 2 // 448
+		long  = frameLength
+		short = shortLength
+	)
+
+	if info.sequence == eightShortSequence {
+		clear(buf)
+		out := f.out[:2*short]
+		for w := range 8 {
+			f.short.transform(ch.spec[w*short:(w+1)*short], out)
+			rise := shortCur
+			if w == 0 {
+				rise = shortPrev
+			}
+			at := buf[flat+w*short:]
+			for n := range short {
+				at[n] += out[n] * rise[n]
+				at[short+n] += out[short+n] * shortCur[short-1-n]
+			}
+		}
+	} else {
+		f.long.transform(ch.spec[:], buf)
+		switch info.sequence {
+		case onlyLongSequence, longStartSequence:
+			for n := range long {
+				buf[n] *= longPrev[n]
+			}
+		case longStopSequence:
+			clear(buf[:flat])
+			for n := range short {
+				buf[flat+n] *= shortPrev[n]
+			}
+		}
+		switch info.sequence {
+		case onlyLongSequence, longStopSequence:
+			for n := range long {
+				buf[long+n] *= longCur[long-1-n]
+			}
+		case longStartSequence:
+			for n := range short {
+				buf[long+flat+n] *= shortCur[short-1-n]
+			}
+			clear(buf[long+flat+short:])
+		}
+	}
+
+	for n := range long {
+		ch.pcm[n] = (ch.overlap[n] + buf[n]) / 32768
+	}
+	copy(ch.overlap[:], buf[long:])
+	ch.prevShape = info.shape
+}
+
+// sineWindow returns the rising half of the sine window of length n.
+func sineWindow(n int) []float64 {
+	w := make([]float64, n/2)
+	for i := range w {
+		w[i] = math.Sin(math.Pi / float64(n) * (float64(i) + 0.5))
+	}
+	return w
+}
+
+// kbdWindow returns the rising half of the Kaiser-Bessel derived window of
+// length n with parameter alpha.
+func kbdWindow(n int, alpha float64) []float64 {
+	half := n / 2
+	kaiser := make([]float64, half+1)
+	var total float64
+	for j := range kaiser {
+		x := 2*float64(j)/float64(half) - 1
+		kaiser[j] = besselI0(math.Pi * alpha * math.Sqrt(1-x*x))
+		total += kaiser[j]
+	}
+	w := make([]float64, half)
+	var sum float64
+	for i := range w {
+		sum += kaiser[i]
+		w[i] = math.Sqrt(sum / total)
+	}
+	return w
+}
+
+// besselI0 is the zeroth-order modified Bessel function of the first kind.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > 1e-12*sum; k++ {
+		term *= (x / 2 / float64(k)) * (x / 2 / float64(k))
+		sum += term
+	}
+	return sum
+}
+
+// imdct computes the inverse MDCT of n coefficients,
+//
+//	y[i] = 2/N · Σ X[k]·cos(2π/N·(i + n0)·(k + 1/2)),  N = 2n, n0 = (n+1)/2,
+//
+// through a DCT-IV of n points, itself an n/2-point complex FFT between
+// two twiddles.
+type imdct struct {
+	n         int
+	pre, post []complex128
+	fft       *fft
+	z         []complex128
+	u         []float64
+}
+
+func newIMDCT(n int) *imdct {
+	t := &imdct{
+		n:    n,
+		pre:  make([]complex128, n/2),
+		post: make([]complex128, n/2),
+		fft:  newFFT(n / 2),
+		z:    make([]complex128, n/2),
+		u:    make([]float64, n),
+	}
+	for k := range n / 2 {
+		t.pre[k] = cmplx.Exp(complex(0, -math.Pi*float64(k)/float64(n)))
+		t.post[k] = cmplx.Exp(complex(0, -math.Pi*float64(4*k+1)/float64(4*n)))
+	}
+	return t
+}
+
+// transform writes the 2n outputs for the n coefficients in x into y.
+func (t *imdct) transform(x, y []float64) {
+	n := t.n
+	for k := range n / 2 {
+		t.z[k] = complex(x[2*k], x[n-1-2*k]) * t.pre[k]
+	}
+	t.fft.transform(t.z)
+	for p := range n / 2 {
+		w := t.z[p] * t.post[p]
+		t.u[2*p] = real(w)
+		t.u[n-1-2*p] = -imag(w)
+	}
+
+	// The IMDCT is the DCT-IV shifted by n/2 and extended by its odd and
+	// even symmetries.
+	scale := 1 / float64(n)
+	for i := range n / 2 {
+		y[i] = t.u[n/2+i] * scale
+	}
+	for i := n / 2; i < 3*n/2; i++ {
+		y[i] = -t.u[3*n/2-1-i] * scale
+	}
+	for i := 3 * n / 2; i < 2*n; i++ {
+		y[i] = -t.u[i-3*n/2] * scale
+	}
+}
+
+// fft is an in-place radix-2 complex FFT of a fixed power-of-two size.
+type fft struct {
+	n       int
+	twiddle []complex128
+	reverse []int
+}
+
+func newFFT(n int) *fft {
+	f := &fft{n: n, twiddle: make([]complex128, n/2), reverse: make([]int, n)}
+	for k := range n / 2 {
+		f.twiddle[k] = cmplx.Exp(complex(0, -2*math.Pi*float64(k)/float64(n)))
+	}
+	bits := 0
+	for 1<<bits < n {
+		bits++
+	}
+	for i := range n {
+		r := 0
+		for b := range bits {
+			r |= (i >> b & 1) << (bits - 1 - b)
+		}
+		f.reverse[i] = r
+	}
+	return f
+}
+
+func (f *fft) transform(z []complex128) {
+	for i, r := range f.reverse {
+		if i < r {
+			z[i], z[r] = z[r], z[i]
+		}
+	}
+	for size := 2; size <= f.n; size *= 2 {
+		step := f.n / size
+		for start := 0; start < f.n; start += size {
+			for k := range size / 2 {
+				a, b := z[start+k], z[start+k+size/2]*f.twiddle[k*step]
+				z[start+k], z[start+k+size/2] = a+b, a-b
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package waveform
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Store serves waveforms for the files of one filesystem, computing each
+// at most once per version of a file: results are kept in memory and,
+// when dir is set, in dir as <version>-<sha256>.json so they survive
+// restarts and are shared by files with identical audio.
+type Store struct {
+	fsys fs.FS
+	dir  string
+	// entries holds the latest waveform of each name, stamped with the
+	// file's size and modification time; a replaced file's entry is
+	// overwritten rather than kept beside the new one.
+	entries sync.Map // name → storeEntry
+	// mu serialises computation so concurrent first requests do the work
+	// once.
+	mu sync.Mutex
+}
+
+type storeEntry struct {
+	size int64
+	mod  time.Time
+	hash string
+	w    *Waveform
+}
+
+func (e storeEntry) matches(stat fs.FileInfo) bool {
+	return e.size == stat.Size() && e.mod.Equal(stat.ModTime())
+}
+
+// NewStore returns a store over fsys that persists to dir ("" for memory
+// only).
+func NewStore(fsys fs.FS, dir string) *Store {
+	return &Store{fsys: fsys, dir: dir}
+}
+
+// Get returns the waveform of name and its content hash, which makes a
+// strong validator.
+func (s *Store) Get(name string) (*Waveform, string, error) {
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return nil, "", err
+		}
+		ra = bytes.NewReader(data)
+	}
+
+	if v, ok := s.entries.Load(name); ok && v.(storeEntry).matches(stat) {
+		e := v.(storeEntry)
+		return e.w, e.hash, nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(ra, 0, stat.Size())); err != nil {
+		return nil, "", err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.entries.Load(name); ok && v.(storeEntry).matches(stat) {
+		e := v.(storeEntry)
+		return e.w, e.hash, nil
+	}
+	entry := storeEntry{size: stat.Size(), mod: stat.ModTime(), hash: hash}
+	if w, ok := s.load(hash); ok {
+		entry.w = w
+		s.entries.Store(name, entry)
+		return w, hash, nil
+	}
+	w, err := Compute(ra, stat.Size())
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", name, err)
+	}
+	entry.w = w
+	s.entries.Store(name, entry)
+	if err := s.save(hash, w); err != nil {
+		// The result is still served; only the disk copy is lost.
+		return w, hash, fmt.Errorf("cache %s: %w", name, err)
+	}
+	return w, hash, nil
+}
+
+func (s *Store) path(hash string) string {
+	return filepath.Join(s.dir, fmt.Sprintf("v%d-%s.json", Version, hash))
+}
+
+func (s *Store) load(hash string) (*Waveform, bool) {
+	if s.dir == "" {
+		return nil, false
+	}
+	data, err := os.ReadFile(s.path(hash))
+	if err != nil {
+		return nil, false
+	}
+	var w Waveform
+	if err := json.Unmarshal(data, &w); err != nil || w.Version != Version {
+		return nil, false
+	}
+	return &w, true
+}
+
+// save writes through a temporary file so a reader never sees a partial
+// entry.
+func (s *Store) save(hash string, w *Waveform) error {
+	if s.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".waveform-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path(hash)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+// Package waveform computes peak and RMS envelopes of the preview tracks
+// for drawing waveforms before playback starts. Tracks are decoded to PCM
+// with the package's own AAC-LC decoder, since there is no pure-Go one to
+// lean on, and trimmed to their edit lists so encoder priming does not
+// show up as the start of the track.
+package waveform
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"sonare.media/internal/mp4"
+)
+
+const (
+	// Version changes whenever the computation does, invalidating caches.
+	Version = 2
+	// Method names how levels are measured, for clients and cache entries.
+	Method = "pcm"
+)
+
+// Resolutions are the point counts computed for every track.
+var Resolutions = []int{64, 256, 1024}
+
+// ErrNoFrames is returned when a track has no audio to measure.
+var ErrNoFrames = errors.New("no AAC frames")
+
+// Waveform is a track's envelope at each of Resolutions.
+type Waveform struct {
+	Version  int     `json:"version"`
+	Method   string  `json:"method"`
+	Duration float64 `json:"duration_seconds"`
+	Frames   int     `json:"frames"`
+	// Series holds one entry per resolution, coarsest first.
+	Series []Series `json:"series"`
+}
+
+// Series is an envelope of Points buckets. Values are linear amplitudes
+// in [0, 1] relative to full scale, taken over all channels: the largest
+// absolute sample of each bucket and its root mean square.
+type Series struct {
+	Points int       `json:"points"`
+	Peaks  []float64 `json:"peaks"`
+	RMS    []float64 `json:"rms"`
+}
+
+// Resolution returns the series with the given number of points.
+func (w *Waveform) Resolution(points int) (Series, bool) {
+	for _, s := range w.Series {
+		if s.Points == points {
+			return s, true
+		}
+	}
+	return Series{}, false
+}
+
+// Compute decodes the audio track of the MP4 file in r and builds its
+// envelope.
+func Compute(r io.ReaderAt, size int64) (*Waveform, error) {
+	table, err := mp4.ReadSamples(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if table.Timescale == 0 {
+		return nil, errors.New("audio track has no timescale")
+	}
+	if len(table.Samples) == 0 {
+		return nil, ErrNoFrames
+	}
+	dec, err := newDecoder(table.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	// The edit list says which decoded samples are the track: the first
+	// Skip are priming, and the track lasts Length.
+	rate := uint64(dec.rate)
+	decoded := len(table.Samples) * frameLength
+	skip := min(int(table.Skip*rate/uint64(table.Timescale)), decoded)
+	length := decoded - skip
+	if table.Length > 0 {
+		length = min(length, int(table.Length*rate/uint64(table.Timescale)))
+	}
+	if length <= 0 {
+		return nil, ErrNoFrames
+	}
+
+	envelopes := make([]*buckets, len(Resolutions))
+	for i, points := range Resolutions {
+		envelopes[i] = newBuckets(points, length)
+	}
+	var buf []byte
+	pos := -skip
+	for i, s := range table.Samples {
+		if cap(buf) < int(s.Size) {
+			buf = make([]byte, s.Size)
+		}
+		frame := buf[:s.Size]
+		if _, err := r.ReadAt(frame, s.Offset); err != nil {
+			return nil, err
+		}
+		channels, err := dec.decode(frame)
+		if err != nil {
+			return nil, fmt.Errorf("frame %d: %w", i, err)
+		}
+		for n := range frameLength {
+			if pos+n < 0 || pos+n >= length {
+				continue
+			}
+			var peak, power float64
+			for _, ch := range channels {
+				v := ch.pcm[n]
+				peak = max(peak, math.Abs(v))
+				power += v * v
+			}
+			for _, b := range envelopes {
+				b.add(pos+n, peak, power/float64(len(channels)))
+			}
+		}
+		pos += frameLength
+	}
+
+	w := &Waveform{
+		Version:  Version,
+		Method:   Method,
+		Duration: float64(length) / float64(rate),
+		Frames:   len(table.Samples),
+	}
+	for _, b := range envelopes {
+		w.Series = append(w.Series, b.series())
+	}
+	return w, nil
+}
+
+// buckets accumulates the samples of a track into points equal spans.
+type buckets struct {
+	points, length int
+	peak, power    []float64
+	count          []int
+}
+
+func newBuckets(points, length int) *buckets {
+	points = min(points, length)
+	return &buckets{
+		points: points,
+		length: length,
+		peak:   make([]float64, points),
+		power:  make([]float64, points),
+		count:  make([]int, points),
+	}
+}
+
+// add records the sample at pos: its largest absolute value over the
+// channels and its mean power.
+func (b *buckets) add(pos int, peak, power float64) {
+	i := pos * b.points / b.length
+	b.peak[i] = max(b.peak[i], peak)
+	b.power[i] += power
+	b.count[i]++
+}
+
+func (b *buckets) series() Series {
+	s := Series{Points: b.points, Peaks: make([]float64, b.points), RMS: make([]float64, b.points)}
+	for i := range b.points {
+		s.Peaks[i] = round(b.peak[i])
+		if b.count[i] > 0 {
+			s.RMS[i] = round(math.Sqrt(b.power[i] / float64(b.count[i])))
+		}
+	}
+	return s
+}
+
+// round clamps a level to [0, 1] and rounds it to keep the JSON small.
+func round(v float64) float64 {
+	return math.Round(min(v, 1)*1000) / 1000
+}
@@ -0,0 +1,178 @@
+package waveform
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestIMDCT(t *testing.T) {
+	t.Parallel()
+
+	for _, n := range []int{shortLength, frameLength} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			t.Parallel()
+			rng := rand.New(rand.NewPCG(1, uint64(n)))
+			x := make([]float64, n)
+			for k := range x {
+				x[k] = rng.Float64()*2 - 1
+			}
+			got := make([]float64, 2*n)
+			newIMDCT(n).transform(x, got)
+
+			// The definition in ISO/IEC 14496-3, 4.6.18.2.
+			bigN, n0 := float64(2*n), (float64(n)+1)/2
+			for i := range 2 * n {
+				var want float64
+				for k, v := range x {
+					want += v * math.Cos(2*math.Pi/bigN*(float64(i)+n0)*(float64(k)+0.5))
+				}
+				want *= 2 / bigN
+				if math.Abs(got[i]-want) > 1e-9 {
+					t.Fatalf("output %d mismatch: got=%v want=%v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestHuffmanDecodesEveryCodeword(t *testing.T) {
+	t.Parallel()
+
+	check := func(name string, h huffman, code func(int) (uint32, uint8), n int) {
+		for sym := range n {
+			c, bits := code(sym)
+			// Left-align the codeword in a 32-bit buffer.
+			v := c << (32 - bits)
+			r := bitReader{b: []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}}
+			if got := h.decode(&r); got != sym || r.pos != int(bits) {
+				t.Errorf("%s codeword %d mismatch: got=%d after %d bits want=%d after %d", name, sym, got, r.pos, sym, bits)
+			}
+		}
+	}
+	check("scalefactor", scalefactorHuffman, func(i int) (uint32, uint8) {
+		return scalefactorCodes[i], scalefactorBits[i]
+	}, len(scalefactorCodes))
+	for cb := range spectralBooks {
+		check(fmt.Sprintf("codebook %d", cb+1), spectralBooks[cb].huffman, func(i int) (uint32, uint8) {
+			return uint32(spectralCodes[cb][i]), spectralBits[cb][i]
+		}, len(spectralCodes[cb]))
+	}
+}
+
+func TestComputeTrack(t *testing.T) {
+	t.Parallel()
+
+	f, err := os.Open("../../web/music/WARM_OPEN-FirstLight.m4a")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	w, err := Compute(f, stat.Size())
+	if err != nil {
+		t.Fatalf("compute: %v", err)
+	}
+
+	// The edit list plays 153.08s from after 1024 samples of priming.
+	if w.Duration != 153.08 || w.Frames != 7177 {
+		t.Errorf("length mismatch: got=%vs %d frames want=153.08s 7177 frames", w.Duration, w.Frames)
+	}
+
+	// The track decoded by FFmpeg and reduced the same way: a level body
+	// that fades out over the last three buckets.
+	wantPeaks := []float64{
+		0.741, 0.791, 0.721, 0.728, 0.745, 0.709, 0.714, 0.747, 0.744, 0.735, 0.728, 0.738, 0.729, 0.823, 0.752, 0.765,
+		0.81, 0.826, 0.757, 0.741, 0.805, 0.745, 0.747, 0.803, 0.785, 0.775, 0.749, 0.783, 0.78, 0.758, 0.77, 0.768,
+		0.782, 0.826, 0.789, 0.782, 0.771, 0.801, 0.747, 0.741, 0.793, 0.768, 0.762, 0.833, 0.776, 0.798, 0.766, 0.778,
+		0.751, 0.758, 0.752, 0.768, 0.789, 0.753, 0.762, 0.756, 0.766, 0.76, 0.793, 0.78, 0.757, 0.324, 0.279, 0.15,
+	}
+	wantRMS := []float64{
+		0.162, 0.15, 0.149, 0.169, 0.165, 0.133, 0.177, 0.158, 0.193, 0.147, 0.145, 0.179, 0.157, 0.197, 0.152, 0.206,
+		0.177, 0.194, 0.16, 0.18, 0.194, 0.209, 0.194, 0.154, 0.2, 0.197, 0.162, 0.196, 0.196, 0.201, 0.203, 0.187,
+		0.189, 0.195, 0.198, 0.216, 0.183, 0.192, 0.195, 0.204, 0.199, 0.189, 0.209, 0.191, 0.213, 0.194, 0.212, 0.202,
+		0.231, 0.185, 0.223, 0.211, 0.2, 0.154, 0.135, 0.182, 0.129, 0.17, 0.147, 0.173, 0.214, 0.099, 0.061, 0.022,
+	}
+	s, ok := w.Resolution(64)
+	if !ok || len(s.Peaks) != 64 || len(s.RMS) != 64 {
+		t.Fatalf("64-point series mismatch: got=%+v", s)
+	}
+	for i := range 64 {
+		// Allow for rounding a value that differs in the last float bits.
+		if math.Abs(s.Peaks[i]-wantPeaks[i]) > 0.0011 || math.Abs(s.RMS[i]-wantRMS[i]) > 0.0011 {
+			t.Errorf("bucket %d mismatch: got=%v/%v want=%v/%v", i, s.Peaks[i], s.RMS[i], wantPeaks[i], wantRMS[i])
+		}
+	}
+
+	for _, s := range w.Series {
+		if len(s.Peaks) != s.Points || len(s.RMS) != s.Points {
+			t.Errorf("series %d mismatch: peaks=%d rms=%d", s.Points, len(s.Peaks), len(s.RMS))
+		}
+	}
+}
+
+func TestStorePersistsByContent(t *testing.T) {
+	t.Parallel()
+
+	music := os.DirFS("../../web/music")
+	name := "CALM_Sonare.m4a"
+	dir := t.TempDir()
+
+	w, hash, err := NewStore(music, dir).Get(name)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if w.Frames == 0 || w.Duration <= 0 || len(w.Series) != len(Resolutions) {
+		t.Fatalf("waveform mismatch: frames=%d duration=%v series=%d", w.Frames, w.Duration, len(w.Series))
+	}
+	if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("v%d-%s.json", Version, hash))); err != nil {
+		t.Fatalf("cache entry missing: %v", err)
+	}
+
+	// A fresh store reads the entry back instead of recomputing.
+	again, againHash, err := NewStore(music, dir).Get(name)
+	if err != nil || againHash != hash || again.Frames != w.Frames {
+		t.Errorf("cached waveform mismatch: frames=%d hash=%s err=%v", again.Frames, againHash, err)
+	}
+}
+
+func TestStoreReplacesChangedFiles(t *testing.T) {
+	t.Parallel()
+
+	calm, err := os.ReadFile("../../web/music/CALM_Sonare.m4a")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	playful, err := os.ReadFile("../../web/music/PLAYFUL_Sonare.m4a")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	music := fstest.MapFS{"beacon.m4a": {Data: calm}}
+	s := NewStore(music, "")
+	first, firstHash, err := s.Get("beacon.m4a")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	music["beacon.m4a"] = &fstest.MapFile{Data: playful, ModTime: time.Now()}
+	second, secondHash, err := s.Get("beacon.m4a")
+	if err != nil {
+		t.Fatalf("get replaced: %v", err)
+	}
+	if secondHash == firstHash || second.Frames == first.Frames {
+		t.Errorf("replaced file served stale: hash=%s frames=%d", secondHash, second.Frames)
+	}
+	entries := 0
+	s.entries.Range(func(any, any) bool { entries++; return true })
+	if entries != 1 {
+		t.Errorf("entry count mismatch: got=%d want=1", entries)
+	}
+}
@@ -0,0 +1,93 @@
+// Package webfs layers a development directory over the embedded site so
+// edits show up without rebuilding, while anything missing falls through
+// to the copy compiled into the binary.
+package webfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+)
+
+type overlay struct {
+	upper, lower fs.FS
+}
+
+// Overlay returns a filesystem in which files in upper shadow files of the
+// same name in lower and directory listings are the union of both.
+func Overlay(upper, lower fs.FS) fs.FS {
+	return overlay{upper: upper, lower: lower}
+}
+
+func (o overlay) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f, err := o.upper.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.lower.Open(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !info.IsDir() {
+		return f, nil
+	}
+	entries, err := o.ReadDir(name)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &dir{File: f, entries: entries}, nil
+}
+
+// ReadDir implements fs.ReadDirFS, merging both layers by name.
+func (o overlay) ReadDir(name string) ([]fs.DirEntry, error) {
+	upper, upperErr := fs.ReadDir(o.upper, name)
+	lower, lowerErr := fs.ReadDir(o.lower, name)
+	if upperErr != nil && lowerErr != nil {
+		return nil, upperErr
+	}
+
+	byName := make(map[string]fs.DirEntry, len(upper)+len(lower))
+	for _, e := range lower {
+		byName[e.Name()] = e
+	}
+	for _, e := range upper {
+		byName[e.Name()] = e
+	}
+	entries := make([]fs.DirEntry, 0, len(byName))
+	for _, e := range byName {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// dir is an upper-layer directory whose listing includes the lower layer.
+type dir struct {
+	fs.File
+	entries []fs.DirEntry
+	off     int
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.off:]
+	if n <= 0 {
+		d.off = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(rest))
+	d.off += n
+	return rest[:n], nil
+}
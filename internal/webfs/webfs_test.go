@@ -0,0 +1,42 @@
+package webfs
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestOverlay(t *testing.T) {
+	t.Parallel()
+
+	lower := fstest.MapFS{
+		"index.html":    {Data: []byte("embedded index")},
+		"assets/app.js": {Data: []byte("embedded app")},
+		"music/A.m4a":   {Data: []byte("a")},
+	}
+	upper := fstest.MapFS{
+		"index.html":  {Data: []byte("dev index")},
+		"music/B.m4a": {Data: []byte("b")},
+	}
+	fsys := Overlay(upper, lower)
+
+	for name, want := range map[string]string{
+		"index.html":    "dev index",
+		"assets/app.js": "embedded app",
+		"music/A.m4a":   "a",
+		"music/B.m4a":   "b",
+	} {
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil || string(got) != want {
+			t.Errorf("%s mismatch: got=%q err=%v want=%q", name, got, err, want)
+		}
+	}
+
+	if err := fstest.TestFS(fsys, "index.html", "assets/app.js", "music/A.m4a", "music/B.m4a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fsys.Open("../etc/passwd"); err == nil {
+		t.Fatal("expected invalid path to be rejected")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+
+	"sonare.media/internal/config"
+	"sonare.media/internal/listeners"
+)
+
+// serverListeners are the sockets a serve mode runs on. They are bound
+// before anything else so that privileges can be dropped right after.
+type serverListeners struct {
+	http  net.Listener // serve-prod :80 redirect
+	https net.Listener // serve-prod :443
+	serve net.Listener // the single listener of every other serve mode
+	admin net.Listener // nil when AdminAddr is empty or could not be bound
+}
+
+type namedListener struct {
+	name string
+	ln   net.Listener
+}
+
+// named lists the bound listeners under the names bindListeners adopts
+// them by, so they can be handed to a new process.
+func (l *serverListeners) named() []namedListener {
+	var out []namedListener
+	for _, nl := range []namedListener{{"http", l.http}, {"https", l.https}, {"serve", l.serve}, {"admin", l.admin}} {
+		if nl.ln != nil {
+			out = append(out, nl)
+		}
+	}
+	return out
+}
+
+// bindListeners binds, or adopts through socket activation, every listener
+// the mode needs. Inherited sockets are matched by name (http, https,
+// serve, admin; systemd's FileDescriptorName=) and then by address.
+func bindListeners(cfg config.Config, runMode string) (*serverListeners, error) {
+	set, err := listeners.FromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("socket activation: %w", err)
+	}
+	defer func() {
+		if unused := set.Unused(); len(unused) > 0 {
+			slog.Warn("LISTENERS: closing unused inherited sockets", "names", unused)
+			set.Close()
+		}
+	}()
+
+	listen := func(name, addr string) (net.Listener, error) {
+		ln, inherited, err := set.Listen(name, addr)
+		if err != nil {
+			return nil, fmt.Errorf("listen %s on %s: %w", name, addr, err)
+		}
+		if inherited {
+			slog.Info("LISTENERS: using inherited socket", "name", name, "addr", ln.Addr().String())
+		}
+		return ln, nil
+	}
+
+	var l serverListeners
+	if runMode == "serve-prod" {
+		if l.http, err = listen("http", ":80"); err != nil {
+			return nil, fmt.Errorf("%w (bind as root with run_as.user set, grant CAP_NET_BIND_SERVICE, or use socket activation)", err)
+		}
+		if l.https, err = listen("https", ":443"); err != nil {
+			l.http.Close()
+			return nil, fmt.Errorf("%w (bind as root with run_as.user set, grant CAP_NET_BIND_SERVICE, or use socket activation)", err)
+		}
+	} else if l.serve, err = listen("serve", ":"+cfg.Port); err != nil {
+		return nil, err
+	}
+
+	if cfg.AdminAddr != "" {
+		// The admin listener is optional; a clash must not take the site down.
+		if l.admin, err = listen("admin", cfg.AdminAddr); err != nil {
+			slog.Error("Admin Server Failed", "err", err)
+		}
+	}
+	return &l, nil
+}
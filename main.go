@@ -2,56 +2,188 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
+	"log/slog"
 	"mime"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+	"go.opentelemetry.io/otel/trace"
+	"sonare.media/internal/assets"
+	"sonare.media/internal/catalog"
+	"sonare.media/internal/certs"
+	"sonare.media/internal/compression"
+	"sonare.media/internal/config"
+	"sonare.media/internal/csp"
+	"sonare.media/internal/hls"
+	"sonare.media/internal/logging"
+	"sonare.media/internal/metrics"
+	"sonare.media/internal/mp4"
+	"sonare.media/internal/signedurl"
 	"sonare.media/internal/store"
+	"sonare.media/internal/tracing"
 	"sonare.media/internal/tui"
+	"sonare.media/internal/waveform"
 )
 
+// flagKeys maps command-line flags onto config keys. Flags take precedence
+// over the config file and SONARE_* variables, but only when given.
+var flagKeys = map[string]string{
+	"mode":               "mode",
+	"port":               "port",
+	"db":                 "db",
+	"admin-addr":         "admin_addr",
+	"web-dir":            "web_dir",
+	"shutdown-timeout":   "shutdown_timeout",
+	"upgrade-timeout":    "upgrade_timeout",
+	"tls-cert":           "tls.cert_file",
+	"tls-key":            "tls.key_file",
+	"user":               "run_as.user",
+	"group":              "run_as.group",
+	"dev-ca":             "tls.self_signed.dev_ca",
+	"dev-hosts":          "tls.self_signed.hosts",
+	"acme":               "tls.acme.enabled",
+	"acme-directory":     "tls.acme.directory_url",
+	"acme-hosts":         "tls.acme.hosts",
+	"acme-email":         "tls.acme.email",
+	"log-level":          "log.level",
+	"log-format":         "log.format",
+	"log-file":           "log.file",
+	"log-max-size":       "log.max_size_mb",
+	"log-max-backups":    "log.max_backups",
+	"log-max-age":        "log.max_age_days",
+	"log-rotate-every":   "log.rotate_every",
+	"log-compress":       "log.compress",
+	"access-log":         "log.access_log",
+	"access-log-format":  "log.access_log_format",
+	"otlp-endpoint":      "tracing.otlp_endpoint",
+	"otlp-insecure":      "tracing.insecure",
+	"trace-sample-ratio": "tracing.sample_ratio",
+}
+
 func main() {
-	mode := flag.String("mode", "serve-test", "Mode: 'serve-test' (TLS test), 'serve-http' (HTTP only), 'serve-cfd' (Cloudflare Tunnel alias), 'serve-prod' (Prod :443/:80), or 'view' (TUI)")
-	port := flag.String("port", "8080", "Port to serve on (test/http/cfd modes)")
-	dbPath := flag.String("db", "sonare.db", "Path to SQLite database")
+	def := config.Default()
+	configPath := flag.String("config", os.Getenv(config.EnvConfigFile), "Path to a YAML config file (also "+config.EnvConfigFile+")")
+	flag.String("mode", def.Mode, "Mode: 'serve-test' (TLS test), 'serve-http' (HTTP only), 'serve-cfd' (Cloudflare Tunnel alias), 'serve-prod' (Prod :443/:80), or 'view' (TUI)")
+	flag.String("port", def.Port, "Port to serve on (test/http/cfd modes)")
+	flag.String("db", def.DB, "Path to SQLite database")
+	flag.String("admin-addr", def.AdminAddr, "Address for the admin listener serving /metrics (empty serves /metrics on the public listener)")
+	flag.String("web-dir", def.WebDir, "Directory overlaid on the embedded site and music previews for local development (empty serves the embedded copy)")
+	flag.String("tls-cert", def.TLS.CertFile, "TLS certificate file (test/prod modes)")
+	flag.String("tls-key", def.TLS.KeyFile, "TLS private key file (test/prod modes)")
+	flag.Duration("shutdown-timeout", def.ShutdownTimeout, "How long in-flight requests may finish after SIGTERM or an upgrade")
+	flag.Duration("upgrade-timeout", def.UpgradeTimeout, "How long a SIGUSR2 upgrade waits for the new binary to become ready")
+	flag.String("user", def.RunAs.User, "When started as root, switch to this user after binding listeners")
+	flag.String("group", def.RunAs.Group, "Group to switch to with -user (default: the user's primary group)")
+	flag.Bool("dev-ca", def.TLS.SelfSigned.DevCA, "serve-test: sign generated certificates with a persisted local development CA")
+	flag.String("dev-hosts", "", "serve-test: comma-separated extra host names for generated certificates")
+	flag.Bool("acme", def.TLS.ACME.Enabled, "Obtain certificates from an ACME CA instead of -tls-cert/-tls-key")
+	flag.String("acme-directory", def.TLS.ACME.DirectoryURL, "ACME directory URL (point at a local Pebble for testing)")
+	flag.String("acme-hosts", "", "Comma-separated host names to request ACME certificates for")
+	flag.String("acme-email", def.TLS.ACME.Email, "Contact email for the ACME account")
+	flag.String("log-level", def.Log.Level, "Log level: debug, info, warn or error")
+	flag.String("log-format", def.Log.Format, "Log format: text or json")
+	flag.String("log-file", def.Log.File, "Log file path (empty logs to stdout only)")
+	flag.Int("log-max-size", def.Log.MaxSizeMB, "Rotate the log file after this many megabytes")
+	flag.Int("log-max-backups", def.Log.MaxBackups, "Number of rotated log files to keep (0 keeps all)")
+	flag.Int("log-max-age", def.Log.MaxAgeDays, "Days to keep rotated log files (0 keeps all)")
+	flag.Duration("log-rotate-every", def.Log.RotateEvery, "Rotate the log file on this interval regardless of size (0 disables)")
+	flag.Bool("log-compress", def.Log.Compress, "Gzip rotated log files")
+	flag.String("otlp-endpoint", def.Tracing.OTLPEndpoint, "OTLP/HTTP collector address for traces, e.g. localhost:4318 (empty disables tracing)")
+	flag.Bool("otlp-insecure", def.Tracing.Insecure, "Send traces to the collector over plain HTTP")
+	flag.Float64("trace-sample-ratio", def.Tracing.SampleRatio, "Fraction of new traces to record (0-1)")
+	flag.String("access-log", def.Log.AccessLog, "Access log file path (empty logs to stdout)")
+	flag.String("access-log-format", def.Log.AccessLogFormat, "Access log format: combined, json or off")
+	flag.Usage = usage
 	flag.Parse()
 
-	runMode, ok := normalizeMode(*mode)
-	if !ok {
-		log.Fatalf("Invalid mode %q. Valid modes: serve-test, serve-http, serve-cfd, serve-prod, view (aliases: test/http/cfd/prod/tui).", *mode)
+	cfg, err := loadConfig(*configPath)
+	if err == nil {
+		if flag.NArg() > 0 {
+			os.Exit(runCommand(cfg, flag.Args()))
+		}
+		err = cfg.Validate()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
 	}
 
-	// Ensure browsers receive a playable type for preview assets.
-	if err := mime.AddExtensionType(".m4a", "audio/mp4"); err != nil {
-		log.Printf("MIME REGISTER WARNING (.m4a): %v", err)
+	runMode := cfg.Mode
+
+	// Listeners come first: a root process binds :80/:443 and then drops to
+	// run_as before opening any file, and socket activation needs no root.
+	var lns *serverListeners
+	if runMode != "view" {
+		if lns, err = bindListeners(cfg, runMode); err != nil {
+			fatal("Failed to bind listeners", "err", err)
+		}
+		if err := dropPrivileges(cfg.RunAs); err != nil {
+			fatal("Failed to drop privileges", "err", err)
+		}
 	}
 
 	// Setup Logging
-	logFile, err := os.OpenFile("server.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	logger, err := logging.Setup(logging.Config{
+		Level:       cfg.Log.Level,
+		Format:      cfg.Log.Format,
+		File:        cfg.Log.File,
+		MaxSizeMB:   cfg.Log.MaxSizeMB,
+		MaxBackups:  cfg.Log.MaxBackups,
+		MaxAgeDays:  cfg.Log.MaxAgeDays,
+		RotateEvery: cfg.Log.RotateEvery,
+		Compress:    cfg.Log.Compress,
+	})
 	if err != nil {
-		fmt.Printf("Failed to open log file: %v\n", err)
-	} else {
-		multi := io.MultiWriter(os.Stdout, logFile)
-		log.SetOutput(multi)
+		fatal("Failed to set up logging", "err", err)
+	}
+	defer logger.Close()
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Endpoint:    cfg.Tracing.OTLPEndpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+		Headers:     cfg.Tracing.Headers,
+	})
+	if err != nil {
+		fatal("Failed to set up tracing", "err", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Warn("Trace flush failed", "err", err)
+		}
+	}()
+
+	// Ensure browsers receive a playable type for preview assets.
+	if err := mime.AddExtensionType(".m4a", "audio/mp4"); err != nil {
+		slog.Warn("MIME REGISTER WARNING (.m4a)", "err", err)
 	}
-	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
 
 	// Initialize Database
-	if err := store.InitDB(*dbPath); err != nil {
-		log.Fatalf("Failed to init DB: %v", err)
+	if err := store.InitDB(cfg.DB); err != nil {
+		fatal("Failed to init DB", "err", err)
 	}
 	defer func() {
 		if store.DB != nil {
@@ -61,7 +193,7 @@ func main() {
 
 	if runMode == "view" {
 		if err := tui.Start(); err != nil {
-			log.Fatalf("TUI Error: %v", err)
+			fatal("TUI Error", "err", err)
 		}
 		return
 	}
@@ -69,48 +201,155 @@ func main() {
 	// Server Mode
 	mux := http.NewServeMux()
 
+	site, err := siteFS(cfg.WebDir)
+	if err != nil {
+		fatal("Failed to open web assets", "err", err)
+	}
+	music, err := fs.Sub(site, "music")
+	if err != nil {
+		fatal("Failed to open web assets", "err", err)
+	}
+	waveforms := waveform.NewStore(music, cfg.Catalog.WaveformDir)
+	index := catalog.NewIndex(music, cfg.Catalog.RescanDelay, func(cat *catalog.Catalog, err error) {
+		if err != nil {
+			slog.Warn("CATALOG: failed to load, still serving the previous catalog", "err", err)
+			return
+		}
+		for _, problem := range cat.Problems {
+			slog.Warn("CATALOG: skipped manifest entry", "problem", problem)
+		}
+		slog.Info("CATALOG: loaded", "tracks", len(cat.Tracks))
+		go precomputeWaveforms(waveforms, cat)
+	})
+	index.Refresh()
+	if cfg.WebDir != "" {
+		// Only the development overlay can change under a running server;
+		// the embedded copy is fixed at build time.
+		go func() {
+			dir := filepath.Join(cfg.WebDir, "music")
+			if err := index.Watch(context.Background(), dir, cfg.Catalog.RescanDelay); err != nil {
+				slog.Warn("CATALOG: not watching for changes; rescans happen on SIGHUP only", "dir", dir, "err", err)
+			}
+		}()
+	}
+	// Catalog management writes to the music directory of the overlay;
+	// the embedded copy is read-only.
+	var library *catalog.Library
+	switch {
+	case cfg.AdminToken == "" || cfg.AdminAddr == "":
+		slog.Info("ADMIN: catalog management off; it needs admin_addr and admin_token")
+	case cfg.WebDir == "":
+		slog.Warn("ADMIN: catalog management off; it needs web_dir to write to")
+	default:
+		dir := filepath.Join(cfg.WebDir, "music")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			fatal("Failed to create the music directory", "dir", dir, "err", err)
+		}
+		library = catalog.NewLibrary(dir, music, cfg.Catalog.RetiredDir, int64(cfg.Catalog.MaxUploadSize))
+	}
+	// The embedded site is fixed; an overlay is compared with what was
+	// fingerprinted at most once a second, as fast as anyone edits.
+	var recheck time.Duration
+	if cfg.WebDir != "" {
+		recheck = time.Second
+	}
+	pipeline, err := assets.New(site, buildTime(), recheck)
+	if err != nil {
+		fatal("Failed to fingerprint web assets", "err", err)
+	}
+	live := newLiveConfig(cfg)
+
 	// Static File Server
-	fileServer := http.FileServer(http.Dir("web"))
-	mux.Handle("/", staticCacheHeadersMiddleware(fileServer))
+	served := pipeline.FS()
+	fileServer := http.FileServer(http.FS(served))
+	nonce := func(r *http.Request) string { return csp.Nonce(r.Context()) }
+	mux.Handle("/", staticCacheHeadersMiddleware(live, retiredMusicMiddleware(index, signedMusicMiddleware(live, pipeline.Handler(compression.NewHandler(served, pipeline.InjectNonce(fileServer, nonce), compression.DefaultMinSize))))))
 
 	// API Endpoints
 	mux.HandleFunc("/api/lead", handleLead)
-	mux.HandleFunc("/api/preview-sources", handlePreviewSources)
+	probe := mp4.NewCache(music)
+	mux.HandleFunc("/api/preview-sources", handlePreviewSources(live, index, probe))
+	mux.HandleFunc("/api/palettes", handlePalettes(index))
+	mux.HandleFunc("/api/waveform", handleWaveform(index, waveforms))
+	if cfg.Catalog.HLS.Enabled {
+		packager := hls.NewPackager(music, cfg.Catalog.HLS.SegmentDuration)
+		mux.Handle("/hls/", staticCacheHeadersMiddleware(live, signedMusicMiddleware(live, handleHLS(live, index, packager))))
+	}
+	mux.HandleFunc("/api/csp-report", handleCSPReport(csp.NewLimiter(cspReportsPerMinute, cspReportBurst)))
 	mux.HandleFunc("/healthz", handleHealth)
+	mux.HandleFunc("/livez", handleLivez)
 
-	// Apply observability and security controls to all routes.
-	handler := securityHeadersMiddleware(analyticsMiddleware(mux))
+	// Metrics stay off the public listener unless explicitly requested.
+	if cfg.AdminAddr == "" {
+		mux.Handle("/metrics", metrics.Handler())
+	}
 
-	certPath := "certs/server.crt"
-	keyPath := "certs/server.key"
+	startAnalyticsWorkers(analyticsWorkerCount)
 
-	if runMode == "serve-test" || runMode == "serve-prod" {
-		if err := ensureTLSFiles(certPath, keyPath); err != nil {
-			log.Fatalf("TLS setup error: %v (use -mode serve-http for HTTP-only)", err)
+	var accessLog *logging.AccessLog
+	if cfg.Log.AccessLogFormat != "off" {
+		var out io.Writer = os.Stdout
+		if cfg.Log.AccessLog != "" {
+			if out, err = logger.OpenFile(cfg.Log.AccessLog); err != nil {
+				fatal("Failed to open access log", "err", err)
+			}
+		}
+		if accessLog, err = logging.NewAccessLog(out, cfg.Log.AccessLogFormat); err != nil {
+			fatal("Invalid access log configuration", "err", err)
 		}
 	}
 
-	var servers []*http.Server
-
-	if runMode == "serve-prod" {
-		// Check for root privileges
-		if os.Geteuid() != 0 {
-			log.Println("PRODUCTION MODE REQUIRES ROOT PRIVILEGES (Binding ports 80/443).")
-			log.Println("Attempting to relaunch with sudo...")
-
-			cmd := exec.Command("sudo", os.Args...)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			cmd.Stdin = os.Stdin
-
-			if err := cmd.Run(); err != nil {
-				log.Fatalf("Failed to run with sudo: %v", err)
+	// Apply observability and security controls to all routes.
+	// Request ID, CSP nonce and tracing wrap everything else: they clone
+	// the request, and the metrics middleware must see the same
+	// *http.Request that ServeMux routes.
+	handler := logging.RequestIDMiddleware(csp.Middleware(tracingMiddleware(mux, metricsMiddleware(securityHeadersMiddleware(live, analyticsMiddleware(accessLog, mux))))))
+
+	var certReloader *certs.Reloader
+	var acmeManager *certs.ACME
+	var tlsConfig *tls.Config
+
+	if (runMode == "serve-test" || runMode == "serve-prod") && cfg.TLS.ACME.Enabled {
+		acmeManager, err = certs.NewACME(certs.ACMEConfig{
+			DirectoryURL: cfg.TLS.ACME.DirectoryURL,
+			Email:        cfg.TLS.ACME.Email,
+			Hosts:        cfg.TLS.ACME.Hosts,
+			CacheDir:     cfg.TLS.ACME.CacheDir,
+			CAFile:       cfg.TLS.ACME.CAFile,
+			OnCertificate: func(host string, leaf *x509.Certificate) {
+				metrics.SetCertExpiry("acme:"+host, leaf.NotAfter)
+				slog.Info("ACME: serving certificate", "host", host, "not_after", leaf.NotAfter)
+			},
+		})
+		if err != nil {
+			fatal("ACME setup error", "err", err)
+		}
+		tlsConfig = acmeManager.TLSConfig()
+		mux.Handle("/readyz", newReadiness(music, acmeManager.Leaf))
+	} else if runMode == "serve-test" || runMode == "serve-prod" {
+		if runMode == "serve-test" {
+			if err := ensureDevCertificate(cfg); err != nil {
+				fatal("TLS setup error", "err", err)
 			}
-			return // Exit parent process
 		}
+		if err := ensureTLSFiles(cfg.TLS.CertFile, cfg.TLS.KeyFile); err != nil {
+			fatal("TLS setup error (use -mode serve-http for HTTP-only)", "err", err)
+		}
+		if certReloader, err = certs.NewReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile); err != nil {
+			fatal("TLS setup error", "err", err)
+		}
+		metrics.SetCertExpiry(cfg.TLS.CertFile, certReloader.Leaf().NotAfter)
+		tlsConfig = certReloader.TLSConfig()
+		mux.Handle("/readyz", newReadiness(music, certReloader.Leaf))
+	} else {
+		mux.Handle("/readyz", newReadiness(music, nil))
+	}
+
+	var servers []*http.Server
 
+	if runMode == "serve-prod" {
 		// --- PRODUCTION MODE ---
-		log.Println("SERVER START: Production Mode Enabled")
+		slog.Info("SERVER START: Production Mode Enabled")
 
 		// 1. HTTP Redirect Server (:80 -> :443)
 		redirectMux := http.NewServeMux()
@@ -119,115 +358,190 @@ func main() {
 			http.Redirect(w, r, target, http.StatusMovedPermanently)
 		})
 
+		// With ACME enabled the redirect server also answers HTTP-01.
+		var redirectHandler http.Handler = redirectMux
+		if acmeManager != nil {
+			redirectHandler = acmeManager.HTTPHandler(redirectMux)
+		}
+
 		httpServer := &http.Server{
-			Addr:    ":80",
-			Handler: redirectMux,
+			Handler: redirectHandler,
 		}
 		servers = append(servers, httpServer)
 
 		go func() {
-			log.Println("LISTENING: :80 (HTTP Redirect)")
-			if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				log.Fatalf("HTTP Server Failed: %v", err)
+			slog.Info("LISTENING: HTTP Redirect", "addr", lns.http.Addr().String())
+			if err := httpServer.Serve(lns.http); err != nil && err != http.ErrServerClosed {
+				fatal("HTTP Server Failed", "err", err)
 			}
 		}()
 
 		// 2. HTTPS Main Server (:443)
 		httpsServer := &http.Server{
-			Addr:    ":443",
-			Handler: handler,
+			Handler:   handler,
+			TLSConfig: tlsConfig,
 		}
 		servers = append(servers, httpsServer)
 
 		go func() {
-			log.Println("LISTENING: :443 (HTTPS)")
-			if err := httpsServer.ListenAndServeTLS(certPath, keyPath); err != nil && err != http.ErrServerClosed {
-				log.Fatalf("HTTPS Server Failed: %v", err)
+			slog.Info("LISTENING: HTTPS", "addr", lns.https.Addr().String())
+			if err := httpsServer.ServeTLS(lns.https, "", ""); err != nil && err != http.ErrServerClosed {
+				fatal("HTTPS Server Failed", "err", err)
 			}
 		}()
 
 	} else if runMode == "serve-http" || runMode == "serve-cfd" {
 		// --- HTTP-ONLY MODE (Cloudflare Tunnel origin-compatible) ---
-		addr := ":" + *port
+		addr := ":" + cfg.Port
 
 		httpServer := &http.Server{
-			Addr:    addr,
 			Handler: handler,
 		}
 		servers = append(servers, httpServer)
 
 		go func() {
 			if runMode == "serve-cfd" {
-				log.Println("NOTICE: '-mode serve-cfd' is retained for compatibility; prefer '-mode serve-http'.")
-				log.Printf("SERVER START: Cloudflare Tunnel Mode on http://localhost%s (HTTP)\n", addr)
+				slog.Warn("NOTICE: '-mode serve-cfd' is retained for compatibility; prefer '-mode serve-http'.")
+				slog.Info("SERVER START: Cloudflare Tunnel Mode (HTTP)", "url", "http://localhost"+addr)
 			} else {
-				log.Printf("SERVER START: HTTP-Only Mode on http://localhost%s (HTTP)\n", addr)
+				slog.Info("SERVER START: HTTP-Only Mode (HTTP)", "url", "http://localhost"+addr)
 			}
 
-			if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				log.Fatalf("HTTP Server Failed: %v\n", err)
+			if err := httpServer.Serve(lns.serve); err != nil && err != http.ErrServerClosed {
+				fatal("HTTP Server Failed", "err", err)
 			}
 		}()
 
 	} else {
 		// --- TEST MODE ---
 		// Default to test mode if mode is serve-test or anything else (e.g. legacy 'serve')
-		addr := ":" + *port
+		addr := ":" + cfg.Port
 
 		testServer := &http.Server{
-			Addr:    addr,
-			Handler: handler,
+			Handler:   handler,
+			TLSConfig: tlsConfig,
 		}
 		servers = append(servers, testServer)
 
 		go func() {
-			log.Printf("SERVER START: Test Mode on https://localhost%s (TLS self-signed)\n", addr)
-			if err := testServer.ListenAndServeTLS(certPath, keyPath); err != nil && err != http.ErrServerClosed {
-				log.Fatalf("TLS Failed: %v\n", err)
+			slog.Info("SERVER START: Test Mode (TLS self-signed)", "url", "https://localhost"+addr)
+			if err := testServer.ServeTLS(lns.serve, "", ""); err != nil && err != http.ErrServerClosed {
+				fatal("TLS Failed", "err", err)
 			}
 		}()
 	}
 
-	// Graceful Shutdown
+	if lns.admin != nil {
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/metrics", metrics.Handler())
+		if library != nil {
+			tracks := requireAdminToken(cfg.AdminToken, handleAdminTracks(index, library, int64(cfg.Catalog.MaxUploadSize)))
+			adminMux.Handle("/admin/tracks", tracks)
+			adminMux.Handle("/admin/tracks/", tracks)
+		}
+
+		adminServer := &http.Server{
+			Handler: adminMux,
+		}
+		servers = append(servers, adminServer)
+
+		go func() {
+			slog.Info("LISTENING: Admin (/metrics, /admin/tracks)", "addr", lns.admin.Addr().String(), "catalog_management", library != nil)
+			if err := adminServer.Serve(lns.admin); err != nil && err != http.ErrServerClosed {
+				slog.Error("Admin Server Failed", "err", err)
+			}
+		}()
+	}
+
+	if acmeManager != nil {
+		go func() {
+			if err := acmeManager.Warm(cfg.TLS.ACME.Hosts); err != nil {
+				slog.Error("ACME: certificate not obtained yet; retrying on demand", "err", err)
+			}
+		}()
+	}
+
+	// Hot Reload
+	rl := &reloader{
+		configPath: *configPath,
+		live:       live,
+		logger:     logger,
+		certs:      certReloader,
+		music:      music,
+		index:      index,
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			rl.reload()
+		}
+	}()
+
+	if err := notifyUpgradeReady(); err != nil {
+		slog.Warn("UPGRADE: could not report ready to the previous process", "err", err)
+	}
+
+	// Graceful Shutdown; SIGUSR2 hands the listeners to a freshly started
+	// binary and drains this process once it is serving.
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	upgrade := make(chan os.Signal, 1)
+	notifyUpgrade(upgrade)
+
+wait:
+	for {
+		select {
+		case <-stop:
+			slog.Info("SHUTDOWN SIGNAL RECEIVED: Stopping servers...")
+			break wait
+		case <-upgrade:
+			slog.Info("UPGRADE SIGNAL RECEIVED: Starting new binary...")
+			pid, err := upgradeBinary(lns, cfg.UpgradeTimeout)
+			if err != nil {
+				slog.Error("UPGRADE FAILED: still serving", "err", err)
+				continue
+			}
+			slog.Info("UPGRADE: new process is serving; draining", "pid", pid, "timeout", cfg.ShutdownTimeout)
+			break wait
+		}
+	}
 
-	<-stop
-	log.Println("SHUTDOWN SIGNAL RECEIVED: Stopping servers...")
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
 	for _, srv := range servers {
 		if err := srv.Shutdown(ctx); err != nil {
-			log.Printf("Server forced to shutdown: %v", err)
+			slog.Warn("Server forced to shutdown", "err", err)
 		}
 	}
 
-	log.Println("SERVER STOPPED: Clean exit.")
+	slog.Info("SERVER STOPPED: Clean exit.")
+}
+
+// fatal logs at error level and exits, standing in for log.Fatalf.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
 // Middleware: Security Headers
-func securityHeadersMiddleware(next http.Handler) http.Handler {
+func securityHeadersMiddleware(live *liveConfig, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		csp := strings.Join([]string{
-			"default-src 'self'",
-			"script-src 'self' 'unsafe-inline'",
-			"style-src 'self' 'unsafe-inline'",
-			"img-src 'self' data: https:",
-			"font-src 'self' data:",
-			"media-src 'self'",
-			"connect-src 'self'",
-			"object-src 'none'",
-			"base-uri 'self'",
-			"frame-ancestors 'none'",
-			"form-action 'self'",
-		}, "; ")
-
-		w.Header().Set("Content-Security-Policy", csp)
+		sec := live.Load().Security
+		nonce := csp.Nonce(r.Context())
+		w.Header().Set("Content-Security-Policy", csp.Policy(sec.CSP, nonce, sec.CSPReportURI))
+		if len(sec.CSPReportOnly) > 0 {
+			w.Header().Set("Content-Security-Policy-Report-Only", csp.Policy(sec.CSPReportOnly, nonce, sec.CSPReportURI))
+		}
+		if sec.CSPReportURI != "" {
+			w.Header().Set("Reporting-Endpoints", csp.ReportingEndpoints(sec.CSPReportURI))
+		}
 		w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
 		w.Header().Set("Permissions-Policy", "accelerometer=(), camera=(), geolocation=(), gyroscope=(), magnetometer=(), microphone=(), payment=(), usb=()")
-		w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains; preload")
+		if sec.HSTS != "" {
+			w.Header().Set("Strict-Transport-Security", sec.HSTS)
+		}
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("X-Frame-Options", "DENY")
 		w.Header().Set("X-XSS-Protection", "1; mode=block")
@@ -236,18 +550,19 @@ func securityHeadersMiddleware(next http.Handler) http.Handler {
 }
 
 // Static caching profile for web assets.
-func staticCacheHeadersMiddleware(next http.Handler) http.Handler {
+func staticCacheHeadersMiddleware(live *liveConfig, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cache := live.Load().Cache
 		path := r.URL.Path
 		switch {
 		case path == "/" || path == "/index.html":
 			w.Header().Set("Cache-Control", "no-cache")
-		case strings.HasPrefix(path, "/music/"):
-			w.Header().Set("Cache-Control", "public, max-age=604800")
+		case strings.HasPrefix(path, "/music/"), strings.HasPrefix(path, "/hls/"):
+			w.Header().Set("Cache-Control", cacheControl(cache.Music))
 		case strings.HasPrefix(path, "/assets/"):
-			w.Header().Set("Cache-Control", "public, max-age=86400")
+			w.Header().Set("Cache-Control", cacheControl(cache.Assets))
 		case hasCacheableStaticExt(path):
-			w.Header().Set("Cache-Control", "public, max-age=86400")
+			w.Header().Set("Cache-Control", cacheControl(cache.Static))
 		default:
 			w.Header().Set("Cache-Control", "no-cache")
 		}
@@ -255,6 +570,82 @@ func staticCacheHeadersMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// retiredMusicMiddleware hides retired tracks still present under /music,
+// such as copies embedded in the binary.
+func retiredMusicMiddleware(index *catalog.Index, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if file, ok := strings.CutPrefix(r.URL.Path, "/music/"); ok && index.Catalog().IsRetired(file) {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// signedMusicMiddleware refuses /music and /hls requests without a valid
+// signature while security.signed_urls is enabled. What it lets through
+// is marked private, since every visitor has different URLs, and
+// cacheable only while the URL is still valid.
+func signedMusicMiddleware(live *liveConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := live.Load()
+		su, signer, err := live.Signer()
+		if !su.Enabled || !(strings.HasPrefix(r.URL.Path, "/music/") || strings.HasPrefix(r.URL.Path, "/hls/")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			logging.FromContext(r.Context()).Error("SIGNED URL ERROR", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		query := r.URL.Query()
+		if err := signer.Verify(r.URL.Path, query, sessionID(r), time.Now(), su.Grace); err != nil {
+			metrics.SignedURLRejections.WithLabelValues(signedurl.Reason(err)).Inc()
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		maxAge := cfg.Cache.Music
+		if exp, ok := signedurl.Expires(query); ok {
+			maxAge = min(maxAge, time.Until(exp))
+		}
+		if maxAge < time.Second {
+			w.Header().Set("Cache-Control", "private, no-cache")
+		} else {
+			w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int64(maxAge/time.Second)))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newSigner builds a signer from the configured keys, which Validate has
+// already checked.
+func newSigner(su config.SignedURLs) (*signedurl.Signer, error) {
+	keys, err := signedurl.ParseKeys(su.Keys)
+	if err != nil {
+		return nil, err
+	}
+	return signedurl.New(keys)
+}
+
+// sessionCookie identifies a browser session for signed URLs bound to one.
+const sessionCookie = "sonare_session"
+
+// sessionID returns the request's session cookie, or "".
+func sessionID(r *http.Request) string {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+func cacheControl(maxAge time.Duration) string {
+	if maxAge <= 0 {
+		return "no-cache"
+	}
+	return fmt.Sprintf("public, max-age=%d", int64(maxAge/time.Second))
+}
+
 func hasCacheableStaticExt(path string) bool {
 	switch strings.ToLower(filepath.Ext(path)) {
 	case ".css", ".js", ".jpg", ".jpeg", ".png", ".gif", ".svg", ".webp", ".ico", ".woff", ".woff2", ".ttf", ".eot", ".m4a":
@@ -264,17 +655,130 @@ func hasCacheableStaticExt(path string) bool {
 	}
 }
 
-// Middleware to track analytics
-func analyticsMiddleware(next http.Handler) http.Handler {
+// Middleware: one server span per request, named after the ServeMux
+// pattern that will handle it so span names stay low-cardinality.
+func tracingMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	tracer := tracing.Tracer("sonare.media/http")
+	propagator := otel.GetTextMapPropagator()
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		trackRequest(r)
-		next.ServeHTTP(w, r)
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// Middleware: Prometheus request counters, latency and in-flight gauge.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		metrics.HTTPInFlight.Inc()
+		defer metrics.HTTPInFlight.Dec()
+
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		// ServeMux records the matched pattern on the request, which keeps
+		// the route label bounded no matter what paths scanners probe.
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(rec.status)
+
+		metrics.HTTPRequests.WithLabelValues(route, metricMethod(r.Method), status).Inc()
+		metrics.HTTPDuration.WithLabelValues(route, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+// responseRecorder captures the status code and body size written by
+// downstream handlers.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.wroteHeader = true
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Middleware to track analytics. It runs the handler first so the access
+// log and analytics row carry the response status, size and latency.
+// accessLog may be nil to disable access logging.
+func analyticsMiddleware(accessLog *logging.AccessLog, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+		trackRequest(r, rec, start, accessLog)
 	})
 }
 
-func trackRequest(r *http.Request) {
+func trackRequest(r *http.Request, rec *responseRecorder, start time.Time, accessLog *logging.AccessLog) {
 	// Keep health checks cheap and noise-free for monitors.
-	if r.URL.Path == "/healthz" {
+	switch r.URL.Path {
+	case "/healthz", "/livez", "/readyz":
 		return
 	}
 
@@ -286,21 +790,82 @@ func trackRequest(r *http.Request) {
 		ip = fwd
 	}
 
-	// Log to file/console
-	log.Printf("REQUEST: [%s] %s %s | UA: %s", r.Method, r.URL.Path, ip, r.UserAgent())
+	duration := time.Since(start)
+	tlsVersion := ""
+	if r.TLS != nil {
+		// nginx-style "TLSv1.3" keeps the combined log space-delimited.
+		tlsVersion = strings.Replace(tls.VersionName(r.TLS.Version), "TLS ", "TLSv", 1)
+	}
 
-	// Save to DB in background
-	go func() {
-		err := store.SaveAnalytics(store.Analytics{
-			IP:        ip,
-			UserAgent: r.UserAgent(),
-			Path:      r.URL.Path,
-			Method:    r.Method,
+	if accessLog != nil {
+		accessLog.Log(logging.AccessEntry{
+			Time:       start,
+			RequestID:  logging.RequestID(r.Context()),
+			RemoteIP:   ip,
+			Method:     r.Method,
+			URI:        r.URL.RequestURI(),
+			Proto:      r.Proto,
+			Status:     rec.status,
+			Bytes:      rec.bytes,
+			Duration:   duration,
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+			TLSVersion: tlsVersion,
 		})
-		if err != nil {
-			log.Printf("DB ERROR (Analytics): %v", err)
-		}
-	}()
+	}
+
+	// Save to DB in background; shed load rather than block the request.
+	select {
+	case analyticsQueue <- analyticsEvent{
+		span: trace.SpanContextFromContext(r.Context()),
+		row: store.Analytics{
+			IP:         ip,
+			UserAgent:  r.UserAgent(),
+			Path:       r.URL.Path,
+			Method:     r.Method,
+			Status:     rec.status,
+			Bytes:      rec.bytes,
+			DurationMS: float64(duration.Microseconds()) / 1000,
+			TLSVersion: tlsVersion,
+		},
+	}:
+	default:
+		metrics.AnalyticsDropped.Inc()
+	}
+}
+
+// analyticsEvent is a queued analytics row plus the span of the request it
+// describes, so the deferred GeoIP lookup and insert join the same trace.
+type analyticsEvent struct {
+	span trace.SpanContext
+	row  store.Analytics
+}
+
+const (
+	analyticsQueueSize   = 1024
+	analyticsWorkerCount = 4
+)
+
+var analyticsQueue = make(chan analyticsEvent, analyticsQueueSize)
+
+// startAnalyticsWorkers drains analyticsQueue into the database. GeoIP
+// enrichment makes each write slow, so a few workers run side by side.
+func startAnalyticsWorkers(n int) {
+	metrics.RegisterQueueDepth("analytics", "Analytics events waiting to be written.", func() int {
+		return len(analyticsQueue)
+	})
+
+	for i := 0; i < n; i++ {
+		go func() {
+			for ev := range analyticsQueue {
+				// The request context is gone by now; keep only its trace.
+				ctx := trace.ContextWithSpanContext(context.Background(), ev.span)
+				if err := store.SaveAnalytics(ctx, ev.row); err != nil {
+					logging.FromContext(ctx).Error("DB ERROR (Analytics)", "err", err)
+				}
+			}
+		}()
+	}
 }
 
 func handleLead(w http.ResponseWriter, r *http.Request) {
@@ -311,50 +876,180 @@ func handleLead(w http.ResponseWriter, r *http.Request) {
 
 	var l store.Lead
 	if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
-		log.Printf("BAD REQUEST (Lead): %v", err)
+		logging.FromContext(r.Context()).Warn("BAD REQUEST (Lead)", "err", err)
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
 
-	// Log the Form Entry Details
-	log.Printf("LEAD RECEIVED: Name='%s' Business='%s' Email='%s' System='%s' Palette='%s' Scale='%dh/%d stores'",
-		l.Name, l.Business, l.Email, l.Playback, l.Palette, l.HoursEst, l.StoreCount)
+	// Log the Form Entry Details; Lead redacts its own PII via LogValue.
+	logger := logging.FromContext(r.Context())
+	logger.Info("LEAD RECEIVED", "lead", l)
 
-	if err := store.SaveLead(l); err != nil {
-		log.Printf("DB ERROR (Lead): %v", err)
+	if err := store.SaveLead(r.Context(), l); err != nil {
+		logger.Error("DB ERROR (Lead)", "err", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	metrics.LeadsCreated.Inc()
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]string{"status": "received"})
 }
 
-func handlePreviewSources(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+const (
+	// maxCSPReportBytes bounds a report body; real ones are well under 4KB.
+	maxCSPReportBytes = 64 << 10
+	// cspReportBurst and cspReportsPerMinute limit each client address. A
+	// page load reports every violation at once, then rarely again.
+	cspReportBurst      = 10
+	cspReportsPerMinute = 6
+)
 
-	palette := normalizePalette(r.URL.Query().Get("palette"))
-	if palette == "" {
-		http.Error(w, "Missing palette query parameter", http.StatusBadRequest)
-		return
-	}
+// handleCSPReport stores violation reports posted by browsers to the
+// report-uri (application/csp-report) or report-to (application/reports+json)
+// endpoint of either policy. Clients are told apart by the connection's
+// address, since X-Forwarded-For is theirs to choose.
+func handleCSPReport(limiter *csp.Limiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		if !limiter.Allow(host, time.Now()) {
+			metrics.CSPReportsLimited.Inc()
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
 
-	sources, err := previewSourcesForPalette(filepath.Join("web", "music"), palette)
-	if err != nil {
-		log.Printf("PREVIEW SOURCE ERROR: %v", err)
-		http.Error(w, "Failed to load preview sources", http.StatusInternalServerError)
-		return
+		logger := logging.FromContext(r.Context())
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCSPReportBytes))
+		if err != nil {
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		reports, err := csp.ParseReports(r.Header.Get("Content-Type"), body)
+		if errors.Is(err, csp.ErrUnsupportedType) {
+			http.Error(w, "Unsupported Media Type", http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			logger.Warn("BAD REQUEST (CSP report)", "err", err)
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		rows := make([]store.CSPReport, 0, len(reports))
+		for _, rep := range reports {
+			metrics.CSPReports.WithLabelValues(rep.Disposition).Inc()
+			logger.Debug("CSP VIOLATION", "directive", rep.EffectiveDirective, "blocked", rep.BlockedURI, "document", rep.DocumentURI, "disposition", rep.Disposition)
+			rows = append(rows, store.CSPReport{
+				DocumentURI:        rep.DocumentURI,
+				Referrer:           rep.Referrer,
+				EffectiveDirective: rep.EffectiveDirective,
+				BlockedURI:         rep.BlockedURI,
+				SourceFile:         rep.SourceFile,
+				Line:               rep.Line,
+				Column:             rep.Column,
+				Disposition:        rep.Disposition,
+				Sample:             rep.Sample,
+				StatusCode:         rep.StatusCode,
+				UserAgent:          r.UserAgent(),
+			})
+		}
+		if err := store.SaveCSPReports(r.Context(), rows); err != nil {
+			logger.Error("DB ERROR (CSP report)", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
 	}
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"palette": palette,
-		"sources": sources,
-	})
+// turnCookie counts a visitor's preview requests so round-robin selection
+// can rotate per visitor without server-side state.
+const turnCookie = "sonare_turn"
+
+// handlePreviewSources picks one track per phase of a palette. The
+// strategy query parameter overrides catalog.strategy, and all=1 adds
+// every variant of each phase under "variants".
+func handlePreviewSources(live *liveConfig, index *catalog.Index, probe *mp4.Cache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		palette := catalog.NormalizePalette(query.Get("palette"))
+		if palette == "" {
+			http.Error(w, "Missing palette query parameter", http.StatusBadRequest)
+			return
+		}
+		sel := previewSelection{strategy: live.Load().Catalog.Strategy}
+		if v := query.Get("strategy"); v != "" {
+			if !slices.Contains(catalog.Strategies, v) {
+				http.Error(w, fmt.Sprintf("strategy must be one of %s", strings.Join(catalog.Strategies, ", ")), http.StatusBadRequest)
+				return
+			}
+			sel.strategy = v
+		}
+		sel.all, _ = strconv.ParseBool(query.Get("all"))
+		sel.hls = live.Load().Catalog.HLS.Enabled
+		if su, signer, err := live.Signer(); su.Enabled {
+			if err != nil {
+				logging.FromContext(r.Context()).Error("SIGNED URL ERROR", "err", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			session := ""
+			if su.BindSession {
+				if session = sessionID(r); session == "" {
+					session = csp.NewNonce()
+				}
+				http.SetCookie(w, &http.Cookie{
+					Name:     sessionCookie,
+					Value:    session,
+					Path:     "/",
+					Secure:   r.TLS != nil,
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+			expires := time.Now().Add(su.TTL)
+			sel.sign = func(path string) string { return signer.Sign(path, expires, session) }
+		}
+		if sel.strategy == catalog.StrategyRoundRobin {
+			if c, err := r.Cookie(turnCookie); err == nil {
+				sel.turn, _ = strconv.ParseUint(c.Value, 10, 64)
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     turnCookie,
+				Value:    strconv.FormatUint(sel.turn+1, 10),
+				Path:     "/api/preview-sources",
+				MaxAge:   int((30 * 24 * time.Hour).Seconds()),
+				Secure:   r.TLS != nil,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		sources, tracks, variants := previewSourcesForPalette(index.Catalog(), probe, palette, sel)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		payload := map[string]interface{}{
+			"palette":  palette,
+			"strategy": sel.strategy,
+			"sources":  sources,
+			"tracks":   tracks,
+		}
+		if sel.all {
+			payload["variants"] = variants
+		}
+		json.NewEncoder(w).Encode(payload)
+	}
 }
 
 func handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -396,72 +1091,247 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func previewSourcesForPalette(musicDir, palette string) (map[string]string, error) {
-	sources := map[string]string{
-		"open":    "",
-		"peak":    "",
-		"offpeak": "",
-		"close":   "",
-		"beacon":  "",
-	}
+// paletteSummary is one entry of the /api/palettes response.
+type paletteSummary struct {
+	Key          string   `json:"key"`
+	Name         string   `json:"name"`
+	Description  string   `json:"description,omitempty"`
+	Phases       []string `json:"phases"`
+	Beacon       bool     `json:"beacon"`
+	Missing      []string `json:"missing"`
+	Completeness float64  `json:"completeness"`
+	Complete     bool     `json:"complete"`
+}
 
-	entries, err := os.ReadDir(musicDir)
-	if err != nil {
-		return nil, err
+func handlePalettes(index *catalog.Index) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		palettes := make([]paletteSummary, 0)
+		for _, p := range index.Catalog().Palettes() {
+			palettes = append(palettes, paletteSummary{
+				Key:          p.Key,
+				Name:         p.Name,
+				Description:  p.Description,
+				Phases:       p.Phases,
+				Beacon:       p.Beacon,
+				Missing:      p.Missing,
+				Completeness: p.Completeness,
+				Complete:     p.Complete(),
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"palettes": palettes,
+		})
 	}
+}
 
-	for _, entry := range entries {
-		if entry.IsDir() {
+// precomputeWaveforms fills the waveform cache in the background so cards
+// rarely wait on a first computation.
+func precomputeWaveforms(waveforms *waveform.Store, cat *catalog.Catalog) {
+	start := time.Now()
+	computed := 0
+	for _, t := range cat.Tracks {
+		if _, _, err := waveforms.Get(t.File); err != nil {
+			slog.Warn("WAVEFORM: failed to precompute", "track", t.File, "err", err)
 			continue
 		}
+		computed++
+	}
+	slog.Info("WAVEFORM: precomputed", "tracks", computed, "elapsed", time.Since(start))
+}
 
-		entryPalette, trackKey, ok := parsePreviewTrackFilename(entry.Name())
-		if !ok || entryPalette != palette {
-			continue
+// handleWaveform serves the envelope of one catalog track, either every
+// resolution or the one named by points. Entries are keyed by the file's
+// content hash, which doubles as the ETag.
+func handleWaveform(index *catalog.Index, waveforms *waveform.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
 		}
 
-		sources[trackKey] = "/music/" + url.PathEscape(entry.Name())
-	}
+		track := r.URL.Query().Get("track")
+		if track == "" {
+			http.Error(w, "Missing track query parameter", http.StatusBadRequest)
+			return
+		}
+		points := 0
+		if v := r.URL.Query().Get("points"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || !slices.Contains(waveform.Resolutions, n) {
+				http.Error(w, fmt.Sprintf("points must be one of %v", waveform.Resolutions), http.StatusBadRequest)
+				return
+			}
+			points = n
+		}
 
-	return sources, nil
-}
+		// Only catalogued tracks are computed, so the endpoint cannot be
+		// used to make the server parse arbitrary files.
+		if _, ok := index.Catalog().Track(track); !ok {
+			http.Error(w, "Unknown track", http.StatusNotFound)
+			return
+		}
 
-func parsePreviewTrackFilename(filename string) (palette string, trackKey string, ok bool) {
-	ext := strings.ToLower(filepath.Ext(filename))
-	if ext != ".m4a" {
-		return "", "", false
-	}
+		wf, hash, err := waveforms.Get(track)
+		if err != nil {
+			if wf == nil {
+				logging.FromContext(r.Context()).Error("WAVEFORM ERROR", "track", track, "err", err)
+				http.Error(w, "Failed to compute waveform", http.StatusInternalServerError)
+				return
+			}
+			logging.FromContext(r.Context()).Warn("WAVEFORM: not cached", "track", track, "err", err)
+		}
 
-	base := strings.TrimSuffix(filename, filepath.Ext(filename))
-	parts := strings.SplitN(base, "_", 2)
-	if len(parts) != 2 {
-		return "", "", false
-	}
+		series := wf.Series
+		if points != 0 {
+			s, ok := wf.Resolution(points)
+			if !ok {
+				// Tracks with fewer frames than points have a coarser
+				// series only.
+				s = wf.Series[len(wf.Series)-1]
+			}
+			series = []waveform.Series{s}
+		}
 
-	palette = normalizePalette(parts[0])
-	if palette == "" {
-		return "", "", false
+		etag := fmt.Sprintf(`"%s-v%d-%d"`, hash[:32], waveform.Version, points)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "no-cache")
+		if match := r.Header.Get("If-None-Match"); match != "" && (match == "*" || slices.Contains(strings.Split(match, ", "), etag)) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodHead {
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"track":            track,
+			"version":          wf.Version,
+			"method":           wf.Method,
+			"duration_seconds": wf.Duration,
+			"frames":           wf.Frames,
+			"series":           series,
+		})
 	}
+}
 
-	suffix := strings.ToUpper(parts[1])
-	switch {
-	case suffix == "SONARE":
-		return palette, "beacon", true
-	case strings.HasPrefix(suffix, "OPEN-"):
-		return palette, "open", true
-	case strings.HasPrefix(suffix, "PEAK-"):
-		return palette, "peak", true
-	case strings.HasPrefix(suffix, "OFFPEAK-"):
-		return palette, "offpeak", true
-	case strings.HasPrefix(suffix, "CLOSE-"):
-		return palette, "close", true
-	default:
-		return "", "", false
+// previewTrack is the metadata sent alongside each preview source.
+type previewTrack struct {
+	URL             string           `json:"url"`
+	Title           string           `json:"title"`
+	DurationSeconds float64          `json:"duration_seconds,omitempty"`
+	BPM             float64          `json:"bpm,omitempty"`
+	Energy          float64          `json:"energy,omitempty"`
+	VocalRatio      float64          `json:"vocal_ratio,omitempty"`
+	License         *catalog.License `json:"license,omitempty"`
+	Variant         string           `json:"variant"`
+	// Waveform is the /api/waveform URL of the track's envelope.
+	Waveform string `json:"waveform"`
+	// HLS is the track's master playlist, for browsers that stream HLS;
+	// URL stays the progressive download for everyone else.
+	HLS string `json:"hls,omitempty"`
+	// Artist and the audio format are read from the file itself.
+	Artist     string `json:"artist,omitempty"`
+	Codec      string `json:"codec,omitempty"`
+	SampleRate int    `json:"sample_rate,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+	Bitrate    int    `json:"bitrate,omitempty"`
+	// Source says whether the metadata came from the manifest or only
+	// from the file name.
+	Source string `json:"source"`
+}
+
+// previewSelection says how previewSourcesForPalette chooses among the
+// variants of a phase.
+type previewSelection struct {
+	strategy string
+	// turn is the visitor's round-robin counter.
+	turn uint64
+	// all also returns every variant.
+	all bool
+	// hls adds each track's HLS playlist.
+	hls bool
+	// sign, when set, returns the query string that signs a /music or
+	// /hls path.
+	sign func(path string) string
+}
+
+// previewSourcesForPalette returns the URL of the track picked for each
+// phase, with an empty string for phases the palette lacks, and the picked
+// tracks' metadata. With sel.all it also lists every variant per phase.
+// When probe is set, the file's own duration fills in for a manifest that
+// states none, and its format is included.
+func previewSourcesForPalette(cat *catalog.Catalog, probe *mp4.Cache, palette string, sel previewSelection) (map[string]string, map[string]previewTrack, map[string][]previewTrack) {
+	sources := make(map[string]string, len(catalog.Phases))
+	tracks := make(map[string]previewTrack)
+	var variants map[string][]previewTrack
+	if sel.all {
+		variants = make(map[string][]previewTrack, len(catalog.Phases))
+	}
+	for _, phase := range catalog.Phases {
+		sources[phase] = ""
+		candidates := cat.Variants(palette, phase)
+		if sel.all {
+			variants[phase] = make([]previewTrack, 0, len(candidates))
+			for _, t := range candidates {
+				variants[phase] = append(variants[phase], newPreviewTrack(t, probe, sel))
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		pt := newPreviewTrack(catalog.Pick(candidates, sel.strategy, sel.turn), probe, sel)
+		sources[phase] = pt.URL
+		tracks[phase] = pt
 	}
+	return sources, tracks, variants
 }
 
-func normalizePalette(value string) string {
-	return strings.ToLower(strings.TrimSpace(value))
+func newPreviewTrack(t catalog.Track, probe *mp4.Cache, sel previewSelection) previewTrack {
+	pt := previewTrack{
+		URL:             "/music/" + url.PathEscape(t.File),
+		Title:           t.Title,
+		DurationSeconds: t.Duration.Seconds(),
+		BPM:             t.BPM,
+		Energy:          t.Energy,
+		VocalRatio:      t.VocalRatio,
+		Variant:         t.Variant,
+		Source:          t.Source,
+		Waveform:        "/api/waveform?track=" + url.QueryEscape(t.File),
+	}
+	if sel.hls {
+		pt.HLS = "/hls/" + url.PathEscape(t.File) + "/" + hls.MasterName
+	}
+	if sel.sign != nil {
+		pt.URL += "?" + sel.sign("/music/"+t.File)
+		if pt.HLS != "" {
+			pt.HLS += "?" + sel.sign("/hls/"+t.File+"/"+hls.MasterName)
+		}
+	}
+	if t.License != (catalog.License{}) {
+		license := t.License
+		pt.License = &license
+	}
+	if probe != nil {
+		if info, err := probe.Info(t.File); err == nil {
+			if pt.DurationSeconds == 0 {
+				pt.DurationSeconds = info.Duration.Seconds()
+			}
+			pt.Artist = info.Tags.Artist
+			pt.Codec = info.Codec
+			pt.SampleRate = info.SampleRate
+			pt.Channels = info.Channels
+			pt.Bitrate = info.Bitrate
+		}
+	}
+	return pt
 }
 
 func normalizeMode(value string) (string, bool) {
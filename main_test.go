@@ -1,81 +1,27 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
-)
-
-func TestParsePreviewTrackFilename(t *testing.T) {
-	t.Parallel()
+	"testing/fstest"
+	"time"
 
-	tests := []struct {
-		name        string
-		filename    string
-		wantPalette string
-		wantTrack   string
-		wantOK      bool
-	}{
-		{
-			name:        "open phase",
-			filename:    "WARM_OPEN-FirstLight.m4a",
-			wantPalette: "warm",
-			wantTrack:   "open",
-			wantOK:      true,
-		},
-		{
-			name:        "beacon case insensitive",
-			filename:    "modern_sonare.m4a",
-			wantPalette: "modern",
-			wantTrack:   "beacon",
-			wantOK:      true,
-		},
-		{
-			name:        "offpeak with uppercase extension",
-			filename:    "PREMIUM_OFFPEAK-DriftState.M4A",
-			wantPalette: "premium",
-			wantTrack:   "offpeak",
-			wantOK:      true,
-		},
-		{
-			name:     "invalid extension",
-			filename: "WARM_OPEN-FirstLight.mp3",
-			wantOK:   false,
-		},
-		{
-			name:     "missing separator",
-			filename: "WARMOPEN-FirstLight.m4a",
-			wantOK:   false,
-		},
-		{
-			name:     "unknown phase",
-			filename: "WARM_TRANSITION-Rise.m4a",
-			wantOK:   false,
-		},
-	}
-
-	for _, tc := range tests {
-		tc := tc
-		t.Run(tc.name, func(t *testing.T) {
-			t.Parallel()
-
-			gotPalette, gotTrack, gotOK := parsePreviewTrackFilename(tc.filename)
-			if gotOK != tc.wantOK {
-				t.Fatalf("ok mismatch: got=%v want=%v", gotOK, tc.wantOK)
-			}
-			if !tc.wantOK {
-				return
-			}
-			if gotPalette != tc.wantPalette {
-				t.Fatalf("palette mismatch: got=%q want=%q", gotPalette, tc.wantPalette)
-			}
-			if gotTrack != tc.wantTrack {
-				t.Fatalf("track mismatch: got=%q want=%q", gotTrack, tc.wantTrack)
-			}
-		})
-	}
-}
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"sonare.media/internal/catalog"
+	"sonare.media/internal/config"
+	"sonare.media/internal/csp"
+	"sonare.media/internal/hls"
+	"sonare.media/internal/metrics"
+	"sonare.media/internal/waveform"
+)
 
 func TestPreviewSourcesForPalette(t *testing.T) {
 	t.Parallel()
@@ -84,6 +30,7 @@ func TestPreviewSourcesForPalette(t *testing.T) {
 	files := []string{
 		"WARM_OPEN-FirstLight.m4a",
 		"WARM_PEAK-CoreFlow.m4a",
+		"WARM_PEAK-CoreFlow2.m4a",
 		"WARM_OFFPEAK-DriftState.m4a",
 		"WARM_CLOSE-LastCall.m4a",
 		"WARM_Sonare.m4a",
@@ -98,10 +45,11 @@ func TestPreviewSourcesForPalette(t *testing.T) {
 		}
 	}
 
-	got, err := previewSourcesForPalette(musicDir, "warm")
+	cat, err := catalog.Load(os.DirFS(musicDir))
 	if err != nil {
-		t.Fatalf("previewSourcesForPalette returned error: %v", err)
+		t.Fatalf("load catalog: %v", err)
 	}
+	got, tracks, variants := previewSourcesForPalette(cat, nil, "warm", previewSelection{strategy: catalog.StrategyFirst, all: true})
 
 	want := map[string]string{
 		"open":    "/music/WARM_OPEN-FirstLight.m4a",
@@ -114,6 +62,288 @@ func TestPreviewSourcesForPalette(t *testing.T) {
 	if !reflect.DeepEqual(got, want) {
 		t.Fatalf("sources mismatch:\n got: %#v\nwant: %#v", got, want)
 	}
+	if open := tracks["open"]; open.Title != "First Light" || open.URL != want["open"] || open.Source != "filename" {
+		t.Fatalf("open track mismatch: got=%+v", open)
+	}
+	if peak := variants["peak"]; len(peak) != 2 || peak[1].Variant != "CoreFlow2" {
+		t.Fatalf("peak variants mismatch: got=%+v", peak)
+	}
+	if beacon := variants["beacon"]; len(beacon) != 1 || beacon[0].URL != want["beacon"] {
+		t.Fatalf("beacon variants mismatch: got=%+v", beacon)
+	}
+}
+
+func TestPreviewSourcesRoundRobinPerVisitor(t *testing.T) {
+	t.Parallel()
+
+	music := fstest.MapFS{
+		"WARM_PEAK-CoreFlow.m4a":  {Data: []byte("x")},
+		"WARM_PEAK-CoreFlow2.m4a": {Data: []byte("x")},
+	}
+	index := catalog.NewIndex(music, time.Second, nil)
+	if _, err := index.Rescan(); err != nil {
+		t.Fatalf("rescan: %v", err)
+	}
+	handler := handlePreviewSources(newLiveConfig(config.Default()), index, nil)
+
+	var cookies []*http.Cookie
+	for _, want := range []string{"CoreFlow", "CoreFlow2", "CoreFlow"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/preview-sources?palette=warm&strategy=round-robin", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		var body struct {
+			Tracks map[string]previewTrack `json:"tracks"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if got := body.Tracks["peak"].Variant; got != want {
+			t.Fatalf("variant mismatch: got=%q want=%q", got, want)
+		}
+		cookies = rec.Result().Cookies()
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/preview-sources?palette=warm&strategy=loudest", nil)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown strategy status mismatch: got=%d want=%d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestSignedMusicURLs(t *testing.T) {
+	t.Parallel()
+
+	music := fstest.MapFS{"WARM_PEAK-Core Flow.m4a": {Data: []byte("x")}}
+	index := catalog.NewIndex(music, time.Second, nil)
+	if _, err := index.Rescan(); err != nil {
+		t.Fatalf("rescan: %v", err)
+	}
+	cfg := config.Default()
+	cfg.Security.SignedURLs.Enabled = true
+	cfg.Security.SignedURLs.Keys = []string{"k1:" + strings.Repeat("s", 32)}
+	cfg.Security.SignedURLs.BindSession = true
+	live := newLiveConfig(cfg)
+	files := signedMusicMiddleware(live, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rec := httptest.NewRecorder()
+	handlePreviewSources(live, index, nil)(rec, httptest.NewRequest(http.MethodGet, "/api/preview-sources?palette=warm", nil))
+	var body struct {
+		Tracks map[string]previewTrack `json:"tracks"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	signed := body.Tracks["peak"].URL
+	if !strings.HasPrefix(signed, "/music/WARM_PEAK-Core%20Flow.m4a?") {
+		t.Fatalf("signed URL mismatch: got=%q", signed)
+	}
+	var session *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionCookie {
+			session = c
+		}
+	}
+	if session == nil || !session.HttpOnly {
+		t.Fatalf("session cookie mismatch: got=%v", session)
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		session *http.Cookie
+		want    int
+	}{
+		{name: "signed", target: signed, session: session, want: http.StatusOK},
+		{name: "other session", target: signed, session: &http.Cookie{Name: sessionCookie, Value: "other"}, want: http.StatusForbidden},
+		{name: "unsigned", target: "/music/WARM_PEAK-Core%20Flow.m4a", session: session, want: http.StatusForbidden},
+		{name: "outside music", target: "/index.html", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.session != nil {
+			req.AddCookie(tt.session)
+		}
+		rec := httptest.NewRecorder()
+		files.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status mismatch: got=%d want=%d", tt.name, rec.Code, tt.want)
+		}
+		if tt.name == "signed" && !strings.HasPrefix(rec.Header().Get("Cache-Control"), "private, max-age=") {
+			t.Errorf("cache-control mismatch: got=%q", rec.Header().Get("Cache-Control"))
+		}
+	}
+}
+
+func TestHandleHLSFollowsSignedPlaylists(t *testing.T) {
+	t.Parallel()
+
+	data, err := os.ReadFile("web/music/WARM_Sonare.m4a")
+	if err != nil {
+		t.Fatalf("read preview: %v", err)
+	}
+	music := fstest.MapFS{"WARM_Sonare.m4a": {Data: data}}
+	index := catalog.NewIndex(music, time.Second, nil)
+	if _, err := index.Rescan(); err != nil {
+		t.Fatalf("rescan: %v", err)
+	}
+	cfg := config.Default()
+	cfg.Security.SignedURLs.Enabled = true
+	cfg.Security.SignedURLs.Keys = []string{"k1:" + strings.Repeat("s", 32)}
+	live := newLiveConfig(cfg)
+	handler := signedMusicMiddleware(live, handleHLS(live, index, hls.NewPackager(music, 2*time.Second)))
+
+	rec := httptest.NewRecorder()
+	handlePreviewSources(live, index, nil)(rec, httptest.NewRequest(http.MethodGet, "/api/preview-sources?palette=warm", nil))
+	var body struct {
+		Tracks map[string]previewTrack `json:"tracks"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	get := func(target string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	// next resolves the first URI of a playlist (after the EXT-X-MAP) against
+	// the playlist's URL.
+	next := func(base, playlist string, skipMap bool) string {
+		for _, line := range strings.Split(playlist, "\n") {
+			if uri, ok := strings.CutPrefix(line, "#EXT-X-MAP:URI="); ok && !skipMap {
+				line = strings.Trim(uri, `"`)
+			} else if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			dir, _, _ := strings.Cut(base, "?")
+			return dir[:strings.LastIndex(dir, "/")+1] + line
+		}
+		t.Fatalf("no URI in playlist:\n%s", playlist)
+		return ""
+	}
+
+	master := get(body.Tracks["beacon"].HLS, nil)
+	if master.Code != http.StatusOK || master.Header().Get("Content-Type") != hls.PlaylistType {
+		t.Fatalf("master playlist mismatch: got=%d %q", master.Code, master.Header().Get("Content-Type"))
+	}
+	mediaURL := next(body.Tracks["beacon"].HLS, master.Body.String(), false)
+	media := get(mediaURL, nil)
+	if media.Code != http.StatusOK {
+		t.Fatalf("media playlist status mismatch: got=%d want=%d", media.Code, http.StatusOK)
+	}
+	initURL := next(mediaURL, media.Body.String(), false)
+	if rec := get(initURL, nil); rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != hls.SegmentType {
+		t.Errorf("init segment mismatch: got=%d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	segment := strings.Replace(initURL, hls.InitName, hls.SegmentName(0), 1)
+	if rec := get(segment, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("segment with the init signature status mismatch: got=%d want=%d", rec.Code, http.StatusForbidden)
+	}
+	segment = next(mediaURL, media.Body.String(), true)
+	rec = get(segment, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == "" {
+		t.Fatalf("segment mismatch: got=%d etag=%q", rec.Code, rec.Header().Get("ETag"))
+	}
+	if rec := get(segment, http.Header{"If-None-Match": {rec.Header().Get("ETag")}}); rec.Code != http.StatusNotModified {
+		t.Errorf("revalidated segment status mismatch: got=%d want=%d", rec.Code, http.StatusNotModified)
+	}
+}
+
+func TestAdminTracks(t *testing.T) {
+	t.Parallel()
+
+	audio, err := os.ReadFile("web/music/WARM_Sonare.m4a")
+	if err != nil {
+		t.Fatalf("read preview: %v", err)
+	}
+	dir := t.TempDir()
+	music := os.DirFS(dir)
+	// Files the library writes are renamed into place whole, so edits
+	// are served at once even though other files would take an hour to
+	// settle.
+	index := catalog.NewIndex(music, time.Hour, nil)
+	lib := catalog.NewLibrary(dir, music, t.TempDir(), 1<<20)
+	const token = "0123456789abcdef"
+	handler := requireAdminToken(token, handleAdminTracks(index, lib, 1<<20))
+
+	upload := func(method, target string, fields map[string]string, audio []byte) *http.Request {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for k, v := range fields {
+			mw.WriteField(k, v)
+		}
+		if audio != nil {
+			part, _ := mw.CreateFormFile("audio", "upload.m4a")
+			part.Write(audio)
+		}
+		mw.Close()
+		req := httptest.NewRequest(method, target, &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return req
+	}
+	do := func(req *http.Request, auth string) *httptest.ResponseRecorder {
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		auth string
+		want int
+	}{
+		{name: "no token", req: httptest.NewRequest(http.MethodGet, "/admin/tracks", nil), want: http.StatusUnauthorized},
+		{name: "wrong token", req: httptest.NewRequest(http.MethodGet, "/admin/tracks", nil), auth: "fedcba9876543210", want: http.StatusUnauthorized},
+		{name: "upload", req: upload(http.MethodPost, "/admin/tracks", map[string]string{"palette": "lounge", "phase": "peak", "title": "Night Swim", "bpm": "104"}, audio), auth: token, want: http.StatusCreated},
+		{name: "upload again", req: upload(http.MethodPost, "/admin/tracks", map[string]string{"palette": "lounge", "phase": "peak", "variant": "NightSwim"}, audio), auth: token, want: http.StatusConflict},
+		{name: "upload text", req: upload(http.MethodPost, "/admin/tracks", map[string]string{"palette": "lounge", "phase": "open", "variant": "Notes"}, []byte("not an mp4 file")), auth: token, want: http.StatusBadRequest},
+		{name: "upload unknown field", req: upload(http.MethodPost, "/admin/tracks", map[string]string{"palette": "lounge", "phase": "open", "variant": "A", "tempo": "90"}, audio), auth: token, want: http.StatusBadRequest},
+		{name: "update", req: upload(http.MethodPut, "/admin/tracks/LOUNGE_PEAK-NightSwim.m4a", map[string]string{"energy": "0.7"}, nil), auth: token, want: http.StatusOK},
+		{name: "update unknown", req: upload(http.MethodPut, "/admin/tracks/LOUNGE_OPEN-Nope.m4a", map[string]string{"energy": "0.7"}, nil), auth: token, want: http.StatusNotFound},
+		{name: "list", req: httptest.NewRequest(http.MethodGet, "/admin/tracks", nil), auth: token, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		if rec := do(tt.req, tt.auth); rec.Code != tt.want {
+			t.Fatalf("%s: status mismatch: got=%d want=%d (%s)", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+
+	got, ok := index.Catalog().Track("LOUNGE_PEAK-NightSwim.m4a")
+	if !ok || got.Title != "Night Swim" || got.BPM != 104 || got.Energy != 0.7 {
+		t.Fatalf("uploaded track mismatch: got=%+v", got)
+	}
+
+	// Replacing only the audio answers with the entry as it now stands.
+	rec := do(upload(http.MethodPut, "/admin/tracks/LOUNGE_PEAK-NightSwim.m4a", nil, audio), token)
+	var replaced catalog.Track
+	if err := json.Unmarshal(rec.Body.Bytes(), &replaced); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("replace mismatch: status=%d err=%v (%s)", rec.Code, err, rec.Body)
+	}
+	if replaced.File != "LOUNGE_PEAK-NightSwim.m4a" || replaced.Title != "Night Swim" || replaced.Energy != 0.7 || replaced.Source != catalog.SourceManifest {
+		t.Errorf("replaced track mismatch: got=%+v", replaced)
+	}
+
+	if rec := do(httptest.NewRequest(http.MethodDelete, "/admin/tracks/LOUNGE_PEAK-NightSwim.m4a", nil), token); rec.Code != http.StatusNoContent {
+		t.Fatalf("retire status mismatch: got=%d want=%d", rec.Code, http.StatusNoContent)
+	}
+	if _, ok := index.Catalog().Track("LOUNGE_PEAK-NightSwim.m4a"); ok {
+		t.Error("retired track still in the catalog")
+	}
 }
 
 func TestPreviewSourcesForPaletteUnknownPalette(t *testing.T) {
@@ -125,10 +355,11 @@ func TestPreviewSourcesForPaletteUnknownPalette(t *testing.T) {
 		t.Fatalf("write test file: %v", err)
 	}
 
-	got, err := previewSourcesForPalette(musicDir, "unknown")
+	cat, err := catalog.Load(os.DirFS(musicDir))
 	if err != nil {
-		t.Fatalf("previewSourcesForPalette returned error: %v", err)
+		t.Fatalf("load catalog: %v", err)
 	}
+	got, tracks, _ := previewSourcesForPalette(cat, nil, "unknown", previewSelection{strategy: catalog.StrategyFirst})
 
 	want := map[string]string{
 		"open":    "",
@@ -141,4 +372,187 @@ func TestPreviewSourcesForPaletteUnknownPalette(t *testing.T) {
 	if !reflect.DeepEqual(got, want) {
 		t.Fatalf("unknown palette mismatch:\n got: %#v\nwant: %#v", got, want)
 	}
+	if len(tracks) != 0 {
+		t.Fatalf("unknown palette tracks mismatch: got=%v", tracks)
+	}
+}
+
+func TestMetricsMiddlewareRecordsRouteAndStatus(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/metrics-probe", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusTeapot)
+	})
+	handler := metricsMiddleware(mux)
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/api/metrics-probe", nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	got := testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("/api/metrics-probe", "GET", "418"))
+	if got != 2 {
+		t.Fatalf("request counter mismatch: got=%v want=2", got)
+	}
+}
+
+func TestSecurityHeadersUseRequestNonce(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Default()
+	cfg.Security.CSPReportOnly = []string{"style-src 'self' 'nonce-{nonce}'"}
+	var nonce string
+	handler := csp.Middleware(securityHeadersMiddleware(newLiveConfig(cfg), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = csp.Nonce(r.Context())
+	})))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	enforced := rec.Header().Get("Content-Security-Policy")
+	for _, want := range []string{"script-src 'self' 'nonce-" + nonce + "'", "report-uri /api/csp-report", "report-to csp-endpoint"} {
+		if !strings.Contains(enforced, want) {
+			t.Errorf("policy missing %q: %s", want, enforced)
+		}
+	}
+	if strings.Contains(enforced, "script-src 'self' 'unsafe-inline'") {
+		t.Errorf("policy still allows inline script: %s", enforced)
+	}
+	if got, want := rec.Header().Get("Content-Security-Policy-Report-Only"), "style-src 'self' 'nonce-"+nonce+"'; report-uri /api/csp-report; report-to csp-endpoint"; got != want {
+		t.Errorf("report-only mismatch: got=%q want=%q", got, want)
+	}
+	if got, want := rec.Header().Get("Reporting-Endpoints"), `csp-endpoint="/api/csp-report"`; got != want {
+		t.Errorf("reporting-endpoints mismatch: got=%q want=%q", got, want)
+	}
+}
+
+func TestHandleCSPReportRejectsUnknownType(t *testing.T) {
+	t.Parallel()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/csp-report", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	handleCSPReport(csp.NewLimiter(cspReportsPerMinute, cspReportBurst))(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("status mismatch: got=%d want=%d", rec.Code, http.StatusUnsupportedMediaType)
+	}
+}
+
+func TestHandleCSPReportLimitsClients(t *testing.T) {
+	t.Parallel()
+
+	handler := handleCSPReport(csp.NewLimiter(cspReportsPerMinute, 2))
+	post := func(addr string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/csp-report", strings.NewReader("{}"))
+		req.RemoteAddr = addr
+		// A forged header must not buy a fresh allowance.
+		req.Header.Set("X-Forwarded-For", addr)
+		req.Header.Set("Content-Type", "text/plain")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+	for i, tt := range []struct {
+		addr string
+		want int
+	}{
+		{"198.51.100.7:4000", http.StatusUnsupportedMediaType},
+		{"198.51.100.7:4001", http.StatusUnsupportedMediaType},
+		{"198.51.100.7:4002", http.StatusTooManyRequests},
+		{"198.51.100.8:4000", http.StatusUnsupportedMediaType},
+	} {
+		if got := post(tt.addr); got != tt.want {
+			t.Errorf("request %d from %s mismatch: got=%d want=%d", i, tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestRunCatalogCheck(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Default().Catalog
+	var out strings.Builder
+	// The shipped manifest marks the palettes still missing phases as
+	// incomplete, so releases can gate on the check.
+	if code := runCatalogCheck(&out, os.DirFS("web/music"), cfg); code != 0 {
+		t.Errorf("shipped catalog exit mismatch: got=%d want=0\n%s", code, out.String())
+	}
+	if !strings.Contains(out.String(), "warning: no peak track (palette marked incomplete)") {
+		t.Errorf("shipped catalog should warn about missing phases:\n%s", out.String())
+	}
+
+	musicDir := t.TempDir()
+	files := map[string]string{
+		"catalog.yaml":             "palettes:\n  calm:\n    incomplete: true\ntracks:\n  - file: warm-alt.m4a\n    palette: warm\n    phase: open\n    variant: firstlight\n",
+		"WARM_OPEN-FirstLight.m4a": "not audio",
+		"warm-alt.m4a":             "not audio",
+		"WARM_PEAK-CoreFlow.m4a":   "",
+		"WARM_TRANSITION-Rise.m4a": "x",
+		"notes.txt":                "x",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(musicDir, name), []byte(data), 0o644); err != nil {
+			t.Fatalf("write %q: %v", name, err)
+		}
+	}
+	out.Reset()
+	if code := runCatalogCheck(&out, os.DirFS(musicDir), cfg); code != 1 {
+		t.Fatalf("broken catalog exit mismatch: got=%d want=1\n%s", code, out.String())
+	}
+	for _, want := range []string{
+		"error: WARM_TRANSITION-Rise.m4a: name does not match",
+		"warning: notes.txt: not a preview track",
+		"error: not an MP4 file",
+		"error: empty file",
+		`error: 2 open tracks share the variant "firstlight": WARM_OPEN-FirstLight.m4a, warm-alt.m4a`,
+		"error: no offpeak track",
+		"error: no beacon track",
+		"warning: no open track (palette marked incomplete)",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %q in output:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestHandleWaveform(t *testing.T) {
+	t.Parallel()
+
+	music := os.DirFS("web/music")
+	index := catalog.NewIndex(music, time.Second, nil)
+	if _, err := index.Rescan(); err != nil {
+		t.Fatalf("rescan: %v", err)
+	}
+	handler := handleWaveform(index, waveform.NewStore(music, ""))
+	get := func(query, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/waveform?"+query, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	for query, want := range map[string]int{
+		"":                                   http.StatusBadRequest,
+		"track=catalog.yaml":                 http.StatusNotFound,
+		"track=../main.go":                   http.StatusNotFound,
+		"track=CALM_Sonare.m4a&points=100":   http.StatusBadRequest,
+		"track=CALM_Sonare.m4a&points=64":    http.StatusOK,
+		"track=CALM_OPEN-FirstLight.m4a&x=1": http.StatusOK,
+	} {
+		if rec := get(query, ""); rec.Code != want {
+			t.Errorf("%q status mismatch: got=%d want=%d", query, rec.Code, want)
+		}
+	}
+
+	rec := get("track=CALM_Sonare.m4a&points=64", "")
+	etag := rec.Header().Get("ETag")
+	if etag == "" || !strings.Contains(rec.Body.String(), `"points":64`) {
+		t.Fatalf("response mismatch: etag=%q body=%s", etag, rec.Body.String())
+	}
+	if rec := get("track=CALM_Sonare.m4a&points=64", etag); rec.Code != http.StatusNotModified {
+		t.Errorf("revalidation status mismatch: got=%d want=%d", rec.Code, http.StatusNotModified)
+	}
 }
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+
+	"sonare.media/internal/config"
+)
+
+// dropPrivileges is only supported on Unix.
+func dropPrivileges(runAs config.RunAs) error {
+	if runAs.User != "" {
+		return fmt.Errorf("run_as.user is not supported on this platform")
+	}
+	return nil
+}
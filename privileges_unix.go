@@ -0,0 +1,71 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"sonare.media/internal/config"
+)
+
+// dropPrivileges switches a root process to run_as.user and its primary
+// group (or run_as.group). Since Go 1.16 the set*id calls apply to every
+// thread, so nothing started earlier keeps root.
+func dropPrivileges(runAs config.RunAs) error {
+	if os.Geteuid() != 0 {
+		if runAs.User != "" {
+			slog.Info("PRIVILEGES: not running as root; run_as.user is not needed", "user", runAs.User)
+		}
+		return nil
+	}
+	if runAs.User == "" {
+		slog.Warn("PRIVILEGES: serving as root; set run_as.user to drop privileges after binding")
+		return nil
+	}
+
+	u, err := user.Lookup(runAs.User)
+	if err != nil {
+		return fmt.Errorf("run_as.user: %w", err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("run_as.user: uid %q: %w", u.Uid, err)
+	}
+	gidStr := u.Gid
+	if runAs.Group != "" {
+		g, err := user.LookupGroup(runAs.Group)
+		if err != nil {
+			return fmt.Errorf("run_as.group: %w", err)
+		}
+		gidStr = g.Gid
+	}
+	gid, err := strconv.Atoi(gidStr)
+	if err != nil {
+		return fmt.Errorf("run_as.group: gid %q: %w", gidStr, err)
+	}
+	if uid == 0 {
+		return fmt.Errorf("run_as.user %q is root", runAs.User)
+	}
+
+	// Group changes must come first; they need root.
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid %d: %w", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid %d: %w", uid, err)
+	}
+	if syscall.Setuid(0) == nil {
+		return fmt.Errorf("privileges could not be dropped permanently")
+	}
+
+	slog.Info("PRIVILEGES: dropped", "user", u.Username, "uid", uid, "gid", gid)
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io/fs"
+	"log/slog"
+	"reflect"
+	"sync/atomic"
+
+	"sonare.media/internal/catalog"
+	"sonare.media/internal/certs"
+	"sonare.media/internal/config"
+	"sonare.media/internal/logging"
+	"sonare.media/internal/metrics"
+	"sonare.media/internal/signedurl"
+)
+
+// liveConfig is the configuration that SIGHUP may replace while serving.
+// Handlers read it per request through Load.
+type liveConfig struct {
+	p atomic.Pointer[liveState]
+}
+
+// liveState is one configuration and what is built from it up front
+// rather than per request.
+type liveState struct {
+	cfg       config.Config
+	signer    *signedurl.Signer
+	signerErr error
+}
+
+func newLiveConfig(cfg config.Config) *liveConfig {
+	l := &liveConfig{}
+	l.Store(cfg)
+	return l
+}
+
+func (l *liveConfig) Load() *config.Config {
+	return &l.p.Load().cfg
+}
+
+// Store replaces the configuration, building its URL signer.
+func (l *liveConfig) Store(cfg config.Config) {
+	s := &liveState{cfg: cfg}
+	if su := cfg.Security.SignedURLs; su.Enabled {
+		s.signer, s.signerErr = newSigner(su)
+	}
+	l.p.Store(s)
+}
+
+// Signer returns the signed URL settings and the signer built from them,
+// both from the same configuration. The signer is nil while signing is
+// disabled.
+func (l *liveConfig) Signer() (config.SignedURLs, *signedurl.Signer, error) {
+	s := l.p.Load()
+	return s.cfg.Security.SignedURLs, s.signer, s.signerErr
+}
+
+// reloader applies SIGHUP: re-read the config, swap the certificate,
+// reopen log files and rescan the previews. Listeners are untouched, so
+// in-flight connections carry on.
+type reloader struct {
+	configPath string
+	live       *liveConfig
+	logger     *logging.Logger
+	certs      *certs.Reloader // nil when this process does not terminate TLS
+	music      fs.FS
+	index      *catalog.Index
+}
+
+func (rl *reloader) reload() {
+	slog.Info("RELOAD: SIGHUP received")
+
+	current := rl.live.Load()
+	next, err := loadConfig(rl.configPath)
+	if err == nil {
+		err = next.Validate()
+	}
+	if err != nil {
+		slog.Error("RELOAD: config rejected, keeping the running configuration", "err", err)
+	} else {
+		merged, ignored := mergeReloadable(*current, next)
+		if ignored {
+			slog.Warn("RELOAD: some changed settings only take effect after a restart (listeners, database, log files, tracing, ACME, catalog storage)")
+		}
+		if err := rl.logger.SetLevel(merged.Log.Level); err != nil {
+			slog.Error("RELOAD: log level not applied", "err", err)
+		}
+		rl.live.Store(merged)
+		current = &merged
+	}
+
+	if err := rl.logger.Reopen(); err != nil {
+		slog.Error("RELOAD: reopening log files failed", "err", err)
+	}
+
+	if rl.certs != nil {
+		leaf, err := rl.certs.Load(current.TLS.CertFile, current.TLS.KeyFile)
+		if err != nil {
+			slog.Error("RELOAD: certificate rejected, still serving the previous one", "err", err)
+		} else {
+			metrics.SetCertExpiry(current.TLS.CertFile, leaf.NotAfter)
+			slog.Info("RELOAD: certificate loaded", "subject", leaf.Subject.String(), "not_after", leaf.NotAfter)
+		}
+	}
+
+	if err := rl.index.Refresh(); err == nil {
+		status, detail := checkMusicDir(rl.music)
+		slog.Info("RELOAD: music catalog rescanned", "status", status, "detail", detail)
+	}
+}
+
+// mergeReloadable takes the settings that can change at runtime from next
+// and keeps everything else from current. ignored reports whether next
+// differed in any setting that was kept.
+func mergeReloadable(current, next config.Config) (merged config.Config, ignored bool) {
+	merged = current
+	merged.Log.Level = next.Log.Level
+	merged.TLS.CertFile = next.TLS.CertFile
+	merged.TLS.KeyFile = next.TLS.KeyFile
+	merged.Security = next.Security
+	merged.Cache = next.Cache
+	merged.Catalog.Strategy = next.Catalog.Strategy
+
+	pinned := next
+	pinned.Log.Level = current.Log.Level
+	pinned.TLS.CertFile = current.TLS.CertFile
+	pinned.TLS.KeyFile = current.TLS.KeyFile
+	pinned.Security = current.Security
+	pinned.Cache = current.Cache
+	pinned.Catalog.Strategy = current.Catalog.Strategy
+
+	return merged, !reflect.DeepEqual(pinned, current)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"sonare.media/internal/config"
+	"sonare.media/internal/signedurl"
+)
+
+func TestMergeReloadable(t *testing.T) {
+	t.Parallel()
+
+	current := config.Default()
+
+	next := config.Default()
+	next.Log.Level = "debug"
+	next.Cache.Music = time.Hour
+	next.TLS.CertFile = "certs/renewed.crt"
+	next.Catalog.Strategy = "round-robin"
+
+	merged, ignored := mergeReloadable(current, next)
+	if ignored {
+		t.Fatal("only reloadable settings changed, nothing should be ignored")
+	}
+	if merged.Log.Level != "debug" || merged.Cache.Music != time.Hour || merged.TLS.CertFile != "certs/renewed.crt" ||
+		merged.Catalog.Strategy != "round-robin" {
+		t.Fatalf("reloadable settings not applied: %+v", merged)
+	}
+
+	next.Port = "9999"
+	next.DB = "other.db"
+	merged, ignored = mergeReloadable(current, next)
+	if !ignored {
+		t.Fatal("port and db changes should be reported as ignored")
+	}
+	if merged.Port != current.Port || merged.DB != current.DB {
+		t.Fatalf("restart-only settings changed at runtime: port=%q db=%q", merged.Port, merged.DB)
+	}
+}
+
+func TestLiveConfigRebuildsSignerOnStore(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Default()
+	live := newLiveConfig(cfg)
+	if _, signer, err := live.Signer(); signer != nil || err != nil {
+		t.Fatalf("disabled signer mismatch: got=%v err=%v want=nil", signer, err)
+	}
+
+	cfg.Security.SignedURLs.Enabled = true
+	cfg.Security.SignedURLs.Keys = []string{"k1:" + strings.Repeat("s", 32)}
+	live.Store(cfg)
+	_, first, err := live.Signer()
+	if first == nil || err != nil {
+		t.Fatalf("enabled signer mismatch: got=%v err=%v", first, err)
+	}
+	if _, again, _ := live.Signer(); again != first {
+		t.Error("signer rebuilt without a new configuration")
+	}
+
+	// Rotating keys takes effect with the next configuration.
+	cfg.Security.SignedURLs.Keys = []string{"k2:" + strings.Repeat("t", 32)}
+	live.Store(cfg)
+	_, rotated, _ := live.Signer()
+	expires := time.Now().Add(time.Minute)
+	if rotated == first || !strings.Contains(rotated.Sign("/music/a.m4a", expires, ""), signedurl.ParamKey+"=k2") {
+		t.Error("signer not rebuilt from the stored configuration")
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// notifyUpgrade is a no-op: graceful upgrades need Unix descriptor passing.
+func notifyUpgrade(chan<- os.Signal) {}
+
+func upgradeBinary(*serverListeners, time.Duration) (int, error) {
+	return 0, errors.New("graceful upgrades are not supported on this platform")
+}
+
+func notifyUpgradeReady() error { return nil }
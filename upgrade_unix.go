@@ -0,0 +1,127 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"sonare.media/internal/listeners"
+)
+
+// envUpgradeReadyFD names the pipe a process started by upgradeBinary
+// writes to once it is serving. It must not carry the SONARE_ prefix,
+// which config reserves for settings.
+const envUpgradeReadyFD = "UPGRADE_READY_FD"
+
+// notifyUpgrade relays the graceful upgrade signal.
+func notifyUpgrade(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
+
+// upgradeBinary starts the executable at our own path, which a deploy has
+// replaced, handing it every listener through LISTEN_FDS. It returns once
+// the new process reports ready; on any failure the new process is killed
+// and the caller keeps serving.
+func upgradeBinary(l *serverListeners, timeout time.Duration) (pid int, err error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("locate executable: %w", err)
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	var names []string
+	for _, nl := range l.named() {
+		tcp, ok := nl.ln.(*net.TCPListener)
+		if !ok {
+			return 0, fmt.Errorf("listener %s cannot be handed over (%T)", nl.name, nl.ln)
+		}
+		f, err := tcp.File()
+		if err != nil {
+			return 0, fmt.Errorf("listener %s: %w", nl.name, err)
+		}
+		files = append(files, f)
+		names = append(names, nl.name)
+	}
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return 0, fmt.Errorf("ready pipe: %w", err)
+	}
+	defer ready.Close()
+	files = append(files, readyW)
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		listeners.EnvFDs+"="+strconv.Itoa(len(names)),
+		listeners.EnvNames+"="+strings.Join(names, ":"),
+		envUpgradeReadyFD+"="+strconv.Itoa(3+len(names)),
+	)
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("start %s: %w", exe, err)
+	}
+	// Only the child may hold the write end, so its exit shows up as EOF.
+	readyW.Close()
+	files = files[:len(files)-1]
+
+	result := make(chan error, 1)
+	go func() {
+		var b [1]byte
+		if _, err := ready.Read(b[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = errors.New("new process exited before reporting ready")
+			}
+			result <- err
+			return
+		}
+		result <- nil
+	}()
+
+	select {
+	case err = <-result:
+	case <-time.After(timeout):
+		err = fmt.Errorf("new process not ready after %s", timeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, err
+	}
+	go cmd.Wait()
+	return cmd.Process.Pid, nil
+}
+
+// notifyUpgradeReady tells the process that started us through
+// upgradeBinary that we are serving. It is a no-op otherwise.
+func notifyUpgradeReady() error {
+	v := os.Getenv(envUpgradeReadyFD)
+	if v == "" {
+		return nil
+	}
+	os.Unsetenv(envUpgradeReadyFD)
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("%s=%q: %w", envUpgradeReadyFD, v, err)
+	}
+	f := os.NewFile(uintptr(fd), "upgrade-ready")
+	defer f.Close()
+	_, err = f.Write([]byte{'1'})
+	return err
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+	"time"
+
+	"sonare.media/internal/webfs"
+)
+
+// embeddedWeb is the site as of the build, so the binary serves correctly
+// whatever its working directory. go generate adds precompressed siblings
+// of the text assets before they are embedded.
+//
+//go:generate go run ./cmd/precompress web
+//go:embed web
+var embeddedWeb embed.FS
+
+// siteFS returns the embedded site with dir, when set, overlaid on top for
+// local development.
+func siteFS(dir string) (fs.FS, error) {
+	site, err := fs.Sub(embeddedWeb, "web")
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return site, nil
+	}
+	return webfs.Overlay(os.DirFS(dir), site), nil
+}
+
+// musicFS returns the music directory of siteFS(dir).
+func musicFS(dir string) (fs.FS, error) {
+	site, err := siteFS(dir)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Sub(site, "music")
+}
+
+// buildTime stands in for the modification time of embedded files, which
+// have none, so Last-Modified only changes when the binary does.
+func buildTime() time.Time {
+	if exe, err := os.Executable(); err == nil {
+		if info, err := os.Stat(exe); err == nil {
+			return info.ModTime()
+		}
+	}
+	return time.Now()
+}
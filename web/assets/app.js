@@ -299,12 +299,19 @@
                 ctx: null,
                 els: new Map(),     // key -> { btn, wave, labelEl, metaEl }
                 currentKey: null,
+                trackInfo: {},      // key -> metadata from /api/preview-sources
                 rafId: null,
                 pendingSeekPct: null
             };
 
             function getEl(key) { return state.els.get(key) || null; }
 
+            // Duration reported by the server, known before any audio loads.
+            function knownDuration(key) {
+                const info = state.trackInfo[key];
+                return (info && info.duration_seconds > 0) ? info.duration_seconds : 0;
+            }
+
             function setWaveProgress(waveEl, pct) {
                 if (!waveEl) return;
                 const normalized = Math.round(clamp(pct, 0, 100) * 100) / 100;
@@ -340,7 +347,7 @@
                 if (mode === "ready") {
                     btn.setAttribute("aria-pressed", "false");
                     if (label) label.textContent = "▶ Preview";
-                    if (meta) meta.textContent = "00:00 / --:--";
+                    if (meta) meta.textContent = `00:00 / ${knownDuration(key) > 0 ? fmtTime(knownDuration(key)) : "--:--"}`;
                     if (el.wave) {
                         el.wave.classList.add("is-seekable");
                         el.wave.classList.remove("track-active");
@@ -366,6 +373,7 @@
             function setMetaTime(key, t, d) {
                 const el = getEl(key);
                 if (!el || !el.metaEl) return;
+                if (!(d > 0)) d = knownDuration(key);
                 const dur = d > 0 ? fmtTime(d) : "--:--";
                 el.metaEl.textContent = `${fmtTime(t)} / ${dur}`;
             }
@@ -529,13 +537,74 @@
                         el.btn.disabled = false;
                         el.btn.setAttribute("aria-disabled", "false");
                         setBtnMode(key, "ready");
+                        loadWaveform(key, url);
                     } else {
                         el.btn.dataset.src = "";
                         setBtnMode(key, "disabled");
+                        drawWaveform(el.wave, null);
                     }
                 });
             }
 
+            // Waveforms are envelopes precomputed by the server; until one
+            // arrives (or if it never does) the skeleton pattern stays.
+            const WAVEFORM_POINTS = 64;
+
+            async function loadWaveform(key, url) {
+                const el = getEl(key);
+                const info = state.trackInfo[key];
+                if (!el || !info || !info.waveform) {
+                    if (el) drawWaveform(el.wave, null);
+                    return;
+                }
+                try {
+                    const response = await fetch(`${info.waveform}&points=${WAVEFORM_POINTS}`, {
+                        headers: { "Accept": "application/json" }
+                    });
+                    if (!response.ok) throw new Error(`HTTP ${response.status}`);
+                    const payload = await response.json();
+                    // The palette may have changed while the request was out.
+                    if (el.btn.dataset.src !== url) return;
+                    drawWaveform(el.wave, payload && payload.series && payload.series[0]);
+                } catch (e) {
+                    console.warn("Waveform load failed:", key, e);
+                }
+            }
+
+            function drawWaveform(waveEl, series) {
+                if (!waveEl) return;
+                const old = waveEl.querySelector("svg.wave-svg");
+                if (old) old.remove();
+                if (!series || !Array.isArray(series.peaks) || !series.peaks.length) {
+                    waveEl.classList.remove("has-waveform");
+                    return;
+                }
+
+                const ns = "http://www.w3.org/2000/svg";
+                const n = series.peaks.length;
+                const svg = document.createElementNS(ns, "svg");
+                svg.setAttribute("class", "wave-svg");
+                svg.setAttribute("viewBox", `0 0 ${n} 100`);
+                svg.setAttribute("preserveAspectRatio", "none");
+                svg.setAttribute("aria-hidden", "true");
+
+                const bar = (cls, i, v) => {
+                    const h = Math.max(2, clamp(Number(v) || 0, 0, 1) * 100);
+                    const r = document.createElementNS(ns, "rect");
+                    r.setAttribute("class", cls);
+                    r.setAttribute("x", String(i + 0.15));
+                    r.setAttribute("y", String((100 - h) / 2));
+                    r.setAttribute("width", "0.7");
+                    r.setAttribute("height", String(h));
+                    svg.appendChild(r);
+                };
+                series.peaks.forEach((v, i) => bar("wave-peak", i, v));
+                (series.rms || []).forEach((v, i) => bar("wave-rms", i, v));
+
+                waveEl.appendChild(svg);
+                waveEl.classList.add("has-waveform");
+            }
+
             async function maybeRequestSources(ctx) {
                 // Backend may implement this to return URL manifest.
                 // Expected return shape: { open, peak, offpeak, close, beacon }
@@ -585,6 +654,7 @@
                 mount,
                 stop,
                 setSources,
+                setTrackInfo: (info) => { state.trackInfo = (info && typeof info === "object") ? info : {}; },
                 getContext: () => state.ctx,
                 // Backend hook: assign a function here that returns a manifest object.
                 onRequestSources: null
@@ -595,6 +665,7 @@
 
         // --- PREVIEW SOURCE BRIDGE (Go backend) ---
         if (window.SonarePreviewKit) {
+            const NATIVE_HLS = document.createElement("audio").canPlayType("application/vnd.apple.mpegurl") !== "";
             window.SonarePreviewKit.onRequestSources = async (ctx) => {
                 const palette = (ctx && ctx.paletteKey) ? String(ctx.paletteKey).trim().toLowerCase() : "";
                 if (!palette) return {};
@@ -611,6 +682,15 @@
 
                     const payload = await response.json();
                     const sources = (payload && typeof payload.sources === "object" && payload.sources) ? payload.sources : {};
+                    const tracks = (payload && typeof payload.tracks === "object" && payload.tracks) ? payload.tracks : {};
+                    // Browsers with native HLS (Safari, iOS) stream in segments;
+                    // the rest download the progressive file.
+                    if (NATIVE_HLS) {
+                        Object.keys(sources).forEach(key => {
+                            if (tracks[key] && tracks[key].hls) sources[key] = tracks[key].hls;
+                        });
+                    }
+                    window.SonarePreviewKit.setTrackInfo(tracks);
                     const hasAny = Object.values(sources).some(Boolean);
 
                     if (typeof updateGlobalStatus === "function") {
@@ -740,6 +820,28 @@
             document.getElementById('legal-overlay').classList.remove('open');
         }
 
+        // 10) SECURITY: Delegated click handlers replace inline onclick
+        // attributes so the CSP can drop 'unsafe-inline' from script-src.
+        document.addEventListener('click', (e) => {
+            const scrollEl = e.target.closest('[data-scroll-to]');
+            if (scrollEl) {
+                const section = document.getElementById(scrollEl.dataset.scrollTo);
+                if (section) section.scrollIntoView({ behavior: 'smooth' });
+            }
+
+            const el = e.target.closest('[data-action]');
+            if (!el) return;
+            const { action, category, value } = el.dataset;
+            switch (action) {
+                case 'toggle-menu': toggleMobileMenu(); break;
+                case 'graph': switchGraph(value); break;
+                case 'select': selectOption({ target: el }, category, value); break;
+                case 'reset-quiz': resetQuiz(); break;
+                case 'open-legal': openLegal(value); break;
+                case 'close-legal': closeLegal(); break;
+            }
+        });
+
         // 3) ACCESSIBILITY: Escape Key Support
         document.addEventListener('keydown', (e) => {
             if (e.key === 'Escape') {
@@ -754,8 +856,44 @@
             }
         });
 
+        // --- PALETTE DISCOVERY ---
+        // The catalog is the source of truth for palettes: descriptions from
+        // /api/palettes replace the built-in copy, and palettes with tracks
+        // that the quiz does not know yet get their own VIBE option.
+        async function loadPalettes() {
+            let payload;
+            try {
+                const response = await fetch('/api/palettes', { headers: { 'Accept': 'application/json' } });
+                if (!response.ok) return;
+                payload = await response.json();
+            } catch (e) {
+                return;
+            }
+
+            const grid = document.querySelector('#q1 .option-grid');
+            (payload && Array.isArray(payload.palettes) ? payload.palettes : []).forEach(p => {
+                if (!p || !p.key) return;
+                const key = p.key.charAt(0).toUpperCase() + p.key.slice(1);
+                if (SELECTION[key]) {
+                    if (p.description) SELECTION[key] = { name: p.name, desc: p.description };
+                    return;
+                }
+                if (!Array.isArray(p.phases) || p.phases.length === 0 || !grid) return;
+
+                SELECTION[key] = { name: p.name, desc: p.description || '' };
+                const btn = document.createElement('button');
+                btn.className = 'option-btn';
+                btn.dataset.action = 'select';
+                btn.dataset.category = 'vibe';
+                btn.dataset.value = key;
+                btn.textContent = p.name;
+                grid.appendChild(btn);
+            });
+        }
+
         // --- INIT ---
         document.addEventListener("DOMContentLoaded", () => {
+            loadPalettes();
             document.getElementById('hours-input').addEventListener('input', updatePricingUI);
             document.getElementById('stores-input').addEventListener('input', updatePricingUI);
             updatePricingUI();